@@ -0,0 +1,230 @@
+// Package api serves the command database and the latest values over HTTP, and reads and writes single values on demand.
+package api
+
+import (
+	"context"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/flowcontrol"
+	"echoctl/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// refreshTimeout is the time to wait for the response to a read request.
+const refreshTimeout = 5 * time.Second
+
+// Value is a decoded value of a command.
+type Value struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+
+	// Value is a number, or the label of a value code.
+	Value  any          `json:"value"`
+	Raw    int16        `json:"raw"`
+	Unit   conf.Unit    `json:"unit"`
+	At     time.Time    `json:"at"`
+	Source store.Source `json:"source"`
+	Stale  bool         `json:"stale"`
+}
+
+type api struct {
+	commands    map[string]conf.Command
+	lang        string
+	values      store.Store
+	requester   can.Requester
+	allowWrites bool
+	log         *zap.Logger
+}
+
+// NewHandler creates the handler of the API below /api/:
+//
+//	GET /api/commands                    the command database, sorted by id
+//	GET /api/values                      the latest values by id
+//	GET /api/values/{id}[?refresh=true]  the latest value, or a fresh value read from the bus
+//	PUT /api/values/{id}                 writes {"value": …} to a writable command, and returns the value read back
+//
+// values are the latest values, names are in lang. Writes are rejected, unless allowWrites is set.
+func NewHandler(commands map[string]conf.Command, lang string, values store.Store, requester can.Requester, allowWrites bool, log *zap.Logger) http.Handler {
+	return &api{
+		commands:    commands,
+		lang:        lang,
+		values:      values,
+		requester:   requester,
+		allowWrites: allowWrites,
+		log:         log,
+	}
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/api/commands":
+		if allowMethods(w, r, http.MethodGet) {
+			a.getCommands(w)
+		}
+	case path == "/api/values":
+		if allowMethods(w, r, http.MethodGet) {
+			a.getValues(w)
+		}
+	case strings.HasPrefix(path, "/api/values/"):
+		if allowMethods(w, r, http.MethodGet, http.MethodPut) {
+			a.value(w, r, strings.TrimPrefix(path, "/api/values/"))
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found: %s", r.URL.Path)
+	}
+}
+
+func (a *api) getCommands(w http.ResponseWriter) {
+	ids := maps.Keys(a.commands)
+	slices.Sort(ids)
+	commands := make([]conf.Command, len(ids))
+	for i, id := range ids {
+		commands[i] = a.commands[id]
+	}
+	writeJson(w, http.StatusOK, commands)
+}
+
+func (a *api) getValues(w http.ResponseWriter) {
+	entries := a.values.All()
+	values := make(map[string]Value, len(entries))
+	for id, entry := range entries {
+		values[id] = ToValue(entry, a.lang)
+	}
+	writeJson(w, http.StatusOK, values)
+}
+
+func (a *api) value(w http.ResponseWriter, r *http.Request, id string) {
+	command, ok := a.commands[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown command %q", id)
+		return
+	}
+	if r.Method == http.MethodPut {
+		a.putValue(w, r, command)
+		return
+	}
+	if r.URL.Query().Get("refresh") == "true" {
+		a.refresh(w, r.Context(), command)
+		return
+	}
+	entry, ok := a.values.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no value received for %q yet, use ?refresh=true to read it", id)
+		return
+	}
+	writeJson(w, http.StatusOK, ToValue(entry, a.lang))
+}
+
+func (a *api) putValue(w http.ResponseWriter, r *http.Request, command conf.Command) {
+	if !a.allowWrites {
+		writeError(w, http.StatusForbidden, "writes are disabled, see http.allow-writes")
+		return
+	}
+	if !command.Writable {
+		writeError(w, http.StatusForbidden, "command %q is not writable", command.Id)
+		return
+	}
+	var body struct {
+		Value any `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "body: %v", err)
+		return
+	}
+	raw, err := command.Encode(body.Value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), refreshTimeout)
+	defer cancel()
+	if err := a.requester.Write(ctx, command, raw); err != nil {
+		a.writeSendError(w, command, err)
+		return
+	}
+	// Read the value back, to confirm the write.
+	a.refresh(w, ctx, command)
+}
+
+// refresh sends the read request of command, and waits for the response. Values received before the request was sent, but still queued for the store, are skipped.
+func (a *api) refresh(w http.ResponseWriter, ctx context.Context, command conf.Command) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	entries, unsubscribe := a.values.Subscribe()
+	defer unsubscribe()
+	sent := time.Now()
+	if err := a.requester.Read(ctx, command); err != nil {
+		a.writeSendError(w, command, err)
+		return
+	}
+
+	for {
+		select {
+		case entry := <-entries:
+			if entry.Command.Id == command.Id && !entry.At.Before(sent) {
+				writeJson(w, http.StatusOK, ToValue(entry, a.lang))
+				return
+			}
+		case <-ctx.Done():
+			writeError(w, http.StatusGatewayTimeout, "no response for %q within %v", command.Id, refreshTimeout)
+			return
+		}
+	}
+}
+
+func (a *api) writeSendError(w http.ResponseWriter, command conf.Command, err error) {
+	a.log.Warn("sending request", zap.String("command", command.Id), zap.Error(err))
+	if errors.Is(err, context.DeadlineExceeded) || flowcontrol.IsCanSkip(err) {
+		writeError(w, http.StatusServiceUnavailable, "sending request for %q: %v", command.Id, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, "sending request for %q: %v", command.Id, err)
+}
+
+// ToValue converts entry to a Value. The name is in lang.
+func ToValue(entry store.Entry, lang string) Value {
+	name, ok := entry.Command.Name[lang]
+	if !ok {
+		name = entry.Command.Id
+	}
+	return Value{
+		Id:     entry.Command.Id,
+		Name:   name,
+		Value:  entry.Value,
+		Raw:    entry.Raw,
+		Unit:   entry.Command.Unit,
+		At:     entry.At,
+		Source: entry.Source,
+		Stale:  entry.Stale,
+	}
+}
+
+// allowMethods returns true, if the method of r is one of methods. Otherwise, it responds with 405.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJson(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,221 @@
+package api_test
+
+import (
+	"context"
+	"echoctl/api"
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/store"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var commands = map[string]conf.Command{
+	"t_dhw":     {Id: "t_dhw", Name: map[string]string{"en": "DHW temperature"}, Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg},
+	"mode":      {Id: "mode", Type: conf.TypeValue, ValueCode: map[string]int{"standby": 0, "heating": 1}, Writable: true},
+	"t_dhw_set": {Id: "t_dhw_set", Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg, Writable: true},
+}
+
+func TestApi(t *testing.T) {
+	t.Run("lists the commands", func(t *testing.T) {
+		t.Parallel()
+
+		handler, _ := newHandler(true)
+		code, body := do(t, handler, http.MethodGet, "/api/commands", "")
+		assert.Equal(t, http.StatusOK, code)
+		var listed []conf.Command
+		assert.NoError(t, json.Unmarshal([]byte(body), &listed))
+		assert.Equal(t, []string{"mode", "t_dhw", "t_dhw_set"}, []string{listed[0].Id, listed[1].Id, listed[2].Id})
+	})
+
+	t.Run("returns the decoded values", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		bus.respond("t_dhw", 485)
+		bus.respond("mode", 1)
+
+		code, body := do(t, handler, http.MethodGet, "/api/values", "")
+		assert.Equal(t, http.StatusOK, code)
+		var values map[string]api.Value
+		assert.NoError(t, json.Unmarshal([]byte(body), &values))
+		assert.Equal(t, 48.5, values["t_dhw"].Value)
+		assert.Equal(t, "DHW temperature", values["t_dhw"].Name)
+		assert.Equal(t, conf.UnitDeg, values["t_dhw"].Unit)
+		assert.Equal(t, store.SourcePassive, values["t_dhw"].Source)
+		assert.Equal(t, "heating", values["mode"].Value)
+		assert.Equal(t, int16(1), values["mode"].Raw)
+	})
+
+	t.Run("returns not found for a value not received yet", func(t *testing.T) {
+		t.Parallel()
+
+		handler, _ := newHandler(true)
+		code, body := do(t, handler, http.MethodGet, "/api/values/t_dhw", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Contains(t, body, "refresh=true")
+
+		code, _ = do(t, handler, http.MethodGet, "/api/values/unknown", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("refreshes a value", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		bus.respond("t_dhw", 400)
+		bus.raw["t_dhw"] = 485
+
+		code, body := do(t, handler, http.MethodGet, "/api/values/t_dhw?refresh=true", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, `"value":48.5`)
+		assert.Contains(t, body, `"source":"poll"`)
+		assert.Equal(t, []string{"read t_dhw"}, bus.requests)
+	})
+
+	t.Run("skips values received before the request", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		bus.queued = map[string]int16{"t_dhw": 400, "t_dhw_set": 500}
+		bus.raw["t_dhw"] = 485
+
+		code, body := do(t, handler, http.MethodGet, "/api/values/t_dhw?refresh=true", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, `"value":48.5`)
+
+		code, body = do(t, handler, http.MethodPut, "/api/values/t_dhw_set", `{"value": 52.5}`)
+		assert.Equal(t, http.StatusOK, code, body)
+		assert.Contains(t, body, `"value":52.5`, "the value before the write is no read back")
+	})
+
+	t.Run("times out without response", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		bus.silent = true
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/values/t_dhw?refresh=true", nil).WithContext(ctx))
+		assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	})
+
+	t.Run("writes a value and reads it back", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		code, body := do(t, handler, http.MethodPut, "/api/values/t_dhw_set", `{"value": 52.5}`)
+		assert.Equal(t, http.StatusOK, code, body)
+		assert.Contains(t, body, `"value":52.5`)
+		assert.Contains(t, body, `"source":"write"`)
+		assert.Equal(t, []string{"write t_dhw_set 525", "read t_dhw_set"}, bus.requests)
+
+		code, body = do(t, handler, http.MethodPut, "/api/values/mode", `{"value": "standby"}`)
+		assert.Equal(t, http.StatusOK, code, body)
+		assert.Contains(t, body, `"value":"standby"`)
+	})
+
+	t.Run("rejects invalid writes", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(true)
+		for path, body := range map[string]string{
+			"/api/values/mode":      `{"value": "cooling"}`,
+			"/api/values/t_dhw_set": `{"value": "hot"}`,
+			"/api/values/t_dhw":     `{"value": 50}`,
+		} {
+			code, _ := do(t, handler, http.MethodPut, path, body)
+			assert.Contains(t, []int{http.StatusBadRequest, http.StatusForbidden}, code, path)
+		}
+		code, _ := do(t, handler, http.MethodPut, "/api/values/t_dhw_set", `{"value": 5000}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Empty(t, bus.requests)
+	})
+
+	t.Run("rejects writes, unless allowed", func(t *testing.T) {
+		t.Parallel()
+
+		handler, bus := newHandler(false)
+		code, body := do(t, handler, http.MethodPut, "/api/values/t_dhw_set", `{"value": 52.5}`)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, body, "allow-writes")
+		assert.Empty(t, bus.requests)
+	})
+
+	t.Run("rejects other methods", func(t *testing.T) {
+		t.Parallel()
+
+		handler, _ := newHandler(true)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/api/values/t_dhw", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Equal(t, "GET, PUT", recorder.Header().Get("Allow"))
+	})
+}
+
+// bus is a can.Requester, which answers read requests by storing the current raw value.
+type bus struct {
+	mutex    sync.Mutex
+	store    store.Store
+	raw      map[string]int16
+	requests []string
+	silent   bool
+
+	// queued is stored before the response of a read, as if it was received before the request.
+	queued map[string]int16
+}
+
+func newHandler(allowWrites bool) (http.Handler, *bus) {
+	b := &bus{store: store.NewStore(nil, nil), raw: make(map[string]int16)}
+	return api.NewHandler(commands, "en", b.store, b, allowWrites, zap.NewNop()), b
+}
+
+func (b *bus) respond(id string, raw int16) {
+	b.store.Put(dispatcher.CommandValue{Cmd: commands[id], Value: raw})
+}
+
+func (b *bus) Read(_ context.Context, command conf.Command) error {
+	b.mutex.Lock()
+	b.requests = append(b.requests, "read "+command.Id)
+	raw, silent := b.raw[command.Id], b.silent
+	queued, isQueued := b.queued[command.Id]
+	b.mutex.Unlock()
+	if isQueued {
+		b.store.Put(dispatcher.CommandValue{Cmd: command, Value: queued, At: time.Now().Add(-time.Second)})
+	}
+	b.store.RequestSent(command, false)
+	if !silent {
+		b.respond(command.Id, raw)
+	}
+	return nil
+}
+
+func (b *bus) Write(_ context.Context, command conf.Command, raw int16) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.requests = append(b.requests, fmt.Sprintf("write %s %d", command.Id, raw))
+	b.raw[command.Id] = raw
+	b.store.RequestSent(command, true)
+	return nil
+}
+
+func do(t *testing.T, handler http.Handler, method string, path string, body string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	buf, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, string(buf)
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"echoctl/api"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/dashboard"
+	"echoctl/dispatcher"
+	"echoctl/homeassistant"
+	"echoctl/influxdb"
+	"echoctl/metrics"
+	"echoctl/mqtt"
+	"echoctl/schedule"
+	"echoctl/status"
+	"echoctl/store"
+	"echoctl/supervise"
+	"echoctl/systemd"
+	"echoctl/web"
+	"fmt"
+	phaoMqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-daq/canbus"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"gopkg.in/tomb.v2"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Daemon wires the components of the echoctl daemon: Reader → Recorder → HealthMonitor → Dispatcher → sinks (Publisher, Poller, influxdb.Writer, store.Store of the latest values) with their own queues, the Poller requesting subscribed commands, the dashboard.Feed of received frames, the DiscoveryAnnouncer, the influxdb.Writer, the HTTP server with metrics, health, readiness, the API and the dashboard, and the systemd Notifier. The supervised components (conf.SupervisedComponents) are restarted according to their restart policies. The caller has to provide a can.Socket and a *zap.Logger. The bus state is published, if the socket is a can.BusStateSource.
+func Daemon(configuration conf.Configuration, commands map[string]conf.Command) fx.Option {
+	subscriptions, err := attachCommand(configuration.Subscriptions, commands)
+	if err != nil {
+		return fx.Error(err)
+	}
+
+	canReaderToRecorder := make(chan canbus.Frame, 10)
+	canRecorderToMonitor := make(chan canbus.Frame, 10)
+	canMonitorToDispatcher := make(chan canbus.Frame, 10)
+
+	return fx.Options(
+		fx.Provide(
+			func() metrics.Registry {
+				return metrics.NewRegistry(configuration.Lang)
+			},
+			func(registry metrics.Registry) metrics.Collector {
+				return registry
+			},
+			func(d dispatcher.Dispatcher) dashboard.Feed {
+				feed := dashboard.NewFeed()
+				d.Listen(feed)
+				return feed
+			},
+			func(d dispatcher.Dispatcher) store.Store {
+				sink := d.Register("store", configuration.Dispatch.Policy("store"))
+				return store.NewStore(pollIntervals(subscriptions), sink.Values())
+			},
+			func(socket can.Socket, d dispatcher.Dispatcher, collector metrics.Collector, values store.Store, log *zap.Logger) can.Poller {
+				sink := d.Register("poller", configuration.Dispatch.Policy("poller"))
+				return can.NewPoller(socket, subscriptions, sink.Values(), schedule.NewScheduler[can.Subscription](), collector, values, log.Named("poller"))
+			},
+			func(collector metrics.Collector, log *zap.Logger) dispatcher.Dispatcher {
+				return dispatcher.NewDispatcher(canMonitorToDispatcher, maps.Values(commands), collector, log.Named("disp"))
+			},
+			func(log *zap.Logger) (phaoMqtt.Client, error) {
+				return mqtt.NewClient(configuration.Mqtt.Server, configuration.Mqtt.ClientId, configuration.Mqtt.User, configuration.Mqtt.Password, log.Named("mqtt"), nil)
+			},
+			func(client phaoMqtt.Client, d dispatcher.Dispatcher, collector metrics.Collector, log *zap.Logger) mqtt.Publisher {
+				sink := d.Register("mqtt", configuration.Dispatch.Policy("mqtt"))
+				return mqtt.NewPublisher(configuration.Mqtt.ValueTopicPrefix, sink.Values(), client, collector, log.Named("publ"))
+			},
+			func(client phaoMqtt.Client, d dispatcher.Dispatcher, log *zap.Logger) mqtt.UnknownPublisher {
+				return mqtt.NewUnknownPublisher(configuration.Mqtt.ValueTopicPrefix, configuration.UnknownCommands.PublishInterval, d, client, log.Named("unkn"))
+			},
+			func(socket can.Socket, client phaoMqtt.Client, log *zap.Logger) mqtt.BusStatePublisher {
+				var states <-chan can.BusState
+				if source, ok := socket.(can.BusStateSource); ok {
+					states = source.BusStates()
+				}
+				return mqtt.NewBusStatePublisher(configuration.Mqtt.ValueTopicPrefix, states, client, log.Named("bus"))
+			},
+			func(client phaoMqtt.Client, log *zap.Logger) homeassistant.DiscoveryAnnouncer {
+				return homeassistant.NewDiscoveryAnnouncer(subscriptions, configuration.Homeassistant.DiscoveryTopicPrefix, mqtt.HealthTopic(configuration.Mqtt.ValueTopicPrefix), configuration.Lang, client, log.Named("anou"))
+			},
+			func(socket can.Socket, log *zap.Logger) can.Reader {
+				return can.NewReader(socket, canReaderToRecorder, log.Named("reader"))
+			},
+			func(log *zap.Logger) can.Recorder {
+				return can.NewRecorder(configuration.Can.Record, configuration.Can.Iface, canReaderToRecorder, canRecorderToMonitor, log.Named("rec"))
+			},
+			func(socket can.Socket, log *zap.Logger) can.HealthMonitor {
+				var states <-chan can.BusState
+				if source, ok := socket.(can.BusStateSource); ok {
+					states = source.BusStates()
+				}
+				return can.NewHealthMonitor(canRecorderToMonitor, canMonitorToDispatcher, states, log.Named("health"))
+			},
+			func(monitor can.HealthMonitor, client phaoMqtt.Client, log *zap.Logger) mqtt.HealthPublisher {
+				return mqtt.NewHealthPublisher(configuration.Mqtt.ValueTopicPrefix, monitor.Healths(), client, log.Named("hlth"))
+			},
+			func(socket can.Socket, collector metrics.Collector, values store.Store, log *zap.Logger) can.Requester {
+				return can.NewRequester(socket, collector, values, log.Named("req"))
+			},
+			func(registry metrics.Registry, values store.Store, requester can.Requester, feed dashboard.Feed, log *zap.Logger) *http.ServeMux {
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", registry)
+				mux.Handle("/api/", api.NewHandler(commands, configuration.Lang, values, requester, configuration.Http.AllowWrites, log.Named("api")))
+				mux.Handle("/dashboard/", dashboard.NewHandler(subscriptions, configuration.Lang, values, configuration.Http.AllowWrites, feed, log.Named("dash")))
+				return mux
+			},
+			func(d dispatcher.Dispatcher, log *zap.Logger) influxdb.Writer {
+				var inbound <-chan dispatcher.CommandValue
+				if configuration.Influxdb.Enabled() {
+					inbound = d.Register("influxdb", configuration.Dispatch.Policy("influxdb")).Values()
+				}
+				return influxdb.NewWriter(configuration.Influxdb, configuration.Lang, inbound, log.Named("infl"))
+			},
+			func(mux *http.ServeMux, log *zap.Logger) web.Server {
+				return web.NewServer(configuration.Http.Listen, mux, log.Named("http"))
+			},
+		),
+
+		fx.Invoke(func(publisher mqtt.Publisher, unknownPublisher mqtt.UnknownPublisher, busStatePublisher mqtt.BusStatePublisher, healthPublisher mqtt.HealthPublisher, poller can.Poller, dispatcher dispatcher.Dispatcher, reader can.Reader, recorder can.Recorder, monitor can.HealthMonitor, shutdowner fx.Shutdowner, discoveryAnnouncer homeassistant.DiscoveryAnnouncer, influxWriter influxdb.Writer, server web.Server, values store.Store, mux *http.ServeMux, lc fx.Lifecycle, client phaoMqtt.Client, socket can.Socket, log *zap.Logger) {
+			// Hooks are stopped in reverse order. Appending this hook before daemonize() writes the file after all components stopped.
+			writeUnknownCommandsOnStop(lc, configuration.UnknownCommands.File, dispatcher, log)
+			supervised := func(name string, start func() *tomb.Tomb) *tomb.Tomb {
+				return supervise.NewSupervisor(name, start, configuration.Supervision.Policy(name), log.Named("supr")).Supervise()
+			}
+			components := []status.Component{
+				{Name: "publisher", Tomb: supervised("publisher", publisher.Publish)},
+				{Name: "unknown-publisher", Tomb: supervised("unknown-publisher", unknownPublisher.Publish)},
+				{Name: "bus-state-publisher", Tomb: supervised("bus-state-publisher", busStatePublisher.Publish)},
+				{Name: "health-publisher", Tomb: supervised("health-publisher", healthPublisher.Publish)},
+				{Name: "poller", Tomb: supervised("poller", poller.Poll)},
+				{Name: "dispatcher", Tomb: supervised("dispatcher", dispatcher.Dispatch)},
+				{Name: "reader", Tomb: supervised("reader", reader.Read)},
+				{Name: "recorder", Tomb: supervised("recorder", recorder.Record)},
+				{Name: "health-monitor", Tomb: supervised("health-monitor", monitor.Monitor)},
+				{Name: "discovery", Tomb: supervised("discovery", discoveryAnnouncer.Announce)},
+				{Name: "influxdb", Tomb: supervised("influxdb", influxWriter.Write)},
+				{Name: "store", Tomb: supervised("store", values.Record)},
+			}
+			componentStatus := status.NewStatus(components, probes(socket, monitor, client, dispatcher, influxWriter), subscribedIds(subscriptions), lastValues(values), configuration.Http.MaxSilence)
+			mux.Handle("/healthz", componentStatus.Health())
+			mux.Handle("/readyz", componentStatus.Readiness())
+			notifier := systemd.NewNotifier(os.Environ(), componentStatus.Report, configuration.Http.MaxSilence, log.Named("sd"))
+			daemonize(
+				lc,
+				shutdowner,
+				fx.DefaultTimeout,
+				log,
+				client,
+				socket,
+				append(status.Tombs(components), supervised("http", server.Serve), notifier.Notify())...,
+			)
+		}),
+	)
+}
+
+func attachCommand(subscriptions []conf.Subscription, commands map[string]conf.Command) ([]can.Subscription, error) {
+	result := make([]can.Subscription, len(subscriptions))
+	for i := range subscriptions {
+		var ok bool
+		result[i].Command, ok = commands[subscriptions[i].Command]
+		if !ok {
+			return nil, fmt.Errorf("error parsing configuration file: command '%s' not found in the command database", subscriptions[i].Command)
+		}
+		result[i].Delay = subscriptions[i].Delay
+	}
+
+	return result, nil
+}
+
+// probes reports the CAN socket, the bus health, the MQTT connection, the queues of the sinks of d, and writing to InfluxDB. The socket is only probed, if it is a can.BusStateSource. Sinks dropping values and failing writes to InfluxDB do not affect readiness.
+func probes(socket can.Socket, monitor can.HealthMonitor, client phaoMqtt.Client, d dispatcher.Dispatcher, influxWriter influxdb.Writer) []status.Probe {
+	var result []status.Probe
+	if source, ok := socket.(can.BusStateSource); ok {
+		result = append(result, status.Probe{Name: "can-socket", State: func() (string, bool) {
+			state := source.BusState()
+			return state.String(), state == can.BusUp
+		}})
+	}
+	for _, sink := range d.Sinks() {
+		sink := sink
+		result = append(result, status.Probe{Name: "sink-" + sink.Name(), State: func() (string, bool) {
+			return sink.Health().State(), true
+		}})
+	}
+	return append(result,
+		status.Probe{Name: "can-bus", State: func() (string, bool) {
+			state := monitor.Health().State
+			return state, state != can.StateBusOff
+		}},
+		status.Probe{Name: "mqtt", State: func() (string, bool) {
+			if client.IsConnectionOpen() {
+				return "connected", true
+			}
+			return "disconnected", false
+		}},
+		status.Probe{Name: "influxdb", State: func() (string, bool) {
+			return influxWriter.State(), true
+		}},
+	)
+}
+
+// lastValues returns the times of the latest values.
+func lastValues(values store.Store) func() map[string]time.Time {
+	return func() map[string]time.Time {
+		entries := values.All()
+		result := make(map[string]time.Time, len(entries))
+		for id, entry := range entries {
+			result[id] = entry.At
+		}
+		return result
+	}
+}
+
+// pollIntervals returns the poll intervals of the subscribed commands by id.
+func pollIntervals(subscriptions []can.Subscription) map[string]time.Duration {
+	result := make(map[string]time.Duration, len(subscriptions))
+	for _, s := range subscriptions {
+		result[s.Command.Id] = s.Delay
+	}
+	return result
+}
+
+func subscribedIds(subscriptions []can.Subscription) []string {
+	result := make([]string, len(subscriptions))
+	for i, s := range subscriptions {
+		result[i] = s.Command.Id
+	}
+	return result
+}
+
+func writeUnknownCommandsOnStop(lc fx.Lifecycle, fileName string, d dispatcher.Dispatcher, log *zap.Logger) {
+	if fileName == "" {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			if err := dispatcher.WriteUnknownCommandsFile(fileName, d.UnknownCommands()); err != nil {
+				log.Error("writing unknown commands", zap.String("file", fileName), zap.Error(err))
+			}
+			return nil
+		},
+	})
+}
@@ -1,4 +1,4 @@
-package main
+package app
 
 import (
 	"context"
@@ -15,11 +15,18 @@ import (
 )
 
 func daemonize(lc fx.Lifecycle, shutdowner fx.Shutdowner, stopTimeout time.Duration, log *zap.Logger, client phaoMqtt.Client, socket can.Socket, tombs ...*tomb.Tomb) {
+	RunTombs(lc, shutdowner, stopTimeout, log, func(ctx context.Context) {
+		client.Disconnect(mqtt.GetQuiesce(ctx))
+		_ = socket.Close()
+	}, tombs...)
+}
+
+// RunTombs shuts the app down, when one of tombs dies. On stop, it kills all tombs and calls closeResources after they died.
+func RunTombs(lc fx.Lifecycle, shutdowner fx.Shutdowner, stopTimeout time.Duration, log *zap.Logger, closeResources func(ctx context.Context), tombs ...*tomb.Tomb) {
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
 			killAllAndWait(ctx, tombs)
-			client.Disconnect(mqtt.GetQuiesce(ctx))
-			_ = socket.Close()
+			closeResources(ctx)
 			return nil
 		},
 	})
@@ -38,9 +45,8 @@ func shutdownOnDeath(tombs []*tomb.Tomb, log *zap.Logger, stopTimeout time.Durat
 	defer killCtxCancel()
 	killAllAndWait(killCtx, tombs)
 
-	// Avoid double-printing error
-	tombs = slices.Delete(tombs, diedIdx, diedIdx+1)
-	printTombErrors(tombs, log)
+	// Avoid double-printing error. Delete from a copy, the stop hook still iterates tombs.
+	printTombErrors(slices.Delete(slices.Clone(tombs), diedIdx, diedIdx+1), log)
 
 	err := shutdowner.Shutdown()
 	if err != nil {
@@ -0,0 +1,90 @@
+package can
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatCandump formats frame as a line of the `candump -L` log format, without trailing newline:
+//
+//	(1670790000.123456) can0 180#3210FA01120B00
+func FormatCandump(at time.Time, iface string, frame canbus.Frame) string {
+	var id string
+	switch frame.Kind {
+	case canbus.EFF:
+		id = fmt.Sprintf("%08X", frame.ID&unix.CAN_EFF_MASK)
+	case canbus.ERR:
+		id = fmt.Sprintf("%08X", frame.ID&unix.CAN_ERR_MASK|unix.CAN_ERR_FLAG)
+	default:
+		id = fmt.Sprintf("%03X", frame.ID&unix.CAN_SFF_MASK)
+	}
+
+	var data string
+	if frame.Kind == canbus.RTR {
+		data = "R"
+	} else {
+		data = strings.ToUpper(hex.EncodeToString(frame.Data))
+	}
+	return fmt.Sprintf("(%d.%06d) %s %s#%s", at.Unix(), at.Nanosecond()/1000, iface, id, data)
+}
+
+// ParseCandump parses a line of the `candump -L` log format, as written by FormatCandump. It returns the frame, its timestamp, and the interface name. CAN FD frames are not supported.
+func ParseCandump(line string) (frame canbus.Frame, at time.Time, iface string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return frame, at, iface, fmt.Errorf("malformed candump line %q", line)
+	}
+
+	at, err = parseCandumpTimestamp(strings.Trim(fields[0], "()"))
+	if err != nil {
+		return frame, at, iface, fmt.Errorf("malformed timestamp in %q: %w", line, err)
+	}
+	iface = fields[1]
+
+	idPart, dataPart, ok := strings.Cut(fields[2], "#")
+	if !ok || strings.HasPrefix(dataPart, "#") {
+		return frame, at, iface, fmt.Errorf("unsupported frame in %q", line)
+	}
+	id, err := strconv.ParseUint(idPart, 16, 32)
+	if err != nil {
+		return frame, at, iface, fmt.Errorf("malformed CAN ID in %q: %w", line, err)
+	}
+	switch {
+	case len(idPart) == 3:
+		frame.ID, frame.Kind = uint32(id), canbus.SFF
+	case uint32(id)&unix.CAN_ERR_FLAG != 0:
+		frame.ID, frame.Kind = uint32(id)&unix.CAN_ERR_MASK, canbus.ERR
+	default:
+		frame.ID, frame.Kind = uint32(id)&unix.CAN_EFF_MASK, canbus.EFF
+	}
+
+	if strings.HasPrefix(dataPart, "R") {
+		frame.Kind = canbus.RTR
+		return frame, at, iface, nil
+	}
+	frame.Data, err = hex.DecodeString(dataPart)
+	if err != nil {
+		return frame, at, iface, fmt.Errorf("malformed data in %q: %w", line, err)
+	}
+	return frame, at, iface, nil
+}
+
+func parseCandumpTimestamp(s string) (time.Time, error) {
+	secPart, usecPart, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var usec int64
+	if usecPart != "" {
+		if usec, err = strconv.ParseInt(usecPart, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, usec*1000), nil
+}
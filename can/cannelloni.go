@@ -0,0 +1,173 @@
+package can
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"net"
+	"sync"
+)
+
+// Cannelloni packet format, see https://github.com/mguentner/cannelloni.
+const (
+	cannelloniVersion      = 2
+	cannelloniOpData       = 0
+	cannelloniHeaderLength = 5
+	cannelloniMaxPacket    = 1500
+	cannelloniCanFdFlag    = 0x80
+)
+
+type cannelloniSocket struct {
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+	inbox  *inbox
+	mutex  sync.Mutex
+	seqNo  uint8
+	log    *zap.Logger
+}
+
+var _ Socket = (*cannelloniSocket)(nil)
+
+// NewCannelloniSocket exchanges frames with the cannelloni peer at remote (host:port) over UDP. It receives on local ([host]:port). Cannelloni is connectionless, so a missing peer is not detected.
+func NewCannelloniSocket(remote string, local string, log *zap.Logger) (Socket, error) {
+	remoteAddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni remote %q: %w", remote, err)
+	}
+	localAddr, err := net.ResolveUDPAddr("udp", local)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni local %q: %w", local, err)
+	}
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni: %w", err)
+	}
+	s := &cannelloniSocket{
+		conn:   conn,
+		remote: remoteAddr,
+		inbox:  newInbox(),
+		log:    log,
+	}
+	go s.receive()
+	return s, nil
+}
+
+func (s *cannelloniSocket) Close() error {
+	s.inbox.close()
+	return s.conn.Close()
+}
+
+func (s *cannelloniSocket) Send(msg canbus.Frame) (int, error) {
+	s.mutex.Lock()
+	seqNo := s.seqNo
+	s.seqNo++
+	s.mutex.Unlock()
+
+	packet := []byte{cannelloniVersion, cannelloniOpData, seqNo}
+	packet = binary.BigEndian.AppendUint16(packet, 1)
+	packet = appendCannelloniFrame(packet, msg)
+	if _, err := s.conn.WriteToUDP(packet, s.remote); err != nil {
+		return 0, err
+	}
+	return len(msg.Data), nil
+}
+
+func (s *cannelloniSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	return s.inbox.recv(ctx)
+}
+
+func (s *cannelloniSocket) receive() {
+	buffer := make([]byte, cannelloniMaxPacket)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !s.inbox.isClosed() {
+				s.inbox.fail(fmt.Errorf("cannelloni: %w", err))
+			}
+			return
+		}
+		frames, err := parseCannelloniPacket(buffer[:n])
+		if err != nil {
+			s.log.Warn("cannelloni: dropping malformed packet", zap.Error(err))
+			continue
+		}
+		for _, frame := range frames {
+			if !s.inbox.put(frame) {
+				return
+			}
+		}
+	}
+}
+
+func appendCannelloniFrame(packet []byte, frame canbus.Frame) []byte {
+	id := frame.ID
+	switch frame.Kind {
+	case canbus.EFF:
+		id = id&unix.CAN_EFF_MASK | unix.CAN_EFF_FLAG
+	case canbus.RTR:
+		id = id&unix.CAN_SFF_MASK | unix.CAN_RTR_FLAG
+	case canbus.ERR:
+		id = id&unix.CAN_ERR_MASK | unix.CAN_ERR_FLAG
+	default:
+		id &= unix.CAN_SFF_MASK
+	}
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = append(packet, byte(len(frame.Data)))
+	if frame.Kind == canbus.RTR {
+		// Remote frames carry the DLC, but no data.
+		return packet
+	}
+	return append(packet, frame.Data...)
+}
+
+func parseCannelloniPacket(packet []byte) ([]canbus.Frame, error) {
+	if len(packet) < cannelloniHeaderLength {
+		return nil, errors.New("packet too short")
+	}
+	if packet[0] != cannelloniVersion {
+		return nil, fmt.Errorf("unsupported version %d", packet[0])
+	}
+	if packet[1] != cannelloniOpData {
+		// ACK and NACK are not used.
+		return nil, nil
+	}
+	count := int(binary.BigEndian.Uint16(packet[3:5]))
+	rest := packet[cannelloniHeaderLength:]
+	frames := make([]canbus.Frame, 0, count)
+	for i := 0; i < count; i++ {
+		if len(rest) < 5 {
+			return nil, fmt.Errorf("frame %d truncated", i)
+		}
+		id := binary.BigEndian.Uint32(rest)
+		length := rest[4]
+		rest = rest[5:]
+		if length&cannelloniCanFdFlag != 0 {
+			return nil, fmt.Errorf("frame %d: CAN FD is not supported", i)
+		}
+		var frame canbus.Frame
+		// Remote frames carry the DLC, but no data.
+		if id&unix.CAN_RTR_FLAG == 0 {
+			if len(rest) < int(length) {
+				return nil, fmt.Errorf("frame %d truncated", i)
+			}
+			frame.Data = append([]byte(nil), rest[:length]...)
+			rest = rest[length:]
+		}
+		switch {
+		case id&unix.CAN_ERR_FLAG != 0:
+			frame.ID, frame.Kind = id&unix.CAN_ERR_MASK, canbus.ERR
+		case id&unix.CAN_EFF_FLAG != 0:
+			frame.ID, frame.Kind = id&unix.CAN_EFF_MASK, canbus.EFF
+		case id&unix.CAN_RTR_FLAG != 0:
+			frame.ID, frame.Kind = id&unix.CAN_SFF_MASK, canbus.RTR
+		default:
+			frame.ID, frame.Kind = id&unix.CAN_SFF_MASK, canbus.SFF
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
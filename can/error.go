@@ -15,3 +15,26 @@ func (s sendBufferFullError) ShouldRetry() bool {
 func (s sendBufferFullError) Error() string {
 	return "socket send buffer full"
 }
+
+// busDownError is returned by RecoveringSocket.Send while the socket is reopened. The frame is lost, the poller sends it again once the bus is up.
+type busDownError struct {
+	cause error
+}
+
+var _ flowcontrol.CanSkip = busDownError{}
+var _ error = busDownError{}
+
+func (b busDownError) CanSkip() bool {
+	return true
+}
+
+func (b busDownError) Error() string {
+	if b.cause == nil {
+		return "CAN bus down"
+	}
+	return "CAN bus down: " + b.cause.Error()
+}
+
+func (b busDownError) Unwrap() error {
+	return b.cause
+}
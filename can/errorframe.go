@@ -0,0 +1,113 @@
+package can
+
+import (
+	"fmt"
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+// canErrCnt flags error frames carrying the TX/RX error counters in data[6] and data[7]. Missing in x/sys/unix.
+const canErrCnt = 0x200
+
+// ErrorFrame is a decoded CAN error frame, see linux/can/error.h.
+type ErrorFrame struct {
+	// Class is the error class, the bit mask in the CAN ID.
+	Class uint32
+
+	// ControllerStatus is data[1], the CAN_ERR_CRTL_* bits of controller problems.
+	ControllerStatus byte
+
+	// ProtocolType and ProtocolLocation are data[2] and data[3], describing protocol violations.
+	ProtocolType     byte
+	ProtocolLocation byte
+
+	// Transceiver is data[4], the CAN_ERR_TRX_* status of the transceiver.
+	Transceiver byte
+
+	// TxErrors and RxErrors are the error counters of the controller. Only valid, if HasCounters is set.
+	TxErrors    uint8
+	RxErrors    uint8
+	HasCounters bool
+}
+
+// DecodeErrorFrame decodes frame. Returns false, if frame is no error frame.
+func DecodeErrorFrame(frame canbus.Frame) (ErrorFrame, bool) {
+	if frame.Kind != canbus.ERR {
+		return ErrorFrame{}, false
+	}
+	data := make([]byte, unix.CAN_ERR_DLC)
+	copy(data, frame.Data)
+	e := ErrorFrame{
+		Class:            frame.ID & unix.CAN_ERR_MASK,
+		ControllerStatus: data[1],
+		ProtocolType:     data[2],
+		ProtocolLocation: data[3],
+		Transceiver:      data[4],
+	}
+	if e.Class&canErrCnt != 0 {
+		e.TxErrors, e.RxErrors, e.HasCounters = data[6], data[7], true
+	}
+	return e, true
+}
+
+func (e ErrorFrame) Is(class uint32) bool {
+	return e.Class&class != 0
+}
+
+// Problems describes the problems reported by the error frame.
+func (e ErrorFrame) Problems() []string {
+	var problems []string
+	if e.Is(unix.CAN_ERR_TX_TIMEOUT) {
+		problems = append(problems, "TX timeout")
+	}
+	if e.Is(unix.CAN_ERR_LOSTARB) {
+		problems = append(problems, "lost arbitration")
+	}
+	if e.Is(unix.CAN_ERR_CRTL) {
+		problems = append(problems, e.controllerProblems()...)
+	}
+	if e.Is(unix.CAN_ERR_PROT) {
+		problems = append(problems, fmt.Sprintf("protocol violation (type 0x%02X, location 0x%02X)", e.ProtocolType, e.ProtocolLocation))
+	}
+	if e.Is(unix.CAN_ERR_TRX) {
+		problems = append(problems, fmt.Sprintf("transceiver problem (0x%02X)", e.Transceiver))
+	}
+	if e.Is(unix.CAN_ERR_ACK) {
+		problems = append(problems, "no ACK on transmission")
+	}
+	if e.Is(unix.CAN_ERR_BUSOFF) {
+		problems = append(problems, "bus-off")
+	}
+	if e.Is(unix.CAN_ERR_BUSERROR) {
+		problems = append(problems, "bus error")
+	}
+	if e.Is(unix.CAN_ERR_RESTARTED) {
+		problems = append(problems, "controller restarted")
+	}
+	return problems
+}
+
+func (e ErrorFrame) controllerProblems() []string {
+	names := []struct {
+		bit  byte
+		name string
+	}{
+		{unix.CAN_ERR_CRTL_RX_OVERFLOW, "RX buffer overflow"},
+		{unix.CAN_ERR_CRTL_TX_OVERFLOW, "TX buffer overflow"},
+		{unix.CAN_ERR_CRTL_RX_WARNING, "RX error warning"},
+		{unix.CAN_ERR_CRTL_TX_WARNING, "TX error warning"},
+		{unix.CAN_ERR_CRTL_RX_PASSIVE, "RX error passive"},
+		{unix.CAN_ERR_CRTL_TX_PASSIVE, "TX error passive"},
+		{unix.CAN_ERR_CRTL_ACTIVE, "back to error active"},
+	}
+	var problems []string
+	for _, n := range names {
+		if e.ControllerStatus&n.bit != 0 {
+			problems = append(problems, n.name)
+		}
+	}
+	if len(problems) == 0 {
+		problems = append(problems, "controller problem")
+	}
+	return problems
+}
@@ -0,0 +1,29 @@
+package can
+
+import (
+	"echoctl/conf"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+)
+
+// ResponseFilters returns kernel receive filters, passing the standard frames with the response CAN IDs of commands (0x180, 0x300, ...). Commands without a response, like t_room, are skipped.
+func ResponseFilters(commands []conf.Command) []unix.CanFilter {
+	var ids []uint32
+	for i := range commands {
+		if len(commands[i].Response.CommandBytes) == 0 {
+			continue
+		}
+		id := uint32(commands[i].Response.CanId)
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	filters := make([]unix.CanFilter, len(ids))
+	for i, id := range ids {
+		// Including the EFF and RTR flags in the mask excludes extended and remote frames with the same ID.
+		filters[i] = unix.CanFilter{Id: id, Mask: unix.CAN_SFF_MASK | unix.CAN_EFF_FLAG | unix.CAN_RTR_FLAG}
+	}
+	return filters
+}
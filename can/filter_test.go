@@ -0,0 +1,36 @@
+package can_test
+
+import (
+	"echoctl/can"
+	"echoctl/conf"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func TestResponseFilters(t *testing.T) {
+	t.Run("one filter per response CAN ID", func(t *testing.T) {
+		t.Parallel()
+
+		commands := []conf.Command{
+			{Id: "a", Response: conf.RequestCommand{CanId: 0x300, CommandBytes: conf.CommandBytes{0x62, 0x0A, 0x0E}}},
+			{Id: "b", Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}}},
+			{Id: "c", Response: conf.RequestCommand{CanId: 0x300, CommandBytes: conf.CommandBytes{0x62, 0x0A, 0x0F}}},
+		}
+		mask := uint32(unix.CAN_SFF_MASK | unix.CAN_EFF_FLAG | unix.CAN_RTR_FLAG)
+		assert.Equal(t, []unix.CanFilter{{Id: 0x180, Mask: mask}, {Id: 0x300, Mask: mask}}, can.ResponseFilters(commands))
+	})
+
+	t.Run("skips commands without a response", func(t *testing.T) {
+		t.Parallel()
+
+		commands := []conf.Command{
+			{Id: "t_room", Request: conf.RequestCommand{CanId: 0x680, CommandBytes: conf.CommandBytes{0x60, 0x79, 0x00}}},
+			{Id: "t_dhw", Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}}},
+		}
+		filters := can.ResponseFilters(commands)
+		if assert.Len(t, filters, 1, "CAN ID 0 should not pass") {
+			assert.Equal(t, uint32(0x180), filters[0].Id)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package can
+
+import (
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"gopkg.in/tomb.v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error states of the CAN controller.
+const (
+	StateErrorActive  = "error-active"
+	StateErrorWarning = "error-warning"
+	StateErrorPassive = "error-passive"
+	StateBusOff       = "bus-off"
+)
+
+// Error counter thresholds of the CAN specification.
+const (
+	warningErrorCount = 96
+	passiveErrorCount = 128
+)
+
+// Health is the bus health, accumulated from the received error frames.
+type Health struct {
+	State              string     `json:"state"`
+	TxErrors           uint8      `json:"tx_errors"`
+	RxErrors           uint8      `json:"rx_errors"`
+	ErrorFrames        uint64     `json:"error_frames"`
+	BusOff             uint64     `json:"bus_off"`
+	NoAck              uint64     `json:"no_ack"`
+	ControllerProblems uint64     `json:"controller_problems"`
+	ProtocolViolations uint64     `json:"protocol_violations"`
+	LastError          string     `json:"last_error,omitempty"`
+	LastErrorAt        *time.Time `json:"last_error_at,omitempty"`
+}
+
+type healthMonitor struct {
+	inbound      <-chan canbus.Frame
+	toDispatcher chan<- canbus.Frame
+	states       <-chan BusState
+	mutex        sync.Mutex
+	health       Health
+	healths      chan Health
+	tomb         *tomb.Tomb
+	log          *zap.Logger
+}
+
+// The HealthMonitor sits between Recorder and Dispatcher. It decodes error frames into the bus Health, and passes all other frames on to the Dispatcher. A reopened socket resets the Health to error-active.
+type HealthMonitor interface {
+	// Monitor starts monitoring. It can be called again after the returned tomb died, to restart monitoring. The Health is kept.
+	Monitor() *tomb.Tomb
+
+	// Health returns the current bus health.
+	Health() Health
+
+	// Healths returns a channel receiving the latest bus health after each error frame and reset. Intermediate values are dropped, if the receiver is slow.
+	Healths() <-chan Health
+}
+
+var _ HealthMonitor = (*healthMonitor)(nil)
+
+// NewHealthMonitor creates a HealthMonitor. states are the bus states of the socket, nil if it does not reopen, see BusStateSource.
+func NewHealthMonitor(inbound <-chan canbus.Frame, toDispatcher chan<- canbus.Frame, states <-chan BusState, log *zap.Logger) HealthMonitor {
+	m := &healthMonitor{
+		inbound:      inbound,
+		toDispatcher: toDispatcher,
+		states:       states,
+		health:       Health{State: StateErrorActive},
+		healths:      make(chan Health, 1),
+		log:          log,
+	}
+	offerLatest(m.healths, m.health)
+	return m
+}
+
+func (m *healthMonitor) Monitor() *tomb.Tomb {
+	m.tomb = new(tomb.Tomb)
+	m.tomb.Go(m.monitor)
+	return m.tomb
+}
+
+func (m *healthMonitor) Health() Health {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.health
+}
+
+func (m *healthMonitor) Healths() <-chan Health {
+	return m.healths
+}
+
+func (m *healthMonitor) monitor() error {
+	for {
+		select {
+		case frame := <-m.inbound:
+			if errorFrame, ok := DecodeErrorFrame(frame); ok {
+				m.update(errorFrame, time.Now())
+				continue
+			}
+			select {
+			case m.toDispatcher <- frame:
+			case <-m.tomb.Dying():
+				return tomb.ErrDying
+			}
+		case state := <-m.states:
+			if state == BusUp {
+				m.reset()
+			}
+		case <-m.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (m *healthMonitor) update(e ErrorFrame, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h := &m.health
+	previous := h.State
+
+	h.ErrorFrames++
+	if e.Is(unix.CAN_ERR_ACK) {
+		h.NoAck++
+	}
+	if e.Is(unix.CAN_ERR_PROT) {
+		h.ProtocolViolations++
+	}
+	if e.HasCounters {
+		h.TxErrors, h.RxErrors = e.TxErrors, e.RxErrors
+	}
+	switch {
+	case e.Is(unix.CAN_ERR_BUSOFF):
+		h.BusOff++
+		h.State = StateBusOff
+	case e.Is(unix.CAN_ERR_RESTARTED):
+		h.State = StateErrorActive
+	case e.Is(unix.CAN_ERR_CRTL):
+		h.ControllerProblems++
+		h.State = controllerState(e.ControllerStatus, h.State)
+	case e.HasCounters:
+		// Only counters below the warning threshold tell, that the controller left bus-off.
+		if state := counterState(e.TxErrors, e.RxErrors); h.State != StateBusOff || state == StateErrorActive {
+			h.State = state
+		}
+	}
+	h.LastError = strings.Join(e.Problems(), ", ")
+	h.LastErrorAt = &at
+
+	// Error frames come in bursts, f.e. thousands per second without ACK. Only state changes are logged above debug.
+	fields := []zap.Field{zap.String("problems", h.LastError), zap.String("state", h.State), zap.Uint8("tx_errors", h.TxErrors), zap.Uint8("rx_errors", h.RxErrors)}
+	switch {
+	case h.State == previous:
+		m.log.Debug("CAN error frame", fields...)
+	case h.State == StateErrorActive:
+		m.log.Info("CAN bus state changed", append(fields, zap.String("previous", previous))...)
+	default:
+		m.log.Warn("CAN bus state changed", append(fields, zap.String("previous", previous))...)
+	}
+	offerLatest(m.healths, *h)
+}
+
+// reset sets the health to error-active with cleared error counters, after the socket was reopened. The controller was restarted, but the RESTARTED error frame is not received by the new socket.
+func (m *healthMonitor) reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h := &m.health
+	if h.State == StateErrorActive && h.TxErrors == 0 && h.RxErrors == 0 {
+		return
+	}
+	m.log.Info("CAN bus state changed", zap.String("state", StateErrorActive), zap.String("previous", h.State), zap.String("cause", "socket reopened"))
+	h.State, h.TxErrors, h.RxErrors = StateErrorActive, 0, 0
+	offerLatest(m.healths, *h)
+}
+
+// controllerState derives the error state from the CAN_ERR_CRTL_* status bits. Keeps the current state, if the status is unspecified.
+func controllerState(status byte, current string) string {
+	switch {
+	case status&(unix.CAN_ERR_CRTL_RX_PASSIVE|unix.CAN_ERR_CRTL_TX_PASSIVE) != 0:
+		return StateErrorPassive
+	case status&(unix.CAN_ERR_CRTL_RX_WARNING|unix.CAN_ERR_CRTL_TX_WARNING) != 0:
+		return StateErrorWarning
+	case status&unix.CAN_ERR_CRTL_ACTIVE != 0:
+		return StateErrorActive
+	default:
+		return current
+	}
+}
+
+func counterState(txErrors uint8, rxErrors uint8) string {
+	count := txErrors
+	if rxErrors > count {
+		count = rxErrors
+	}
+	switch {
+	case count >= passiveErrorCount:
+		return StateErrorPassive
+	case count >= warningErrorCount:
+		return StateErrorWarning
+	default:
+		return StateErrorActive
+	}
+}
+
+// offerLatest replaces a not yet received value of ch, which must have a buffer of 1, by value. ch must have a single sender.
+func offerLatest[T any](ch chan T, value T) {
+	select {
+	case ch <- value:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- value
+}
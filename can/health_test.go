@@ -0,0 +1,158 @@
+package can_test
+
+import (
+	"echoctl/can"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"testing"
+	"time"
+)
+
+func TestDecodeErrorFrame(t *testing.T) {
+	t.Run("ignores data frames", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok := can.DecodeErrorFrame(canbus.Frame{ID: unix.CAN_ERR_BUSOFF, Kind: canbus.SFF})
+		assert.False(t, ok)
+	})
+
+	t.Run("decodes controller status and counters", func(t *testing.T) {
+		t.Parallel()
+
+		e, ok := can.DecodeErrorFrame(canbus.Frame{
+			ID:   unix.CAN_ERR_CRTL | 0x200,
+			Data: []byte{0, unix.CAN_ERR_CRTL_TX_PASSIVE, 0, 0, 0, 0, 130, 5},
+			Kind: canbus.ERR,
+		})
+		assert.True(t, ok)
+		assert.True(t, e.HasCounters)
+		assert.Equal(t, uint8(130), e.TxErrors)
+		assert.Equal(t, uint8(5), e.RxErrors)
+		assert.Equal(t, []string{"TX error passive"}, e.Problems())
+	})
+
+	t.Run("tolerates short data", func(t *testing.T) {
+		t.Parallel()
+
+		e, ok := can.DecodeErrorFrame(canbus.Frame{ID: unix.CAN_ERR_ACK | unix.CAN_ERR_BUSOFF, Kind: canbus.ERR})
+		assert.True(t, ok)
+		assert.False(t, e.HasCounters)
+		assert.Equal(t, []string{"no ACK on transmission", "bus-off"}, e.Problems())
+	})
+}
+
+func TestHealthMonitor(t *testing.T) {
+	t.Run("passes data frames on", func(t *testing.T) {
+		t.Parallel()
+
+		inbound, toDispatcher := make(chan canbus.Frame, 1), make(chan canbus.Frame, 1)
+		monitor := can.NewHealthMonitor(inbound, toDispatcher, nil, zap.NewNop())
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x32}}
+			assert.Equal(t, uint32(0x180), readWithTimeout(t, toDispatcher).ID)
+		})
+	})
+
+	t.Run("accumulates error frames into health", func(t *testing.T) {
+		t.Parallel()
+
+		inbound, toDispatcher := make(chan canbus.Frame, 1), make(chan canbus.Frame, 1)
+		monitor := can.NewHealthMonitor(inbound, toDispatcher, nil, zap.NewNop())
+		assert.Equal(t, can.StateErrorActive, readWithTimeout(t, monitor.Healths()).State)
+
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_CRTL, Data: []byte{0, unix.CAN_ERR_CRTL_RX_WARNING}, Kind: canbus.ERR}
+			health := readWithTimeout(t, monitor.Healths())
+			assert.Equal(t, can.StateErrorWarning, health.State)
+			assert.Equal(t, uint64(1), health.ControllerProblems)
+
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_ACK | unix.CAN_ERR_BUSOFF, Kind: canbus.ERR}
+			health = readWithTimeout(t, monitor.Healths())
+			assert.Equal(t, can.StateBusOff, health.State)
+			assert.Equal(t, uint64(2), health.ErrorFrames)
+			assert.Equal(t, uint64(1), health.BusOff)
+			assert.Equal(t, uint64(1), health.NoAck)
+			assert.Equal(t, "no ACK on transmission, bus-off", health.LastError)
+			assert.NotNil(t, health.LastErrorAt)
+
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_RESTARTED, Kind: canbus.ERR}
+			assert.Equal(t, can.StateErrorActive, readWithTimeout(t, monitor.Healths()).State)
+			assert.Equal(t, can.StateErrorActive, monitor.Health().State)
+		})
+		select {
+		case frame := <-toDispatcher:
+			assert.Fail(t, "error frames must not be passed on", "%v", frame)
+		default:
+		}
+	})
+
+	t.Run("resets bus-off after the socket was reopened", func(t *testing.T) {
+		t.Parallel()
+
+		inbound, toDispatcher, states := make(chan canbus.Frame, 1), make(chan canbus.Frame, 1), make(chan can.BusState, 1)
+		monitor := can.NewHealthMonitor(inbound, toDispatcher, states, zap.NewNop())
+		readWithTimeout(t, monitor.Healths())
+
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_BUSOFF | 0x200, Data: []byte{0, 0, 0, 0, 0, 0, 255, 0}, Kind: canbus.ERR}
+			assert.Equal(t, can.StateBusOff, readWithTimeout(t, monitor.Healths()).State)
+
+			states <- can.BusDown
+			states <- can.BusUp
+			health := readWithTimeout(t, monitor.Healths())
+			assert.Equal(t, can.StateErrorActive, health.State, "the reopened socket is ready")
+			assert.Equal(t, uint8(0), health.TxErrors)
+			assert.Equal(t, uint64(1), health.BusOff, "the statistics are kept")
+		})
+	})
+
+	t.Run("leaves bus-off on counters below the warning threshold", func(t *testing.T) {
+		t.Parallel()
+
+		inbound, toDispatcher := make(chan canbus.Frame, 1), make(chan canbus.Frame, 1)
+		monitor := can.NewHealthMonitor(inbound, toDispatcher, nil, zap.NewNop())
+		readWithTimeout(t, monitor.Healths())
+
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_BUSOFF, Kind: canbus.ERR}
+			assert.Equal(t, can.StateBusOff, readWithTimeout(t, monitor.Healths()).State)
+
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_PROT | 0x200, Data: []byte{0, 0, 0, 0, 0, 0, 200, 0}, Kind: canbus.ERR}
+			assert.Equal(t, can.StateBusOff, readWithTimeout(t, monitor.Healths()).State, "high counters do not leave bus-off")
+
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_PROT | 0x200, Data: []byte{0, 0, 0, 0, 0, 0, 3, 0}, Kind: canbus.ERR}
+			assert.Equal(t, can.StateErrorActive, readWithTimeout(t, monitor.Healths()).State)
+		})
+	})
+
+	t.Run("keeps the health across restarts", func(t *testing.T) {
+		t.Parallel()
+
+		inbound, toDispatcher := make(chan canbus.Frame, 1), make(chan canbus.Frame, 1)
+		monitor := can.NewHealthMonitor(inbound, toDispatcher, nil, zap.NewNop())
+		readWithTimeout(t, monitor.Healths())
+
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: unix.CAN_ERR_BUSOFF, Kind: canbus.ERR}
+			readWithTimeout(t, monitor.Healths())
+		})
+		runAndKillMonitor(t, monitor, func() {
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x32}}
+			assert.Equal(t, uint32(0x180), readWithTimeout(t, toDispatcher).ID)
+		})
+		assert.Equal(t, can.StateBusOff, monitor.Health().State)
+	})
+}
+
+func runAndKillMonitor(t *testing.T, monitor can.HealthMonitor, f func()) {
+	tmb := monitor.Monitor()
+	f()
+	tmb.Kill(nil)
+	select {
+	case <-tmb.Dead():
+	case <-time.After(time.Second):
+		assert.Fail(t, "HealthMonitor failed to exit in 1s")
+	}
+}
@@ -0,0 +1,99 @@
+package can
+
+import (
+	"context"
+	"errors"
+	"github.com/go-daq/canbus"
+	"sync"
+	"syscall"
+)
+
+// memoryBusBuffer is the receive buffer of each memory socket. Frames exceeding it are dropped, like a full socket buffer of the kernel drops them.
+const memoryBusBuffer = 100
+
+// MemoryBus is an in-process CAN bus. Each frame sent on one of its sockets is received by all other sockets.
+type MemoryBus struct {
+	mutex   sync.Mutex
+	sockets []*memorySocket
+}
+
+type memorySocket struct {
+	bus     *MemoryBus
+	inbound chan canbus.Frame
+	closed  chan struct{}
+	once    sync.Once
+}
+
+var _ Socket = (*memorySocket)(nil)
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Socket attaches a new socket to the bus.
+func (b *MemoryBus) Socket() Socket {
+	s := &memorySocket{
+		bus:     b,
+		inbound: make(chan canbus.Frame, memoryBusBuffer),
+		closed:  make(chan struct{}),
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.sockets = append(b.sockets, s)
+	return s
+}
+
+func (b *MemoryBus) deliver(from *memorySocket, frame canbus.Frame) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, s := range b.sockets {
+		if s == from {
+			continue
+		}
+		select {
+		case s.inbound <- frame:
+		default:
+		}
+	}
+}
+
+func (b *MemoryBus) detach(socket *memorySocket) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, s := range b.sockets {
+		if s == socket {
+			b.sockets = append(b.sockets[:i], b.sockets[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *memorySocket) Close() error {
+	s.once.Do(func() {
+		s.bus.detach(s)
+		close(s.closed)
+	})
+	return nil
+}
+
+func (s *memorySocket) Send(msg canbus.Frame) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, syscall.EBADF
+	default:
+	}
+	msg.Data = append([]byte(nil), msg.Data...)
+	s.bus.deliver(s, msg)
+	return len(msg.Data), nil
+}
+
+func (s *memorySocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	select {
+	case frame := <-s.inbound:
+		return frame, nil
+	case <-s.closed:
+		return canbus.Frame{}, errors.New("memory socket closed")
+	case <-ctx.Done():
+		return canbus.Frame{}, ctx.Err()
+	}
+}
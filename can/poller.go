@@ -2,7 +2,9 @@ package can
 
 import (
 	"echoctl/conf"
+	"echoctl/dispatcher"
 	"echoctl/flowcontrol"
+	"echoctl/metrics"
 	"echoctl/schedule"
 	"errors"
 	"github.com/go-daq/canbus"
@@ -22,31 +24,57 @@ type Subscription struct {
 type poller struct {
 	socket        Socket
 	subscriptions []Subscription
-	inbound       <-chan conf.Command
+	inbound       <-chan dispatcher.CommandValue
 	tomb          *tomb.Tomb
 	log           *zap.Logger
 	scheduler     schedule.Scheduler[Subscription]
+	collector     metrics.Collector
+	listener      RequestListener
+
+	// states receives the bus states of the socket, if it is a BusStateSource.
+	states <-chan BusState
+
+	// scheduled is set, after the subscriptions were scheduled by the first Poll. The scheduler keeps them across restarts.
+	scheduled bool
+
+	// failed is the subscription, which failed to send, and has to be scheduled again on restart.
+	failed *Subscription
+
+	// paused are the subscriptions triggered while the bus was down. They are scheduled again, once the bus is up, or on restart.
+	paused []*Subscription
 }
 
-// Poller sends periodic commands to a can-bus socket, following the specified schedule. Poller does not wait for a reply. It relies on Reader to read the reply from can-bus. The Reader passes the received frame to the Dispatcher, and the Dispatcher passes it on to Poller.
+// Poller sends periodic commands to a can-bus socket, following the specified schedule. Poller does not wait for a reply. While the bus of a BusStateSource is down, it waits for the bus to come up again. It relies on Reader to read the reply from can-bus. The Reader passes the received frame to the Dispatcher, and the Dispatcher passes it on to its sinks, including the Poller.
 type Poller interface {
+	// Poll starts polling. It can be called again after the returned tomb died, to restart polling.
 	Poll() *tomb.Tomb
 }
 
 var _ Poller = (*poller)(nil)
 
-func NewPoller(socket Socket, subscriptions []Subscription, inbound <-chan conf.Command, scheduler schedule.Scheduler[Subscription], log *zap.Logger) Poller {
+func NewPoller(socket Socket, subscriptions []Subscription, inbound <-chan dispatcher.CommandValue, scheduler schedule.Scheduler[Subscription], collector metrics.Collector, listener RequestListener, log *zap.Logger) Poller {
 	return &poller{
 		socket:        socket,
 		subscriptions: subscriptions,
 		inbound:       inbound,
-		tomb:          new(tomb.Tomb),
 		log:           log,
 		scheduler:     scheduler,
+		collector:     collector,
+		listener:      listener,
+		states:        busStates(socket),
 	}
 }
 
+// busStates returns the bus states of socket, or nil, if it does not provide them.
+func busStates(socket Socket) <-chan BusState {
+	if source, ok := socket.(BusStateSource); ok {
+		return source.BusStates()
+	}
+	return nil
+}
+
 func (poller *poller) Poll() *tomb.Tomb {
+	poller.tomb = new(tomb.Tomb)
 	poller.tomb.Go(poller.poll)
 	poller.tomb.Go(func() error {
 		poller.scheduler.Run(poller.tomb.Dying())
@@ -56,7 +84,16 @@ func (poller *poller) Poll() *tomb.Tomb {
 }
 
 func (poller *poller) poll() error {
-	poller.createSchedule(poller.subscriptions)
+	if !poller.scheduled {
+		poller.createSchedule(poller.subscriptions)
+		poller.scheduled = true
+	} else {
+		if poller.failed != nil {
+			poller.scheduler.Schedule() <- schedule.Request[Subscription]{Data: poller.failed, TriggerIn: poller.failed.Delay}
+			poller.failed = nil
+		}
+		poller.resume()
+	}
 	for {
 		select {
 		case trigger := <-poller.scheduler.Next():
@@ -80,22 +117,75 @@ func (poller *poller) createSchedule(subscriptions []Subscription) {
 }
 
 func (poller *poller) processTrigger(trigger schedule.Trigger[Subscription]) error {
+	if !trigger.DueAt.IsZero() {
+		poller.collector.SchedulerLateness(time.Since(trigger.DueAt))
+	}
 	err := poller.sendCommand(trigger.Data.Command)
 
 	if flowcontrol.IsShouldRetry(err) {
+		poller.collector.SendRetry()
 		// Retry sending, but delay a bit, to not directly fail again on retry.
 		poller.scheduler.Schedule() <- schedule.Request[Subscription]{Data: trigger.Data, TriggerIn: RetryDelay}
 		return nil
 	}
+	if flowcontrol.IsCanSkip(err) {
+		if poller.states == nil {
+			// The bus is down. Pause polling this command until its next schedule.
+			poller.log.Debug("sending skipped", zap.String("command", trigger.Data.Command.Id), zap.Error(err))
+			poller.scheduler.Schedule() <- schedule.Request[Subscription]{Data: trigger.Data, TriggerIn: trigger.Data.Delay}
+			return nil
+		}
+		// The bus is down. Stop polling until it is up again, then send the paused commands right away.
+		poller.log.Info("bus down, pausing polling", zap.String("command", trigger.Data.Command.Id), zap.Error(err))
+		poller.paused = append(poller.paused, trigger.Data)
+		if err := poller.waitBusUp(); err != nil {
+			return err
+		}
+		poller.log.Info("bus up, resuming polling", zap.Int("paused", len(poller.paused)))
+		poller.resume()
+		return nil
+	}
 	if err != nil {
+		poller.failed = trigger.Data
 		return err
 	}
 
 	// Command sent successfully, reschedule the next sending.
+	poller.collector.RequestSent(trigger.Data.Command, false)
+	poller.listener.RequestSent(trigger.Data.Command, false)
 	poller.scheduler.Schedule() <- schedule.Request[Subscription]{Data: trigger.Data, TriggerIn: trigger.Data.Delay}
 	return nil
 }
 
+// waitBusUp blocks until the bus state is up. Returns tomb.ErrDying, if the poller is killed meanwhile. Triggers are kept reading and paused, else the scheduler would try to send the overdue trigger again and again.
+func (poller *poller) waitBusUp() error {
+	for {
+		select {
+		case state := <-poller.states:
+			if state == BusUp {
+				return nil
+			}
+
+		case trigger := <-poller.scheduler.Next():
+			poller.paused = append(poller.paused, trigger.Data)
+
+		case <-poller.inbound:
+			// Keep the sink flowing, values are ignored anyway.
+
+		case <-poller.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+// resume schedules the paused subscriptions right away.
+func (poller *poller) resume() {
+	for _, subscription := range poller.paused {
+		poller.scheduler.Schedule() <- schedule.Request[Subscription]{Data: subscription}
+	}
+	poller.paused = nil
+}
+
 func (poller *poller) sendCommand(command conf.Command) error {
 	poller.log.Debug("sending", zap.String("command", command.Id))
 	_, err := poller.socket.Send(toFrame(command.Request))
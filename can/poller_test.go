@@ -3,9 +3,12 @@ package can_test
 import (
 	"echoctl/can"
 	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/metrics"
 	"echoctl/schedule"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"runtime"
 	"syscall"
 	"testing"
 	"time"
@@ -55,6 +58,109 @@ func TestSending(t *testing.T) {
 			assert.Equal(t, can.RetryDelay, scheduleRequest.TriggerIn, "the schedule request should have a short TriggerIn")
 		})
 	})
+
+	t.Run("reschedule skipped sends with configured delay", func(t *testing.T) {
+		t.Parallel()
+		poller, socket, scheduleRequests, nextTrigger := NewPoller()
+
+		runAndKillPoller(t, poller, func() {
+			socket.NextSendError(skipError{})
+			nextTrigger <- newTrigger(123, 3*time.Second)
+			scheduleRequest := readWithTimeout(t, scheduleRequests)
+			assert.Equal(t, 3*time.Second, scheduleRequest.TriggerIn, "polling should pause until the next schedule")
+		})
+	})
+
+	t.Run("pauses polling while the bus is down", func(t *testing.T) {
+		t.Parallel()
+		sockets := make(chan *failingSocket, 1)
+		first := newFailingSocket()
+		sockets <- first
+		socket := newRecoveringSocket(t, sockets)
+		poller, scheduleRequests, nextTrigger := newPollerOn(socket)
+
+		runAndKillPoller(t, poller, func() {
+			first.fail <- syscall.ENETDOWN
+			nextTrigger <- newTrigger(123, 3*time.Second)
+			// The poller keeps reading the triggers of the other subscriptions. A scheduler, whose trigger is not read, would try again and again.
+			for _, canId := range []conf.CanId{124, 125} {
+				select {
+				case nextTrigger <- newTrigger(canId, 3*time.Second):
+				case <-time.After(time.Second):
+					t.Fatal("the poller should read triggers while the bus is down")
+				}
+			}
+			select {
+			case request := <-scheduleRequests:
+				t.Fatalf("the poller should not reschedule while the bus is down, got %v", request)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			sockets <- newFailingSocket()
+			for _, canId := range []conf.CanId{123, 124, 125} {
+				scheduleRequest := readWithTimeout(t, scheduleRequests)
+				assert.Equal(t, canId, scheduleRequest.Data.Command.Request.CanId)
+				assert.Equal(t, time.Duration(0), scheduleRequest.TriggerIn, "the paused commands should be sent right away")
+			}
+		})
+	})
+
+	t.Run("does not spin the scheduler while the bus is down", func(t *testing.T) {
+		// Not parallel, it counts the allocations of all go routines. A spinning scheduler allocates a timer per round.
+		sockets := make(chan *failingSocket, 1)
+		first := newFailingSocket()
+		sockets <- first
+		socket := newRecoveringSocket(t, sockets)
+		first.fail <- syscall.ENETDOWN
+		var subscriptions []can.Subscription
+		for _, canId := range []conf.CanId{123, 124, 125} {
+			subscriptions = append(subscriptions, can.Subscription{Command: NewCommand(canId), Delay: 5 * time.Millisecond})
+		}
+		poller := can.NewPoller(socket, subscriptions, make(chan dispatcher.CommandValue), schedule.NewScheduler[can.Subscription](), metrics.Nop, can.NopRequestListener, zap.NewNop())
+
+		runAndKillPoller(t, poller, func() {
+			// The bus stays down, the socket is not reopened.
+			time.Sleep(50 * time.Millisecond)
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			time.Sleep(100 * time.Millisecond)
+			runtime.ReadMemStats(&after)
+			assert.Less(t, after.Mallocs-before.Mallocs, uint64(10000), "the scheduler should idle while the bus is down")
+		})
+	})
+}
+
+func TestRestart(t *testing.T) {
+	t.Run("reschedules the failed subscription on restart", func(t *testing.T) {
+		t.Parallel()
+		poller, socket, scheduleRequests, nextTrigger := NewPoller()
+
+		socket.NextSendError(syscall.EIO)
+		tmb := poller.Poll()
+		nextTrigger <- newTrigger(123, 3*time.Second)
+		select {
+		case <-tmb.Dead():
+			assert.ErrorIs(t, tmb.Err(), syscall.EIO)
+		case <-time.After(time.Second):
+			t.Fatal("Poller did not fail in 1s")
+		}
+
+		runAndKillPoller(t, poller, func() {
+			scheduleRequest := readWithTimeout(t, scheduleRequests)
+			assert.Equal(t, conf.CanId(123), scheduleRequest.Data.Command.Request.CanId)
+			assert.Equal(t, 3*time.Second, scheduleRequest.TriggerIn)
+		})
+	})
+}
+
+type skipError struct{}
+
+func (skipError) CanSkip() bool {
+	return true
+}
+
+func (skipError) Error() string {
+	return "skip"
 }
 
 func newTrigger(canId conf.CanId, delay time.Duration) schedule.Trigger[can.Subscription] {
@@ -79,12 +185,18 @@ func readWithTimeout[T any](t *testing.T, ch <-chan T) *T {
 
 func NewPoller() (can.Poller, SocketMock, chan schedule.Request[can.Subscription], chan schedule.Trigger[can.Subscription]) {
 	socket := NewSocketMock()
-	inbound := make(chan conf.Command)
+	poller, scheduleRequests, nextTrigger := newPollerOn(socket)
+	return poller, socket, scheduleRequests, nextTrigger
+}
+
+// newPollerOn creates a Poller sending to socket, scheduling immediately.
+func newPollerOn(socket can.Socket) (can.Poller, chan schedule.Request[can.Subscription], chan schedule.Trigger[can.Subscription]) {
+	inbound := make(chan dispatcher.CommandValue)
 	scheduleRequests := make(chan schedule.Request[can.Subscription], 20)
 	nextTrigger := make(chan schedule.Trigger[can.Subscription])
 	scheduler := schedule.NewImmediatelyScheduler(scheduleRequests, nextTrigger)
-	poller := can.NewPoller(socket, []can.Subscription{}, inbound, scheduler, zap.NewNop())
-	return poller, socket, scheduleRequests, nextTrigger
+	poller := can.NewPoller(socket, []can.Subscription{}, inbound, scheduler, metrics.Nop, can.NopRequestListener, zap.NewNop())
+	return poller, scheduleRequests, nextTrigger
 }
 
 func runAndKillPoller(t *testing.T, poller can.Poller, f func()) {
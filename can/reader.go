@@ -17,6 +17,7 @@ type reader struct {
 
 // The Reader runs in the background, reading can-bus frames from socket and passing them to the Dispatcher.
 type Reader interface {
+	// Read starts reading. It can be called again after the returned tomb died, to restart reading.
 	Read() *tomb.Tomb
 }
 
@@ -26,12 +27,12 @@ func NewReader(socket Socket, toDispatcher chan<- canbus.Frame, log *zap.Logger)
 	return &reader{
 		toDispatcher: toDispatcher,
 		socket:       socket,
-		tomb:         new(tomb.Tomb),
 		log:          log,
 	}
 }
 
 func (r *reader) Read() *tomb.Tomb {
+	r.tomb = new(tomb.Tomb)
 	r.tomb.Go(r.read)
 	return r.tomb
 }
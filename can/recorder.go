@@ -0,0 +1,144 @@
+package can
+
+import (
+	"echoctl/conf"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/tomb.v2"
+	"os"
+	"time"
+)
+
+type recorder struct {
+	config       conf.Record
+	iface        string
+	inbound      <-chan canbus.Frame
+	toDispatcher chan<- canbus.Frame
+	file         *os.File
+	size         int64
+	tomb         *tomb.Tomb
+	log          *zap.Logger
+}
+
+// The Recorder sits between Reader and HealthMonitor. It passes all frames on to the HealthMonitor, and writes them to a log file in the `candump -L` format. Recording is disabled, if no file is configured.
+type Recorder interface {
+	// Record starts recording. It can be called again after the returned tomb died, to restart recording. The file is reopened.
+	Record() *tomb.Tomb
+}
+
+var _ Recorder = (*recorder)(nil)
+
+// NewRecorder creates a Recorder. The frames are recorded on the channel name of iface, see Channel.
+func NewRecorder(config conf.Record, iface string, inbound <-chan canbus.Frame, toDispatcher chan<- canbus.Frame, log *zap.Logger) Recorder {
+	return &recorder{
+		config:       config,
+		iface:        Channel(iface),
+		inbound:      inbound,
+		toDispatcher: toDispatcher,
+		log:          log,
+	}
+}
+
+func (r *recorder) Record() *tomb.Tomb {
+	r.tomb = new(tomb.Tomb)
+	r.tomb.Go(r.record)
+	return r.tomb
+}
+
+func (r *recorder) record() error {
+	if r.config.File != "" {
+		if err := r.open(); err != nil {
+			return err
+		}
+		defer r.close()
+	}
+
+	for {
+		select {
+		case frame := <-r.inbound:
+			if err := r.write(frame); err != nil {
+				return err
+			}
+			select {
+			case r.toDispatcher <- frame:
+			case <-r.tomb.Dying():
+				return tomb.ErrDying
+			}
+		case <-r.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (r *recorder) write(frame canbus.Frame) error {
+	if r.file == nil || !r.matches(frame) {
+		return nil
+	}
+	line := FormatCandump(time.Now(), r.iface, frame) + "\n"
+	if r.config.MaxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.config.MaxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.WriteString(line)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("recording frame to %s: %w", r.config.File, err)
+	}
+	return nil
+}
+
+func (r *recorder) matches(frame canbus.Frame) bool {
+	return len(r.config.Filter) == 0 || slices.Contains(r.config.Filter, conf.CanId(frame.ID))
+}
+
+func (r *recorder) open() error {
+	file, err := os.OpenFile(r.config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening recording file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("opening recording file: %w", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *recorder) close() {
+	if r.file == nil {
+		return
+	}
+	if err := r.file.Close(); err != nil {
+		r.log.Error("closing recording file", zap.Error(err))
+	}
+	r.file = nil
+}
+
+// rotate renames the current file to `<file>.1`, shifting older files to `<file>.2` and so on. The file exceeding MaxFiles is overwritten.
+func (r *recorder) rotate() error {
+	r.close()
+	for i := r.config.MaxFiles; i > 0; i-- {
+		older := fmt.Sprintf("%s.%d", r.config.File, i)
+		newer := r.config.File
+		if i > 1 {
+			newer = fmt.Sprintf("%s.%d", r.config.File, i-1)
+		}
+		if err := os.Rename(newer, older); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotating recording file: %w", err)
+		}
+	}
+	if r.config.MaxFiles <= 0 {
+		// No rotated files are kept, start over.
+		if err := os.Remove(r.config.File); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotating recording file: %w", err)
+		}
+	}
+	r.log.Debug("rotated recording file", zap.String("file", r.config.File))
+	return r.open()
+}
@@ -0,0 +1,139 @@
+package can_test
+
+import (
+	"echoctl/can"
+	"echoctl/conf"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatCandump(t *testing.T) {
+	t.Run("formats standard frame", func(t *testing.T) {
+		t.Parallel()
+
+		line := can.FormatCandump(time.Unix(1670790000, 123456000), "can0", canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10, 0xFA, 0x01, 0x12, 0x0B, 0x00}, Kind: canbus.SFF})
+		assert.Equal(t, "(1670790000.123456) can0 180#3210FA01120B00", line)
+	})
+
+	t.Run("formats extended and remote frames", func(t *testing.T) {
+		t.Parallel()
+
+		at := time.Unix(1, 0)
+		assert.Equal(t, "(1.000000) can0 00012345#01", can.FormatCandump(at, "can0", canbus.Frame{ID: 0x12345, Data: []byte{1}, Kind: canbus.EFF}))
+		assert.Equal(t, "(1.000000) can0 123#R", can.FormatCandump(at, "can0", canbus.Frame{ID: 0x123, Kind: canbus.RTR}))
+		assert.Equal(t, "(1.000000) can0 20000040#0000000000000000", can.FormatCandump(at, "can0", canbus.Frame{ID: 0x40, Data: make([]byte, 8), Kind: canbus.ERR}))
+	})
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("passes frames on and records them", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "can.log")
+		recorder, inbound, toDispatcher := NewRecorder(conf.Record{File: file})
+
+		runAndKillRecorder(t, recorder, func() {
+			frame := NewFrame()
+			inbound <- frame
+			assert.Equal(t, frame, *readWithTimeout(t, toDispatcher), "Recorder should pass frames on unchanged")
+		})
+
+		lines := readLines(t, file)
+		if assert.Len(t, lines, 1) {
+			assert.Regexp(t, `^\(\d+\.\d{6}\) vcan0 15E#000102030405$`, lines[0])
+		}
+	})
+
+	t.Run("records filtered CAN IDs only", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "can.log")
+		recorder, inbound, toDispatcher := NewRecorder(conf.Record{File: file, Filter: []conf.CanId{0x180}})
+
+		runAndKillRecorder(t, recorder, func() {
+			inbound <- canbus.Frame{ID: 0x300, Data: []byte{1}}
+			readWithTimeout(t, toDispatcher)
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{2}}
+			readWithTimeout(t, toDispatcher)
+		})
+
+		lines := readLines(t, file)
+		if assert.Len(t, lines, 1) {
+			assert.True(t, strings.HasSuffix(lines[0], " 180#02"), "unexpected line %q", lines[0])
+		}
+	})
+
+	t.Run("rotates files", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "can.log")
+		// A line is about 40 bytes, rotate after each line.
+		recorder, inbound, toDispatcher := NewRecorder(conf.Record{File: file, MaxSize: 50, MaxFiles: 2})
+
+		runAndKillRecorder(t, recorder, func() {
+			for i := 0; i < 4; i++ {
+				inbound <- canbus.Frame{ID: 0x180, Data: []byte{byte(i)}}
+				readWithTimeout(t, toDispatcher)
+			}
+		})
+
+		assert.Equal(t, []string{"180#03"}, suffixes(readLines(t, file)))
+		assert.Equal(t, []string{"180#02"}, suffixes(readLines(t, file+".1")))
+		assert.Equal(t, []string{"180#01"}, suffixes(readLines(t, file+".2")))
+		assert.NoFileExists(t, file+".3")
+	})
+
+	t.Run("records again after a restart", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "can.log")
+		recorder, inbound, toDispatcher := NewRecorder(conf.Record{File: file})
+
+		for i := byte(0); i < 2; i++ {
+			runAndKillRecorder(t, recorder, func() {
+				inbound <- canbus.Frame{ID: 0x180, Data: []byte{i}}
+				readWithTimeout(t, toDispatcher)
+			})
+		}
+
+		assert.Equal(t, []string{"180#00", "180#01"}, suffixes(readLines(t, file)))
+	})
+}
+
+func NewRecorder(config conf.Record) (can.Recorder, chan canbus.Frame, chan canbus.Frame) {
+	inbound := make(chan canbus.Frame, 1)
+	toDispatcher := make(chan canbus.Frame, 1)
+	recorder := can.NewRecorder(config, "vcan0", inbound, toDispatcher, zap.NewNop())
+	return recorder, inbound, toDispatcher
+}
+
+func runAndKillRecorder(t *testing.T, recorder can.Recorder, f func()) {
+	tmb := recorder.Record()
+	f()
+	tmb.Kill(nil)
+	select {
+	case <-tmb.Dead():
+	case <-time.After(time.Second):
+		assert.Fail(t, "Recorder did not exit in 1s")
+	}
+}
+
+func readLines(t *testing.T, file string) []string {
+	buf, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+}
+
+func suffixes(lines []string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = line[strings.LastIndex(line, " ")+1:]
+	}
+	return result
+}
@@ -0,0 +1,283 @@
+package can
+
+import (
+	"context"
+	"echoctl/conf"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// BusState is the state of the CAN socket, as seen by the RecoveringSocket.
+type BusState int
+
+const (
+	BusUp BusState = iota
+	BusDown
+)
+
+func (s BusState) String() string {
+	switch s {
+	case BusUp:
+		return "up"
+	case BusDown:
+		return "down"
+	default:
+		return fmt.Sprintf("BusState(%d)", int(s))
+	}
+}
+
+// BusStateSource provides changes of the bus state. Implemented by RecoveringSocket.
+type BusStateSource interface {
+	// BusStates returns a new channel receiving the current bus state, and every change. Intermediate states are dropped, if the receiver is slow. Each receiver has to call BusStates once.
+	BusStates() <-chan BusState
+
+	// BusState returns the current bus state. Safe to call from any go routine.
+	BusState() BusState
+}
+
+// RecoveringSocket is a Socket, which reopens the underlying socket when it fails, instead of returning the error. While the socket is down, RecvCtx blocks and Send returns an error, which can be skipped.
+type RecoveringSocket interface {
+	Socket
+	BusStateSource
+}
+
+type recoveringSocket struct {
+	open           func() (Socket, error)
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	log            *zap.Logger
+
+	mutex  sync.Mutex
+	socket Socket // nil while down
+	up     chan struct{}
+	state  BusState
+
+	// backoff is the backoff before the last reopen, and reopenedAt its time. A socket failing again within maxBackoff continues with the grown backoff, f.e. on an interface, which is down: opening it succeeds, but sending fails.
+	backoff    time.Duration
+	reopenedAt time.Time
+	states     []chan BusState
+	closed     chan struct{}
+}
+
+var _ RecoveringSocket = (*recoveringSocket)(nil)
+
+// NewRecoveringSocket opens a socket with open, and reopens it with exponential backoff after it failed. Failing to open the socket initially is returned as error, it's most likely a configuration problem.
+func NewRecoveringSocket(open func() (Socket, error), config conf.Recovery, log *zap.Logger) (RecoveringSocket, error) {
+	socket, err := open()
+	if err != nil {
+		return nil, err
+	}
+	s := &recoveringSocket{
+		open:           open,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+		log:            log,
+		socket:         socket,
+		up:             make(chan struct{}),
+		closed:         make(chan struct{}),
+	}
+	if s.initialBackoff <= 0 {
+		s.initialBackoff = defaultInitialBackoff
+	}
+	if s.maxBackoff <= 0 {
+		s.maxBackoff = defaultMaxBackoff
+	}
+	if s.maxBackoff < s.initialBackoff {
+		s.maxBackoff = s.initialBackoff
+	}
+	close(s.up)
+	s.setState(BusUp)
+	return s, nil
+}
+
+func (s *recoveringSocket) BusStates() <-chan BusState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := make(chan BusState, 1)
+	states <- s.state
+	s.states = append(s.states, states)
+	return states
+}
+
+func (s *recoveringSocket) BusState() BusState {
+	if s.current() == nil {
+		return BusDown
+	}
+	return BusUp
+}
+
+func (s *recoveringSocket) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+		return nil
+	default:
+	}
+	close(s.closed)
+	if s.socket == nil {
+		return nil
+	}
+	return s.socket.Close()
+}
+
+func (s *recoveringSocket) Send(msg canbus.Frame) (int, error) {
+	socket := s.current()
+	if socket == nil {
+		return 0, busDownError{}
+	}
+	n, err := socket.Send(msg)
+	if err == nil || errors.Is(err, syscall.ENOBUFS) || s.isClosed() {
+		return n, err
+	}
+	s.fail(socket, err)
+	return n, busDownError{err}
+}
+
+func (s *recoveringSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	for {
+		socket, err := s.waitUp(ctx)
+		if err != nil {
+			return canbus.Frame{}, err
+		}
+		frame, err := socket.RecvCtx(ctx)
+		if err != nil {
+			if ctx.Err() != nil || s.isClosed() {
+				return frame, err
+			}
+			s.fail(socket, err)
+			continue
+		}
+		if isBusOff(frame) {
+			// Pass the error frame on, so it is logged and recorded.
+			s.fail(socket, errors.New("controller is bus-off"))
+		}
+		return frame, nil
+	}
+}
+
+// current returns the underlying socket, or nil while down.
+func (s *recoveringSocket) current() Socket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.socket
+}
+
+// waitUp returns the underlying socket, waiting for it to be reopened while down.
+func (s *recoveringSocket) waitUp(ctx context.Context) (Socket, error) {
+	for {
+		s.mutex.Lock()
+		socket, up := s.socket, s.up
+		s.mutex.Unlock()
+		if socket != nil {
+			return socket, nil
+		}
+		select {
+		case <-up:
+		case <-s.closed:
+			return nil, errors.New("socket closed")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *recoveringSocket) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail closes the failed socket, and starts reopening it. Failures of an already replaced socket are ignored.
+func (s *recoveringSocket) fail(socket Socket, cause error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.socket != socket {
+		return
+	}
+	backoff := s.initialBackoff
+	if time.Since(s.reopenedAt) < s.maxBackoff {
+		backoff = s.grow(s.backoff)
+	}
+	s.log.Warn("CAN socket failed, reopening", zap.Duration("backoff", backoff), zap.Error(cause))
+	if err := socket.Close(); err != nil {
+		s.log.Debug("closing failed socket", zap.Error(err))
+	}
+	s.socket = nil
+	s.up = make(chan struct{})
+	s.setState(BusDown)
+	go s.recover(backoff)
+}
+
+// grow returns the doubled backoff, limited to maxBackoff.
+func (s *recoveringSocket) grow(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > s.maxBackoff {
+		return s.maxBackoff
+	}
+	return backoff
+}
+
+func (s *recoveringSocket) recover(backoff time.Duration) {
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-s.closed:
+			timer.Stop()
+			return
+		}
+
+		socket, err := s.open()
+		if err == nil {
+			if s.reopened(socket, backoff) {
+				s.log.Info("CAN socket reopened", zap.Int("attempt", attempt))
+			}
+			return
+		}
+		s.log.Debug("reopening CAN socket failed", zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		backoff = s.grow(backoff)
+	}
+}
+
+// reopened installs socket as the new underlying socket, reopened after backoff. Returns false, if the RecoveringSocket was closed meanwhile.
+func (s *recoveringSocket) reopened(socket Socket, backoff time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isClosed() {
+		_ = socket.Close()
+		return false
+	}
+	s.socket = socket
+	s.backoff = backoff
+	s.reopenedAt = time.Now()
+	close(s.up)
+	s.setState(BusUp)
+	return true
+}
+
+// setState must be called with the mutex held, or before the socket is shared.
+func (s *recoveringSocket) setState(state BusState) {
+	s.state = state
+	for _, states := range s.states {
+		offerLatest(states, state)
+	}
+}
+
+func isBusOff(frame canbus.Frame) bool {
+	return frame.Kind == canbus.ERR && frame.ID&unix.CAN_ERR_BUSOFF != 0
+}
@@ -0,0 +1,225 @@
+package can_test
+
+import (
+	"context"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/flowcontrol"
+	"errors"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingSocket receives frames from inbound, and fails with the errors sent to fail.
+type failingSocket struct {
+	inbound chan canbus.Frame
+	fail    chan error
+	closed  atomic.Bool
+}
+
+func newFailingSocket() *failingSocket {
+	return &failingSocket{inbound: make(chan canbus.Frame, 1), fail: make(chan error, 1)}
+}
+
+func (s *failingSocket) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func (s *failingSocket) Send(msg canbus.Frame) (int, error) {
+	select {
+	case err := <-s.fail:
+		return 0, err
+	default:
+		return len(msg.Data), nil
+	}
+}
+
+func (s *failingSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	select {
+	case frame := <-s.inbound:
+		return frame, nil
+	case err := <-s.fail:
+		return canbus.Frame{}, err
+	case <-ctx.Done():
+		return canbus.Frame{}, ctx.Err()
+	}
+}
+
+var testRecovery = conf.Recovery{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+
+func TestRecoveringSocket(t *testing.T) {
+	t.Run("reopens after receive error", func(t *testing.T) {
+		t.Parallel()
+
+		sockets := make(chan *failingSocket, 2)
+		first, second := newFailingSocket(), newFailingSocket()
+		sockets <- first
+		sockets <- second
+		socket := newRecoveringSocket(t, sockets)
+		assert.Equal(t, can.BusUp, *readWithTimeout(t, socket.BusStates()))
+
+		first.fail <- syscall.ENETDOWN
+		second.inbound <- canbus.Frame{ID: 0x180}
+		frame, err := socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x180), frame.ID)
+		assert.True(t, first.closed.Load(), "failed socket should be closed")
+	})
+
+	t.Run("reports bus state changes", func(t *testing.T) {
+		t.Parallel()
+
+		// The second socket fails to open once.
+		attempts := 0
+		first, second := newFailingSocket(), newFailingSocket()
+		socket, err := can.NewRecoveringSocket(func() (can.Socket, error) {
+			attempts++
+			switch attempts {
+			case 1:
+				return first, nil
+			case 2:
+				return nil, syscall.ENODEV
+			default:
+				return second, nil
+			}
+		}, testRecovery, zap.NewNop())
+		assert.NoError(t, err)
+		defer socket.Close()
+		states, other := socket.BusStates(), socket.BusStates()
+		assert.Equal(t, can.BusUp, *readWithTimeout(t, states))
+
+		assert.Equal(t, can.BusUp, socket.BusState())
+
+		first.fail <- syscall.ENETDOWN
+		go func() { _, _ = socket.RecvCtx(context.Background()) }()
+		assert.Equal(t, can.BusDown, *readWithTimeout(t, states))
+		assert.Equal(t, can.BusUp, *readWithTimeout(t, states))
+		assert.Equal(t, can.BusUp, socket.BusState())
+		assert.Equal(t, can.BusUp, *readWithTimeout(t, other), "every receiver gets the latest state")
+		second.inbound <- canbus.Frame{}
+	})
+
+	t.Run("keeps the backoff, if the reopened socket fails right away", func(t *testing.T) {
+		t.Parallel()
+
+		// Like an interface, which is down: opening succeeds, sending fails.
+		socket, err := can.NewRecoveringSocket(func() (can.Socket, error) {
+			s := newFailingSocket()
+			s.fail <- syscall.ENETDOWN
+			return s, nil
+		}, conf.Recovery{InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second}, zap.NewNop())
+		assert.NoError(t, err)
+		defer socket.Close()
+		states := socket.BusStates()
+		assert.Equal(t, can.BusUp, *readWithTimeout(t, states))
+
+		var downtimes []time.Duration
+		for i := 0; i < 4; i++ {
+			_, _ = socket.Send(canbus.Frame{ID: 0x190})
+			down := time.Now()
+			for {
+				if state := readWithTimeout(t, states); state == nil || *state == can.BusUp {
+					break
+				}
+			}
+			downtimes = append(downtimes, time.Since(down))
+		}
+		// 10ms, 20ms, 40ms, 80ms instead of 10ms each time.
+		assert.GreaterOrEqual(t, downtimes[3], 60*time.Millisecond, "the backoff should grow: %v", downtimes)
+	})
+
+	t.Run("skips sending while down", func(t *testing.T) {
+		t.Parallel()
+
+		sockets := make(chan *failingSocket, 1)
+		first := newFailingSocket()
+		sockets <- first
+		socket := newRecoveringSocket(t, sockets)
+
+		first.fail <- syscall.ENETDOWN
+		_, err := socket.Send(canbus.Frame{ID: 0x190})
+		assert.True(t, flowcontrol.IsCanSkip(err), "failed send should be skippable: %v", err)
+		_, err = socket.Send(canbus.Frame{ID: 0x190})
+		assert.True(t, flowcontrol.IsCanSkip(err), "send while down should be skippable: %v", err)
+	})
+
+	t.Run("passes send buffer full through", func(t *testing.T) {
+		t.Parallel()
+
+		sockets := make(chan *failingSocket, 1)
+		first := newFailingSocket()
+		sockets <- first
+		socket := newRecoveringSocket(t, sockets)
+
+		first.fail <- syscall.ENOBUFS
+		_, err := socket.Send(canbus.Frame{ID: 0x190})
+		assert.ErrorIs(t, err, syscall.ENOBUFS)
+		assert.False(t, first.closed.Load())
+	})
+
+	t.Run("reopens after bus-off", func(t *testing.T) {
+		t.Parallel()
+
+		sockets := make(chan *failingSocket, 2)
+		first, second := newFailingSocket(), newFailingSocket()
+		sockets <- first
+		sockets <- second
+		socket := newRecoveringSocket(t, sockets)
+
+		first.inbound <- canbus.Frame{ID: unix.CAN_ERR_BUSOFF, Kind: canbus.ERR}
+		frame, err := socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.ERR, frame.Kind, "error frame should be passed on")
+
+		second.inbound <- canbus.Frame{ID: 0x180}
+		frame, err = socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x180), frame.ID)
+	})
+
+	t.Run("fails if initial open fails", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := can.NewRecoveringSocket(func() (can.Socket, error) {
+			return nil, errors.New("no such device")
+		}, testRecovery, zap.NewNop())
+		assert.Error(t, err)
+	})
+
+	t.Run("returns on cancel while down", func(t *testing.T) {
+		t.Parallel()
+
+		sockets := make(chan *failingSocket, 1)
+		first := newFailingSocket()
+		sockets <- first
+		socket := newRecoveringSocket(t, sockets)
+
+		first.fail <- syscall.ENETDOWN
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := socket.RecvCtx(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// newRecoveringSocket creates a RecoveringSocket opening the sockets in order. Opening fails after the last socket.
+func newRecoveringSocket(t *testing.T, sockets chan *failingSocket) can.RecoveringSocket {
+	socket, err := can.NewRecoveringSocket(func() (can.Socket, error) {
+		select {
+		case s := <-sockets:
+			return s, nil
+		default:
+			return nil, syscall.ENODEV
+		}
+	}, testRecovery, zap.NewNop())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = socket.Close() })
+	return socket
+}
@@ -0,0 +1,166 @@
+package can
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	socketcandDefaultPort = "29536"
+	cannelloniDefaultPort = "20000"
+)
+
+var errSocketClosed = errors.New("socket closed")
+
+// Open opens a socket on iface. iface is either the name of a local interface (`can0`), the URL of a remote interface, or of a serial SLCAN adapter:
+//
+//	socketcand://host[:port]/can0
+//	cannelloni://host[:port][?local=[host]:port]
+//	slcan:///dev/ttyACM0[?bitrate=20000][&baud=115200]
+//
+// filters are only applied to local interfaces, see NewSocket.
+func Open(iface string, filters []unix.CanFilter, log *zap.Logger) (Socket, error) {
+	if !strings.Contains(iface, "://") {
+		return NewSocket(iface, filters)
+	}
+	u, err := url.Parse(iface)
+	if err != nil {
+		return nil, fmt.Errorf("CAN interface URL %q: %w", iface, err)
+	}
+	switch u.Scheme {
+	case "socketcand":
+		remoteIface := strings.Trim(u.Path, "/")
+		if remoteIface == "" {
+			return nil, fmt.Errorf("CAN interface URL %q: missing interface name in path", iface)
+		}
+		return NewSocketcandSocket(withDefaultPort(u.Host, socketcandDefaultPort), remoteIface, log)
+	case "cannelloni":
+		remote := withDefaultPort(u.Host, cannelloniDefaultPort)
+		local := u.Query().Get("local")
+		if local == "" {
+			_, port, _ := net.SplitHostPort(remote)
+			local = ":" + port
+		}
+		return NewCannelloniSocket(remote, local, log)
+	case "slcan":
+		bitrate, err := intParameter(u.Query(), "bitrate", slcanDefaultBitrate)
+		if err != nil {
+			return nil, fmt.Errorf("CAN interface URL %q: %w", iface, err)
+		}
+		baud, err := intParameter(u.Query(), "baud", slcanDefaultBaud)
+		if err != nil {
+			return nil, fmt.Errorf("CAN interface URL %q: %w", iface, err)
+		}
+		return NewSlcanSocket(u.Path, bitrate, baud, log)
+	default:
+		return nil, fmt.Errorf("CAN interface URL %q: unsupported scheme %q", iface, u.Scheme)
+	}
+}
+
+// Channel returns a plain channel name for iface, as used in candump logs: the interface name, or the last path element of an URL (`can0` for `socketcand://host/can0`, `ttyACM0` for `slcan:///dev/ttyACM0`). URLs without path are `can0`.
+func Channel(iface string) string {
+	if !strings.Contains(iface, "://") {
+		return iface
+	}
+	u, err := url.Parse(iface)
+	if err != nil {
+		return "can0"
+	}
+	if name := path.Base(strings.TrimRight(u.Path, "/")); name != "." && name != "/" {
+		return name
+	}
+	return "can0"
+}
+
+func intParameter(query url.Values, name string, defaultValue int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return i, nil
+}
+
+func withDefaultPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// inbox hands the frames received by the background goroutine of a remote or serial socket to RecvCtx.
+type inbox struct {
+	frames chan canbus.Frame
+	failed chan struct{}
+	err    error
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newInbox() *inbox {
+	return &inbox{
+		frames: make(chan canbus.Frame, 10),
+		failed: make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// put blocks until frame is received, and returns false if the inbox was closed meanwhile.
+func (i *inbox) put(frame canbus.Frame) bool {
+	select {
+	case i.frames <- frame:
+		return true
+	case <-i.closed:
+		return false
+	}
+}
+
+// fail makes RecvCtx return err, after all received frames were returned. Must be called only once, by the receiving goroutine.
+func (i *inbox) fail(err error) {
+	i.err = err
+	close(i.failed)
+}
+
+func (i *inbox) recv(ctx context.Context) (canbus.Frame, error) {
+	select {
+	case frame := <-i.frames:
+		return frame, nil
+	default:
+	}
+	select {
+	case frame := <-i.frames:
+		return frame, nil
+	case <-i.failed:
+		return canbus.Frame{}, i.err
+	case <-i.closed:
+		return canbus.Frame{}, errSocketClosed
+	case <-ctx.Done():
+		return canbus.Frame{}, ctx.Err()
+	}
+}
+
+func (i *inbox) close() {
+	i.once.Do(func() { close(i.closed) })
+}
+
+func (i *inbox) isClosed() bool {
+	select {
+	case <-i.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,187 @@
+package can_test
+
+import (
+	"bufio"
+	"context"
+	"echoctl/can"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("rejects malformed URLs", func(t *testing.T) {
+		t.Parallel()
+
+		for _, iface := range []string{"socketcand://localhost:1/", "foo://localhost/can0"} {
+			_, err := can.Open(iface, nil, zap.NewNop())
+			assert.Error(t, err, iface)
+		}
+	})
+}
+
+func TestChannel(t *testing.T) {
+	t.Run("names the channel of URLs for candump logs", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "can0", can.Channel("can0"))
+		assert.Equal(t, "can1", can.Channel("socketcand://host:29536/can1"))
+		assert.Equal(t, "ttyACM0", can.Channel("slcan:///dev/ttyACM0?bitrate=20000"))
+		assert.Equal(t, "can0", can.Channel("cannelloni://host:20000"))
+
+		_, _, iface, err := can.ParseCandump(can.FormatCandump(time.Unix(1, 0), can.Channel("slcan:///dev/ttyACM0?bitrate=20000"), canbus.Frame{ID: 0x180}))
+		assert.NoError(t, err)
+		assert.Equal(t, "ttyACM0", iface)
+	})
+}
+
+func TestSocketcandSocket(t *testing.T) {
+	t.Run("exchanges frames in raw mode", func(t *testing.T) {
+		t.Parallel()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		received := make(chan string, 1)
+		go serveSocketcand(t, listener, received)
+
+		socket, err := can.Open("socketcand://"+listener.Addr().String()+"/can0", nil, zap.NewNop())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer socket.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		frame, err := socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10, 0xFA, 0x01}, Kind: canbus.SFF}, frame)
+		frame, err = socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x300, Data: []byte{0x32, 0x10}, Kind: canbus.SFF}, frame, "space separated data")
+
+		_, err = socket.Send(canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA}})
+		assert.NoError(t, err)
+		assert.Equal(t, "< send 190 3 31 00 FA >", *readWithTimeout(t, received))
+	})
+
+	t.Run("fails if the interface can't be opened", func(t *testing.T) {
+		t.Parallel()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write([]byte("< hi >"))
+			_, _ = bufio.NewReader(conn).ReadString('>')
+			_, _ = conn.Write([]byte("< error could not open bus >"))
+		}()
+
+		_, err = can.NewSocketcandSocket(listener.Addr().String(), "can9", zap.NewNop())
+		assert.ErrorContains(t, err, "could not open bus")
+	})
+}
+
+// serveSocketcand is a stand-in socketcand server. It sends two frames after the handshake, and passes the next message on to received.
+func serveSocketcand(t *testing.T, listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	expect := func(message string) {
+		line, err := r.ReadString('>')
+		assert.NoError(t, err)
+		assert.Equal(t, message, strings.TrimSpace(line))
+	}
+
+	_, _ = conn.Write([]byte("< hi >"))
+	expect("< open can0 >")
+	_, _ = conn.Write([]byte("< ok >"))
+	expect("< rawmode >")
+	_, _ = conn.Write([]byte("< ok >< frame 180 1670790000.123456 3210FA01 >\n< frame 300 1670790000.223456 32 10 >"))
+	line, err := r.ReadString('>')
+	if err == nil {
+		received <- line
+	}
+}
+
+func TestCannelloniSocket(t *testing.T) {
+	t.Run("exchanges frames with peer", func(t *testing.T) {
+		t.Parallel()
+
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+		defer peer.Close()
+
+		socket, err := can.Open("cannelloni://"+peer.LocalAddr().String()+"?local=127.0.0.1:0", nil, zap.NewNop())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer socket.Close()
+
+		_, err = socket.Send(canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA}, Kind: canbus.SFF})
+		assert.NoError(t, err)
+		buffer := make([]byte, 100)
+		assert.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+		n, socketAddr, err := peer.ReadFromUDP(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{2, 0, 0, 0, 1, 0, 0, 0x01, 0x90, 3, 0x31, 0x00, 0xFA}, buffer[:n])
+
+		// Two frames in one packet: a standard frame, and an extended frame.
+		_, err = peer.WriteToUDP([]byte{2, 0, 7, 0, 2, 0, 0, 0x01, 0x80, 2, 0x32, 0x10, 0x80, 0x01, 0x23, 0x45, 1, 0xAA}, socketAddr)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		frame, err := socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10}, Kind: canbus.SFF}, frame)
+		frame, err = socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x12345, Data: []byte{0xAA}, Kind: canbus.EFF}, frame)
+	})
+
+	t.Run("exchanges remote frames without data", func(t *testing.T) {
+		t.Parallel()
+
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+		defer peer.Close()
+
+		socket, err := can.Open("cannelloni://"+peer.LocalAddr().String()+"?local=127.0.0.1:0", nil, zap.NewNop())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer socket.Close()
+
+		_, err = socket.Send(canbus.Frame{ID: 0x190, Data: make([]byte, 2), Kind: canbus.RTR})
+		assert.NoError(t, err)
+		buffer := make([]byte, 100)
+		assert.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+		n, socketAddr, err := peer.ReadFromUDP(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{2, 0, 0, 0, 1, 0x40, 0, 0x01, 0x90, 2}, buffer[:n], "The DLC should be sent without data")
+
+		// A remote frame with DLC 2, followed by a standard frame.
+		_, err = peer.WriteToUDP([]byte{2, 0, 7, 0, 2, 0x40, 0, 0x01, 0x80, 2, 0, 0, 0x01, 0x80, 2, 0x32, 0x10}, socketAddr)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		frame, err := socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x180, Kind: canbus.RTR}, frame)
+		frame, err = socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10}, Kind: canbus.SFF}, frame)
+	})
+}
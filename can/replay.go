@@ -0,0 +1,110 @@
+package can
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+type replaySocket struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	lineNo  int
+	speed   float64
+	log     *zap.Logger
+
+	// firstFrameAt is the timestamp of the first replayed frame, startedAt the wall clock time it was replayed at.
+	firstFrameAt time.Time
+	startedAt    time.Time
+}
+
+var _ Socket = (*replaySocket)(nil)
+
+// NewReplaySocket creates a Socket, which receives the frames of a `candump -L` log file. speed controls the pacing: 1 replays in real time, 10 ten times faster, and 0 as fast as possible. Sent frames are discarded. After the last frame, RecvCtx blocks until its context is cancelled, so the pipeline keeps running for inspection.
+func NewReplaySocket(fileName string, speed float64, log *zap.Logger) (Socket, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("replay speed must not be negative: %v", speed)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("opening replay file: %w", err)
+	}
+	return &replaySocket{
+		file:    file,
+		scanner: bufio.NewScanner(file),
+		speed:   speed,
+		log:     log,
+	}, nil
+}
+
+func (s *replaySocket) Close() error {
+	return s.file.Close()
+}
+
+func (s *replaySocket) Send(msg canbus.Frame) (int, error) {
+	s.log.Debug("replay: discarding sent frame", zap.Uint32("id", msg.ID))
+	return len(msg.Data), nil
+}
+
+func (s *replaySocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	frame, at, err := s.next()
+	if err != nil {
+		return canbus.Frame{}, err
+	}
+	if frame == nil {
+		s.log.Info("replay finished")
+		<-ctx.Done()
+		return canbus.Frame{}, ctx.Err()
+	}
+
+	if err := s.wait(ctx, at); err != nil {
+		return canbus.Frame{}, err
+	}
+	return *frame, nil
+}
+
+// next reads the next frame from the log. Returns a nil frame at the end of the log.
+func (s *replaySocket) next() (*canbus.Frame, time.Time, error) {
+	for s.scanner.Scan() {
+		s.lineNo++
+		line := strings.TrimSpace(s.scanner.Text())
+		if line == "" {
+			continue
+		}
+		frame, at, _, err := ParseCandump(line)
+		if err != nil {
+			return nil, at, fmt.Errorf("replay file line %d: %w", s.lineNo, err)
+		}
+		return &frame, at, nil
+	}
+	return nil, time.Time{}, s.scanner.Err()
+}
+
+// wait delays until the frame recorded at `at` is due, relative to the first replayed frame.
+func (s *replaySocket) wait(ctx context.Context, at time.Time) error {
+	if s.firstFrameAt.IsZero() {
+		s.firstFrameAt, s.startedAt = at, time.Now()
+		return nil
+	}
+	if s.speed == 0 {
+		return nil
+	}
+	dueAt := s.startedAt.Add(time.Duration(float64(at.Sub(s.firstFrameAt)) / s.speed))
+	delay := time.Until(dueAt)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package can_test
+
+import (
+	"context"
+	"echoctl/can"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCandump(t *testing.T) {
+	t.Run("parses what FormatCandump writes", func(t *testing.T) {
+		t.Parallel()
+
+		frames := []canbus.Frame{
+			{ID: 0x180, Data: []byte{0x32, 0x10, 0xFA, 0x01, 0x12, 0x0B, 0x00}, Kind: canbus.SFF},
+			{ID: 0x12345, Data: []byte{1}, Kind: canbus.EFF},
+			{ID: 0x123, Kind: canbus.RTR},
+			{ID: 0x40, Data: make([]byte, 8), Kind: canbus.ERR},
+		}
+		for _, frame := range frames {
+			at := time.Unix(1670790000, 123456000)
+			parsed, parsedAt, iface, err := can.ParseCandump(can.FormatCandump(at, "can0", frame))
+			assert.NoError(t, err)
+			assert.Equal(t, frame, parsed)
+			assert.True(t, at.Equal(parsedAt), "timestamps differ: %v != %v", at, parsedAt)
+			assert.Equal(t, "can0", iface)
+		}
+	})
+
+	t.Run("rejects malformed lines", func(t *testing.T) {
+		t.Parallel()
+
+		for _, line := range []string{"", "can0 180#00", "(1.0) can0 180", "(1.0) can0 XYZ#00", "(1.0) can0 180##100"} {
+			_, _, _, err := can.ParseCandump(line)
+			assert.Error(t, err, "line %q", line)
+		}
+	})
+}
+
+func TestReplaySocket(t *testing.T) {
+	t.Run("replays frames paced by their timestamps", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newReplaySocket(t, 10, "(100.000000) can0 180#3210FA011200\n\n(101.000000) can0 300#3210FA011201\n")
+
+		start := time.Now()
+		first, err := socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x180), first.ID)
+		second, err := socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x300), second.ID)
+		// One second of recording at speed 10.
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "second frame was replayed too early")
+	})
+
+	t.Run("blocks after the last frame until cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newReplaySocket(t, 0, "(100.000000) can0 180#00\n")
+		_, err := socket.RecvCtx(context.Background())
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = socket.RecvCtx(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("discards sent frames", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newReplaySocket(t, 0, "")
+		n, err := socket.Send(canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA, 0x01, 0x12, 0x00, 0x00}})
+		assert.NoError(t, err)
+		assert.Equal(t, 7, n)
+	})
+}
+
+func newReplaySocket(t *testing.T, speed float64, log string) can.Socket {
+	file := filepath.Join(t.TempDir(), "replay.log")
+	assert.NoError(t, os.WriteFile(file, []byte(log), 0644))
+	socket, err := can.NewReplaySocket(file, speed, zap.NewNop())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = socket.Close() })
+	return socket
+}
@@ -0,0 +1,109 @@
+package can
+
+import (
+	"context"
+	"echoctl/conf"
+	"echoctl/metrics"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"syscall"
+	"time"
+)
+
+type requester struct {
+	socket    Socket
+	collector metrics.Collector
+	listener  RequestListener
+	log       *zap.Logger
+}
+
+// RequestListener is notified of the requests sent by the Poller and the Requester, f.e. to attribute the received values to them. It must not block the sender.
+type RequestListener interface {
+	// RequestSent is called after a read request of command was sent, or a write request, if write is set.
+	RequestSent(command conf.Command, write bool)
+}
+
+// NopRequestListener ignores all requests.
+var NopRequestListener RequestListener = nopRequestListener{}
+
+type nopRequestListener struct{}
+
+func (nopRequestListener) RequestSent(conf.Command, bool) {}
+
+// Requester sends single read and write requests on demand, besides the Poller. Like the Poller, it does not wait for a reply. Safe for concurrent use.
+type Requester interface {
+	// Read sends the read request of command. It retries while the send buffer is full, until ctx is done.
+	Read(ctx context.Context, command conf.Command) error
+
+	// Write sends a request writing raw to command. It retries while the send buffer is full, until ctx is done.
+	Write(ctx context.Context, command conf.Command, raw int16) error
+}
+
+var _ Requester = (*requester)(nil)
+
+func NewRequester(socket Socket, collector metrics.Collector, listener RequestListener, log *zap.Logger) Requester {
+	return &requester{
+		socket:    socket,
+		collector: collector,
+		listener:  listener,
+		log:       log,
+	}
+}
+
+func (r *requester) Read(ctx context.Context, command conf.Command) error {
+	r.log.Debug("reading", zap.String("command", command.Id))
+	if err := r.send(ctx, toFrame(command.Request)); err != nil {
+		return err
+	}
+	r.collector.RequestSent(command, false)
+	r.listener.RequestSent(command, false)
+	return nil
+}
+
+func (r *requester) Write(ctx context.Context, command conf.Command, raw int16) error {
+	frame, err := WriteFrame(command, raw)
+	if err != nil {
+		return err
+	}
+	r.log.Info("writing", zap.String("command", command.Id), zap.Int16("raw", raw))
+	if err := r.send(ctx, frame); err != nil {
+		return err
+	}
+	r.collector.RequestSent(command, true)
+	r.listener.RequestSent(command, true)
+	return nil
+}
+
+func (r *requester) send(ctx context.Context, frame canbus.Frame) error {
+	for {
+		_, err := r.socket.Send(frame)
+		if !errors.Is(err, syscall.ENOBUFS) {
+			return err
+		}
+		select {
+		case <-time.After(RetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WriteFrame returns the frame writing raw to command. It is the read request with the low nibble of the first byte cleared, and raw following the register, like in the response: `30 00 FA 06 95 00 01` writes 1 to the register read by `31 00 FA 06 95 00 00`.
+func WriteFrame(command conf.Command, raw int16) (canbus.Frame, error) {
+	request := command.Request.CommandBytes
+	offset := len(command.Response.CommandBytes)
+	if offset < 2 || len(request) < offset {
+		return canbus.Frame{}, fmt.Errorf("command %s: request %X does not contain the register of response %X", command.Id, request, command.Response.CommandBytes)
+	}
+	data := make([]byte, len(request))
+	copy(data, request)
+	for len(data) < offset+2 {
+		data = append(data, 0)
+	}
+	data[0] &= 0xF0
+	binary.BigEndian.PutUint16(data[offset:], uint16(raw))
+	return canbus.Frame{ID: uint32(command.Request.CanId), Data: data}, nil
+}
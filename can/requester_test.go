@@ -0,0 +1,72 @@
+package can_test
+
+import (
+	"context"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/metrics"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"syscall"
+	"testing"
+)
+
+func TestRequester(t *testing.T) {
+	t.Run("retries reading while the send buffer is full", func(t *testing.T) {
+		t.Parallel()
+
+		socket := NewSocketMock()
+		socket.NextSendError(syscall.ENOBUFS)
+		requester := can.NewRequester(socket, metrics.Nop, can.NopRequestListener, zap.NewNop())
+
+		assert.NoError(t, requester.Read(context.Background(), NewCommand(0x190)))
+		frame := readWithTimeout(t, socket.Outbound())
+		assert.Equal(t, uint32(0x190), frame.ID)
+		assert.Equal(t, []byte{3, 7, 5}, frame.Data)
+	})
+
+	t.Run("passes other send errors through", func(t *testing.T) {
+		t.Parallel()
+
+		socket := NewSocketMock()
+		socket.NextSendError(syscall.ENETDOWN)
+		requester := can.NewRequester(socket, metrics.Nop, can.NopRequestListener, zap.NewNop())
+
+		assert.ErrorIs(t, requester.Write(context.Background(), writableCommand([]byte{0x31, 0x00, 0xFA, 0x06, 0x95, 0x00, 0x00}, []byte{0x32, 0x10, 0xFA, 0x06, 0x95}), 1), syscall.ENETDOWN)
+	})
+}
+
+func TestWriteFrame(t *testing.T) {
+	t.Run("writes after an extended register", func(t *testing.T) {
+		t.Parallel()
+
+		frame, err := can.WriteFrame(writableCommand([]byte{0x31, 0x00, 0xFA, 0x06, 0x95, 0x00, 0x00}, []byte{0x32, 0x10, 0xFA, 0x06, 0x95}), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x190, Data: []byte{0x30, 0x00, 0xFA, 0x06, 0x95, 0x00, 0x01}}, frame)
+	})
+
+	t.Run("writes after a short register", func(t *testing.T) {
+		t.Parallel()
+
+		frame, err := can.WriteFrame(writableCommand([]byte{0x61, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}, []byte{0xC2, 0x10, 0x0E}), -2)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x190, Data: []byte{0x60, 0x00, 0x0E, 0xFF, 0xFE, 0x00, 0x00}}, frame)
+	})
+
+	t.Run("fails without register", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := can.WriteFrame(writableCommand([]byte{0x31, 0x00}, []byte{0x32, 0x10, 0xFA, 0x06, 0x95}), 1)
+		assert.Error(t, err)
+	})
+}
+
+func writableCommand(request []byte, response []byte) conf.Command {
+	return conf.Command{
+		Id:       "air_purge",
+		Writable: true,
+		Request:  conf.RequestCommand{CanId: 0x190, CommandBytes: request},
+		Response: conf.RequestCommand{CanId: 0x180, CommandBytes: response},
+	}
+}
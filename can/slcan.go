@@ -0,0 +1,277 @@
+package can
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	slcanDefaultBitrate = 20000 // The bitrate of the HPSU.
+	slcanDefaultBaud    = 115200
+	slcanReplyTimeout   = 2 * time.Second
+	slcanOk             = '\r'
+	slcanError          = '\a'
+)
+
+// slcanBitrates maps CAN bitrates to the argument of the `S` command.
+var slcanBitrates = map[int]byte{
+	10000:   '0',
+	20000:   '1',
+	50000:   '2',
+	100000:  '3',
+	125000:  '4',
+	250000:  '5',
+	500000:  '6',
+	800000:  '7',
+	1000000: '8',
+}
+
+var serialBauds = map[int]uint32{
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	2000000: unix.B2000000,
+	3000000: unix.B3000000,
+}
+
+type slcanSocket struct {
+	file       *os.File
+	inbox      *inbox
+	writeMutex sync.Mutex
+	log        *zap.Logger
+}
+
+var _ Socket = (*slcanSocket)(nil)
+
+// NewSlcanSocket opens the serial device of an SLCAN adapter (Lawicel protocol, f.e. CANable or USBtin), and opens the CAN channel with bitrate. baud is the serial speed, USB adapters ignore it.
+func NewSlcanSocket(device string, bitrate int, baud int, log *zap.Logger) (Socket, error) {
+	bitrateCode, ok := slcanBitrates[bitrate]
+	if !ok {
+		return nil, fmt.Errorf("slcan: unsupported bitrate %d", bitrate)
+	}
+	speed, ok := serialBauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("slcan: unsupported baud rate %d", baud)
+	}
+
+	file, err := os.OpenFile(device, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("slcan: %w", err)
+	}
+	if err := makeRaw(file, speed); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("slcan: configuring %s: %w", device, err)
+	}
+	s := &slcanSocket{
+		file:  file,
+		inbox: newInbox(),
+		log:   log,
+	}
+	r := bufio.NewReader(file)
+	if err := s.setup(r, bitrateCode); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("slcan: setting up %s: %w", device, err)
+	}
+	go s.receive(r)
+	return s, nil
+}
+
+// setup closes the channel, in case it is still open from a previous run, sets the bitrate, and opens the channel.
+func (s *slcanSocket) setup(r *bufio.Reader, bitrateCode byte) error {
+	if err := s.file.SetReadDeadline(time.Now().Add(slcanReplyTimeout)); err != nil {
+		return err
+	}
+	if err := s.write("C"); err != nil {
+		return err
+	}
+	// Closing a closed channel is rejected with BEL, which is the usual state after plugging in the adapter.
+	if _, err := readSlcanReply(r); err != nil && !errors.Is(err, errSlcanRejected) {
+		return err
+	}
+	for _, command := range []string{"S" + string(bitrateCode), "O"} {
+		if err := s.write(command); err != nil {
+			return err
+		}
+		reply, err := readSlcanReply(r)
+		if err != nil {
+			return err
+		}
+		if reply != "" {
+			return fmt.Errorf("command %s rejected", command)
+		}
+	}
+	return s.file.SetReadDeadline(time.Time{})
+}
+
+func (s *slcanSocket) Close() error {
+	s.inbox.close()
+	// Close the channel, so the adapter stops buffering frames.
+	_ = s.write("C")
+	return s.file.Close()
+}
+
+func (s *slcanSocket) Send(msg canbus.Frame) (int, error) {
+	var command string
+	switch msg.Kind {
+	case canbus.EFF:
+		command = fmt.Sprintf("T%08X%d", msg.ID&unix.CAN_EFF_MASK, len(msg.Data))
+	case canbus.RTR:
+		command = fmt.Sprintf("r%03X%d", msg.ID&unix.CAN_SFF_MASK, len(msg.Data))
+	default:
+		command = fmt.Sprintf("t%03X%d", msg.ID&unix.CAN_SFF_MASK, len(msg.Data))
+	}
+	if msg.Kind != canbus.RTR {
+		command += fmt.Sprintf("%X", msg.Data)
+	}
+	if err := s.write(command); err != nil {
+		return 0, err
+	}
+	return len(msg.Data), nil
+}
+
+func (s *slcanSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	return s.inbox.recv(ctx)
+}
+
+func (s *slcanSocket) write(command string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.file.WriteString(command + "\r")
+	return err
+}
+
+func (s *slcanSocket) receive(r *bufio.Reader) {
+	for {
+		reply, err := readSlcanReply(r)
+		if errors.Is(err, errSlcanRejected) {
+			s.log.Warn("slcan: adapter rejected a command")
+			continue
+		}
+		if err != nil {
+			if !s.inbox.isClosed() {
+				s.inbox.fail(fmt.Errorf("slcan: %w", err))
+			}
+			return
+		}
+		if reply == "" || reply[0] == 'z' || reply[0] == 'Z' {
+			// Acknowledge of a command or transmission.
+			continue
+		}
+		frame, err := parseSlcanFrame(reply)
+		if err != nil {
+			s.log.Debug("slcan: ignoring message", zap.String("message", reply), zap.Error(err))
+			continue
+		}
+		if !s.inbox.put(frame) {
+			return
+		}
+	}
+}
+
+var errSlcanRejected = errors.New("command rejected")
+
+// readSlcanReply reads up to the next CR, and returns the message without CR. A BEL reply is returned as errSlcanRejected.
+func readSlcanReply(r *bufio.Reader) (string, error) {
+	var reply []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case slcanOk:
+			return string(reply), nil
+		case slcanError:
+			return "", errSlcanRejected
+		default:
+			reply = append(reply, b)
+		}
+	}
+}
+
+// parseSlcanFrame parses a received frame, f.e. `t18043210FA01`. An optional timestamp after the data is ignored.
+func parseSlcanFrame(message string) (canbus.Frame, error) {
+	var frame canbus.Frame
+	var idLength int
+	switch message[0] {
+	case 't':
+		frame.Kind, idLength = canbus.SFF, 3
+	case 'T':
+		frame.Kind, idLength = canbus.EFF, 8
+	case 'r':
+		frame.Kind, idLength = canbus.RTR, 3
+	case 'R':
+		frame.Kind, idLength = canbus.RTR, 8
+	default:
+		return frame, fmt.Errorf("not a frame")
+	}
+	if len(message) < 2+idLength {
+		return frame, fmt.Errorf("frame too short")
+	}
+	id, err := strconv.ParseUint(message[1:1+idLength], 16, 32)
+	if err != nil {
+		return frame, fmt.Errorf("malformed CAN ID: %w", err)
+	}
+	frame.ID = uint32(id)
+	length := int(message[1+idLength] - '0')
+	if length < 0 || length > 8 {
+		return frame, fmt.Errorf("malformed length")
+	}
+	if frame.Kind == canbus.RTR {
+		return frame, nil
+	}
+	data := message[2+idLength:]
+	if len(data) < 2*length {
+		return frame, fmt.Errorf("data too short")
+	}
+	frame.Data, err = hex.DecodeString(data[:2*length])
+	if err != nil {
+		return frame, fmt.Errorf("malformed data: %w", err)
+	}
+	return frame, nil
+}
+
+// makeRaw puts the serial device into raw mode with speed, like cfmakeraw(3).
+func makeRaw(file *os.File, speed uint32) error {
+	// Fd() would switch the file to blocking mode, and disable deadlines.
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		var termios *unix.Termios
+		termios, ioctlErr = unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if ioctlErr != nil {
+			return
+		}
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB | unix.CBAUD
+		termios.Cflag |= unix.CS8 | unix.CLOCAL | unix.CREAD | speed
+		termios.Ispeed, termios.Ospeed = speed, speed
+		termios.Cc[unix.VMIN], termios.Cc[unix.VTIME] = 1, 0
+		ioctlErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, termios)
+	})
+	if err != nil {
+		return err
+	}
+	return ioctlErr
+}
@@ -0,0 +1,117 @@
+package can_test
+
+import (
+	"bufio"
+	"context"
+	"echoctl/can"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSlcanSocket(t *testing.T) {
+	t.Run("sets up the adapter and exchanges frames", func(t *testing.T) {
+		t.Parallel()
+
+		adapter, device := openPty(t)
+		received := make(chan string, 10)
+		go serveSlcan(adapter, received, "\r")
+
+		socket, err := can.Open("slcan://"+device+"?bitrate=20000", nil, zap.NewNop())
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"C", "S1", "O"}, []string{*readWithTimeout(t, received), *readWithTimeout(t, received), *readWithTimeout(t, received)})
+
+		_, err = adapter.WriteString("t18043210FA01\rz\rT123456781AA1F40\r")
+		assert.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		frame, err := socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10, 0xFA, 0x01}, Kind: canbus.SFF}, frame)
+		frame, err = socket.RecvCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, canbus.Frame{ID: 0x12345678, Data: []byte{0xAA}, Kind: canbus.EFF}, frame, "timestamp is ignored")
+
+		_, err = socket.Send(canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA}})
+		assert.NoError(t, err)
+		assert.Equal(t, "t19033100FA", *readWithTimeout(t, received))
+
+		assert.NoError(t, socket.Close())
+		assert.Equal(t, "C", *readWithTimeout(t, received))
+	})
+
+	t.Run("opens the adapter with a closed channel", func(t *testing.T) {
+		t.Parallel()
+
+		adapter, device := openPty(t)
+		received := make(chan string, 10)
+		go serveSlcan(adapter, received, "\a")
+
+		socket, err := can.Open("slcan://"+device+"?bitrate=20000", nil, zap.NewNop())
+		if !assert.NoError(t, err, "Closing a closed channel should not fail opening") {
+			return
+		}
+		assert.Equal(t, []string{"C", "S1", "O"}, []string{*readWithTimeout(t, received), *readWithTimeout(t, received), *readWithTimeout(t, received)})
+		assert.NoError(t, socket.Close())
+	})
+
+	t.Run("rejects unsupported bitrate", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := can.Open("slcan:///dev/null?bitrate=12345", nil, zap.NewNop())
+		assert.ErrorContains(t, err, "unsupported bitrate")
+	})
+}
+
+// serveSlcan is a stand-in SLCAN adapter. It acknowledges every command with CR, except C, which it answers with closeReply, and passes it on to received.
+func serveSlcan(adapter *os.File, received chan<- string, closeReply string) {
+	r := bufio.NewReader(adapter)
+	for {
+		command, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		received <- command[:len(command)-1]
+		switch command[0] {
+		case 't':
+		case 'C':
+			_, _ = adapter.WriteString(closeReply)
+		default:
+			_, _ = adapter.WriteString("\r")
+		}
+	}
+}
+
+// openPty opens a pseudo-terminal pair. Returns the master, and the device name of the slave.
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals not available: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+	conn, err := master.SyscallConn()
+	assert.NoError(t, err)
+	var number int
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+			return
+		}
+		number, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err != nil || ioctlErr != nil {
+		t.Skipf("pseudo-terminals not available: %v %v", err, ioctlErr)
+	}
+	device := fmt.Sprintf("/dev/pts/%d", number)
+	if _, err := os.Stat(device); err != nil {
+		t.Skipf("pseudo-terminals not available: %v", err)
+	}
+	return master, device
+}
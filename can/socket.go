@@ -14,7 +14,8 @@ type Socket interface {
 	RecvCtx(ctx context.Context) (msg canbus.Frame, err error)
 }
 
-func NewSocket(iface string) (Socket, error) {
+// NewSocket opens a CAN socket on iface. The kernel drops all frames not matching one of filters, which saves wakeups. Without filters, all frames are received. Error frames are received regardless of filters.
+func NewSocket(iface string, filters []unix.CanFilter) (Socket, error) {
 	socket, err := canbus.New()
 	if err != nil {
 		return nil, fmt.Errorf("canbus.New(): %w", err)
@@ -23,6 +24,12 @@ func NewSocket(iface string) (Socket, error) {
 	if err != nil {
 		return nil, fmt.Errorf("socket.Bind(\"%s\"): %w", iface, err)
 	}
+	if len(filters) > 0 {
+		err = socket.SetFilter(filters)
+		if err != nil {
+			return nil, fmt.Errorf("SetFilter(%v): %w", filters, err)
+		}
+	}
 	err = socket.SetErrFilter(unix.CAN_ERR_MASK)
 	if err != nil {
 		return nil, fmt.Errorf("SetErrFilter(CAN_ERR_MASK): %w", err)
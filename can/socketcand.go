@@ -0,0 +1,181 @@
+package can
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	remoteDialTimeout      = 5 * time.Second
+	socketcandHandshakeTTL = 5 * time.Second
+)
+
+type socketcandSocket struct {
+	conn       net.Conn
+	inbox      *inbox
+	writeMutex sync.Mutex
+	log        *zap.Logger
+}
+
+var _ Socket = (*socketcandSocket)(nil)
+
+// NewSocketcandSocket connects to the socketcand server at address (host:port), and opens iface on the server in raw mode. See https://github.com/linux-can/socketcand/blob/master/doc/protocol.md.
+func NewSocketcandSocket(address string, iface string, log *zap.Logger) (Socket, error) {
+	conn, err := net.DialTimeout("tcp", address, remoteDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to socketcand: %w", err)
+	}
+	s := &socketcandSocket{
+		conn:  conn,
+		inbox: newInbox(),
+		log:   log,
+	}
+	r := bufio.NewReader(conn)
+	if err := s.handshake(r, iface); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("socketcand %s: %w", address, err)
+	}
+	go s.receive(r)
+	return s, nil
+}
+
+func (s *socketcandSocket) handshake(r *bufio.Reader, iface string) error {
+	if err := s.conn.SetDeadline(time.Now().Add(socketcandHandshakeTTL)); err != nil {
+		return err
+	}
+	if err := expectMessage(r, "hi"); err != nil {
+		return err
+	}
+	if err := s.write("open " + iface); err != nil {
+		return err
+	}
+	if err := expectMessage(r, "ok"); err != nil {
+		return fmt.Errorf("open %s: %w", iface, err)
+	}
+	if err := s.write("rawmode"); err != nil {
+		return err
+	}
+	if err := expectMessage(r, "ok"); err != nil {
+		return fmt.Errorf("rawmode: %w", err)
+	}
+	return s.conn.SetDeadline(time.Time{})
+}
+
+func (s *socketcandSocket) Close() error {
+	s.inbox.close()
+	return s.conn.Close()
+}
+
+func (s *socketcandSocket) Send(msg canbus.Frame) (int, error) {
+	var id string
+	if msg.Kind == canbus.EFF {
+		id = fmt.Sprintf("%08X", msg.ID&unix.CAN_EFF_MASK)
+	} else {
+		id = fmt.Sprintf("%03X", msg.ID&unix.CAN_SFF_MASK)
+	}
+	command := fmt.Sprintf("send %s %d", id, len(msg.Data))
+	for _, b := range msg.Data {
+		command += fmt.Sprintf(" %02X", b)
+	}
+	if err := s.write(command); err != nil {
+		return 0, err
+	}
+	return len(msg.Data), nil
+}
+
+func (s *socketcandSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	return s.inbox.recv(ctx)
+}
+
+func (s *socketcandSocket) write(command string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write([]byte("< " + command + " >"))
+	return err
+}
+
+func (s *socketcandSocket) receive(r *bufio.Reader) {
+	for {
+		fields, err := readMessage(r)
+		if err != nil {
+			if !s.inbox.isClosed() {
+				s.inbox.fail(fmt.Errorf("socketcand: %w", err))
+			}
+			return
+		}
+		switch fields[0] {
+		case "frame":
+			frame, err := parseSocketcandFrame(fields)
+			if err != nil {
+				s.log.Warn("socketcand: dropping malformed frame", zap.Error(err))
+				continue
+			}
+			if !s.inbox.put(frame) {
+				return
+			}
+		case "error":
+			s.log.Warn("socketcand: server error", zap.String("message", strings.Join(fields[1:], " ")))
+		default:
+			s.log.Debug("socketcand: ignoring message", zap.Strings("message", fields))
+		}
+	}
+}
+
+// readMessage reads the next `< ... >` message, and returns its fields. Messages are never empty.
+func readMessage(r *bufio.Reader) ([]string, error) {
+	for {
+		message, err := r.ReadString('>')
+		if err != nil {
+			return nil, err
+		}
+		message = strings.TrimSpace(message)
+		if !strings.HasPrefix(message, "<") {
+			return nil, fmt.Errorf("malformed message %q", message)
+		}
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(message, "<"), ">"))
+		if len(fields) > 0 {
+			return fields, nil
+		}
+	}
+}
+
+func expectMessage(r *bufio.Reader, expected string) error {
+	fields, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	if fields[0] != expected {
+		return fmt.Errorf("expected < %s >, got < %s >", expected, strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// parseSocketcandFrame parses the fields of `< frame 123 1670790000.123456 3210FA01 >`. socketcand writes the data as one hex string, but some implementations separate the bytes by spaces.
+func parseSocketcandFrame(fields []string) (canbus.Frame, error) {
+	if len(fields) < 3 {
+		return canbus.Frame{}, fmt.Errorf("frame message too short: %v", fields)
+	}
+	id, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return canbus.Frame{}, fmt.Errorf("malformed CAN ID %q: %w", fields[1], err)
+	}
+	frame := canbus.Frame{ID: uint32(id), Kind: canbus.SFF}
+	if len(fields[1]) > 3 {
+		frame.Kind = canbus.EFF
+	}
+	frame.Data, err = hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		return canbus.Frame{}, fmt.Errorf("malformed data %v: %w", fields[3:], err)
+	}
+	return frame, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"echoctl/conf"
+	"fmt"
+	"os"
+)
+
+func runCheckConfig(cliOpts commandLineOptions) {
+	var commandFiles []string
+	if cliOpts.Commands != "" {
+		commandFiles = []string{cliOpts.Commands}
+	}
+	problems := conf.CheckFiles(cliOpts.Config, commandFiles, os.Environ())
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if conf.HasErrors(problems) {
+		os.Exit(1)
+	}
+	fmt.Printf("ok, %d warnings\n", len(problems))
+}
@@ -2,6 +2,8 @@ package conf
 
 import (
 	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
 	"strconv"
 	"strings"
 )
@@ -36,6 +38,10 @@ func (c *CommandBytes) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (c CommandBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("% X", []byte(c)))
+}
+
 //go:generate go run github.com/dmarkham/enumer -type=Unit -json -trimprefix=Unit -transform lower
 type Unit int
 
@@ -81,6 +87,20 @@ func (c *CanId) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (c *CanId) UnmarshalYAML(value *yaml.Node) error {
+	num, err := strconv.ParseInt(value.Value, 16, 17)
+	if err != nil {
+		return fmt.Errorf("line %d: CAN ID %q: %w", value.Line, value.Value, err)
+	}
+
+	*c = CanId(num)
+	return nil
+}
+
+func (c CanId) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%X", uint32(c)))
+}
+
 type RequestCommand struct {
 	CanId        CanId        `json:"can_id"`
 	CommandBytes CommandBytes `json:"command"`
@@ -89,12 +109,12 @@ type RequestCommand struct {
 type Command struct {
 	Id          string            `json:"id"`
 	Name        map[string]string `json:"name"`
-	Description map[string]string `json:"description"`
+	Description map[string]string `json:"description,omitempty"`
 	Request     RequestCommand    `json:"request"`
 	Response    RequestCommand    `json:"response"`
-	Divisor     float32           `json:"divisor"`
+	Divisor     float32           `json:"divisor,omitempty"`
 	Writable    bool              `json:"writable"`
-	Unit        Unit              `json:"unit"`
-	Type        ValueType         `json:"type"`
-	ValueCode   map[string]int    `json:"value_code"`
+	Unit        Unit              `json:"unit,omitempty"`
+	Type        ValueType         `json:"type,omitempty"`
+	ValueCode   map[string]int    `json:"value_code,omitempty"`
 }
@@ -3,22 +3,69 @@ package conf
 import "time"
 
 type Configuration struct {
-	Can           Can
-	Mqtt          Mqtt
-	Subscriptions []Subscription
-	Lang          string
-	Homeassistant Homeassistant
+	// Commands are the layered command files, see ReadCommandFiles. Empty uses DefaultCommandFile.
+	Commands        []string
+	Can             Can
+	Mqtt            Mqtt
+	Subscriptions   []Subscription
+	Lang            string
+	Homeassistant   Homeassistant
+	UnknownCommands UnknownCommands `yaml:"unknown-commands"`
+	Http            Http
+	Supervision     Supervision
+	Dispatch        Dispatch
+	Influxdb        Influxdb
+}
+
+// CommandFiles returns the command files to read, see ReadCommandFiles.
+func (c Configuration) CommandFiles() []string {
+	if len(c.Commands) == 0 {
+		return []string{DefaultCommandFile}
+	}
+	return c.Commands
 }
 
 type Can struct {
-	Iface string
+	Iface    string
+	Record   Record
+	Recovery Recovery
+
+	// Promiscuous receives all frames on the bus. Otherwise, the kernel drops frames not sent by the CAN IDs of known responses. Enable it to collect unknown commands of all devices.
+	Promiscuous bool
+}
+
+// Recovery configures reopening the CAN socket, after the interface went down or the controller went bus-off.
+type Recovery struct {
+	// InitialBackoff is the delay before the first reopen attempt. It doubles with every failed attempt, and with every failure within MaxBackoff after a reopen.
+	InitialBackoff time.Duration `yaml:"initial-backoff"`
+
+	// MaxBackoff limits the delay between reopen attempts.
+	MaxBackoff time.Duration `yaml:"max-backoff"`
+}
+
+// Record configures recording of received frames in the `candump -L` log format.
+type Record struct {
+	// File to record to. Empty disables recording.
+	File string
+
+	// MaxSize is the file size in bytes, after which the file is rotated. Zero disables rotation.
+	MaxSize int64 `yaml:"max-size"`
+
+	// MaxFiles is the number of rotated files to keep.
+	MaxFiles int `yaml:"max-files"`
+
+	// Filter restricts recording to frames with these CAN IDs. Empty records all frames.
+	Filter []CanId
 }
 
 type Mqtt struct {
-	Server           string
-	ClientId         string `yaml:"client-id"`
-	User             string
-	Password         string
+	Server   string
+	ClientId string `yaml:"client-id"`
+	User     string
+	Password string
+
+	// PasswordFile is read for the password, f.e. a Docker secret, unless Password is set.
+	PasswordFile     string `yaml:"password-file"`
 	ValueTopicPrefix string `yaml:"value-topic-prefix"`
 }
 
@@ -30,3 +77,143 @@ type Subscription struct {
 type Homeassistant struct {
 	DiscoveryTopicPrefix string `yaml:"discovery-topic-prefix"`
 }
+
+// UnknownCommands configures the catalog of received frames, which did not match any known command.
+type UnknownCommands struct {
+	// File the catalog is written to on shutdown. Empty disables writing.
+	File string
+
+	// PublishInterval is the interval the catalog is published to mqtt. Zero disables publishing.
+	PublishInterval time.Duration `yaml:"publish-interval"`
+}
+
+// Influxdb configures writing the values to InfluxDB v2. Values are buffered while writing fails.
+type Influxdb struct {
+	// Url is the InfluxDB server written with the HTTP write API, f.e. `http://localhost:8086`.
+	Url    string
+	Org    string
+	Bucket string
+	Token  string
+
+	// TokenFile is read for the token, f.e. a Docker secret, unless Token is set.
+	TokenFile string `yaml:"token-file"`
+
+	// Udp is the address of a line protocol listener, f.e. Telegraf's socket_listener `localhost:8094`. It is used, if Url is empty. Both empty disable writing.
+	Udp string
+
+	// Measurement is the name of the measurement. Empty uses `echoctl`.
+	Measurement string
+
+	// BatchSize is the maximum number of values written at once. Zero uses 500.
+	BatchSize int `yaml:"batch-size"`
+
+	// FlushInterval is the maximum time a value waits for its batch, and the interval failed writes are retried in. Zero uses 10s.
+	FlushInterval time.Duration `yaml:"flush-interval"`
+
+	// MaxBuffer is the number of values buffered while writing fails. Further values replace the oldest ones. Zero uses 10000.
+	MaxBuffer int `yaml:"max-buffer"`
+}
+
+// Enabled returns true, if an Url or Udp address is configured.
+func (i Influxdb) Enabled() bool {
+	return i.Url != "" || i.Udp != ""
+}
+
+// Http configures the HTTP listener, serving Prometheus metrics on /metrics, the health and readiness on /healthz and /readyz, the API on /api/ and the dashboard on /dashboard/.
+type Http struct {
+	// Listen is the address to listen on, f.e. `:9100`. Empty disables the listener.
+	Listen string
+
+	// MaxSilence is the time without any response, after which /healthz fails. Zero disables the check.
+	MaxSilence time.Duration `yaml:"max-silence"`
+
+	// AllowWrites enables writing values with PUT /api/values/{id}.
+	AllowWrites bool `yaml:"allow-writes"`
+}
+
+// Restart selects, when a supervised component is restarted.
+type Restart string
+
+const (
+	// RestartPermanent restarts the component, whenever it exits. It is the default.
+	RestartPermanent Restart = "permanent"
+
+	// RestartTransient restarts the component, only if it failed with an error.
+	RestartTransient Restart = "transient"
+)
+
+// SupervisedComponents are the names of the components, which are restarted according to their RestartPolicy.
+var SupervisedComponents = []string{"publisher", "unknown-publisher", "bus-state-publisher", "health-publisher", "poller", "dispatcher", "reader", "recorder", "health-monitor", "discovery", "influxdb", "store", "http"}
+
+// Supervision configures restarting failed components, instead of shutting the daemon down.
+type Supervision struct {
+	// Default is the policy of all components without an entry in Components.
+	Default RestartPolicy
+
+	// Components replaces the Default policy per component, see SupervisedComponents.
+	Components map[string]RestartPolicy
+}
+
+// Policy returns the restart policy of component.
+func (s Supervision) Policy(component string) RestartPolicy {
+	if policy, ok := s.Components[component]; ok {
+		return policy
+	}
+	return s.Default
+}
+
+// RestartPolicy configures restarting a failed component. When it is exhausted, the daemon shuts down.
+type RestartPolicy struct {
+	Restart Restart
+
+	// MaxRestarts is the number of restarts allowed within Window. Zero disables restarting.
+	MaxRestarts int `yaml:"max-restarts"`
+
+	// Window is the time span restarts are counted in.
+	Window time.Duration
+
+	// InitialBackoff is the delay before the first restart. It doubles with every restart within Window.
+	InitialBackoff time.Duration `yaml:"initial-backoff"`
+
+	// MaxBackoff limits the delay before a restart.
+	MaxBackoff time.Duration `yaml:"max-backoff"`
+}
+
+// Overflow selects, what the dispatcher does with a value, when the queue of a sink is full.
+type Overflow string
+
+const (
+	// OverflowDrop drops the value, so a slow sink never stalls reading the bus. It is the default.
+	OverflowDrop Overflow = "drop"
+
+	// OverflowBlock waits until the sink takes the value, stalling the dispatcher and all other sinks meanwhile.
+	OverflowBlock Overflow = "block"
+)
+
+// KnownSinks are the names of the sinks of the dispatcher, see SinkPolicy.
+var KnownSinks = []string{"mqtt", "poller", "influxdb", "store"}
+
+// Dispatch configures the sinks receiving the values of the dispatcher.
+type Dispatch struct {
+	// Default is the policy of all sinks without an entry in Sinks.
+	Default SinkPolicy
+
+	// Sinks replaces the Default policy per sink, see KnownSinks.
+	Sinks map[string]SinkPolicy
+}
+
+// Policy returns the policy of sink.
+func (d Dispatch) Policy(sink string) SinkPolicy {
+	if policy, ok := d.Sinks[sink]; ok {
+		return policy
+	}
+	return d.Default
+}
+
+// SinkPolicy configures the queue of a sink.
+type SinkPolicy struct {
+	// Queue is the number of values buffered for the sink. Zero uses 100.
+	Queue int
+
+	Overflow Overflow
+}
@@ -0,0 +1,63 @@
+package conf
+
+import (
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"math"
+	"strings"
+)
+
+// Decode converts raw to the value of c: the label of a value code, or a number divided by the divisor. Unknown value codes are returned as number.
+func (c Command) Decode(raw int16) any {
+	switch c.Type {
+	case TypeValue:
+		for label, code := range c.ValueCode {
+			if code == int(raw) {
+				return label
+			}
+		}
+		return raw
+	case TypeFloat:
+		return float64(raw) / c.divisor()
+	case TypeLongint:
+		return math.Round(float64(raw) / c.divisor())
+	default:
+		return raw
+	}
+}
+
+// Encode converts value, as decoded from JSON, to the raw value of c.
+func (c Command) Encode(value any) (int16, error) {
+	switch c.Type {
+	case TypeValue:
+		label, ok := value.(string)
+		code, known := c.ValueCode[label]
+		if !ok || !known {
+			labels := maps.Keys(c.ValueCode)
+			slices.Sort(labels)
+			return 0, fmt.Errorf("value of %q must be one of %s, got %v", c.Id, strings.Join(labels, ", "), value)
+		}
+		return int16(code), nil
+	case TypeFloat, TypeLongint:
+		number, ok := value.(float64)
+		if !ok {
+			return 0, fmt.Errorf("value of %q must be a number, got %v", c.Id, value)
+		}
+		raw := math.Round(number * c.divisor())
+		if raw < math.MinInt16 || raw > math.MaxInt16 {
+			return 0, fmt.Errorf("value of %q is out of range: %v", c.Id, value)
+		}
+		return int16(raw), nil
+	default:
+		return 0, fmt.Errorf("command %q has no type", c.Id)
+	}
+}
+
+// divisor returns the divisor of c, 1 if it is not set.
+func (c Command) divisor() float64 {
+	if c.Divisor == 0 {
+		return 1
+	}
+	return float64(c.Divisor)
+}
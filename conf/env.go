@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix is the prefix of environment variables overriding configuration keys.
+const EnvPrefix = "ECHOCTL_"
+
+// ApplyEnvironment overrides configuration keys with the ECHOCTL_* variables of environ (`NAME=value` entries, like os.Environ). The variable name is the upper case key path, with `.` and `-` replaced by `_`, f.e. ECHOCTL_MQTT_SERVER for mqtt.server, or ECHOCTL_CAN_RECOVERY_MAX_BACKOFF for can.recovery.max-backoff. String values are taken literally, all other values are decoded as YAML, f.e. `ECHOCTL_SUBSCRIPTIONS=[{command: t_dhw, delay: 30s}]`. Unknown ECHOCTL_* variables are an error, as they most likely are typos.
+func ApplyEnvironment(configuration *Configuration, environ []string) error {
+	fields := make(map[string]reflect.Value)
+	collectEnvFields(reflect.ValueOf(configuration).Elem(), strings.TrimSuffix(EnvPrefix, "_"), fields)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("environment variable %s: unknown configuration key", name)
+		}
+		if err := setEnvField(field, value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// collectEnvFields maps the environment variable names of the fields of the struct v, and of nested structs, to the fields.
+func collectEnvFields(v reflect.Value, prefix string, fields map[string]reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			// The yaml package's default key.
+			key = strings.ToLower(field.Name)
+		}
+		name := prefix + "_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		fields[name] = v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			collectEnvFields(v.Field(i), name, fields)
+		}
+	}
+}
+
+func setEnvField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+	// Decode into a zero value, so lists replace, instead of extend, the configured list.
+	decoded := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), decoded.Interface()); err != nil {
+		return err
+	}
+	field.Set(decoded.Elem())
+	return nil
+}
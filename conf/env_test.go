@@ -0,0 +1,117 @@
+package conf_test
+
+import (
+	"echoctl/conf"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	t.Run("overrides keys", func(t *testing.T) {
+		t.Parallel()
+
+		configuration := conf.Configuration{
+			Mqtt:          conf.Mqtt{Server: "tcp://localhost:1883", User: "echoctl"},
+			Subscriptions: []conf.Subscription{{Command: "t_hs", Delay: time.Second}, {Command: "t_v1", Delay: time.Second}},
+		}
+		err := conf.ApplyEnvironment(&configuration, []string{
+			"HOME=/root",
+			"ECHOCTL_MQTT_SERVER=tcp://broker:1883",
+			"ECHOCTL_MQTT_PASSWORD=#secret: yes",
+			"ECHOCTL_MQTT_CLIENT_ID=echoctl-2",
+			"ECHOCTL_CAN_RECOVERY_MAX_BACKOFF=5m",
+			"ECHOCTL_CAN_PROMISCUOUS=true",
+			"ECHOCTL_CAN_RECORD_FILTER=[180, 300]",
+			"ECHOCTL_SUBSCRIPTIONS=[{command: t_dhw, delay: 30s}]",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, conf.Configuration{
+			Can: conf.Can{
+				Recovery:    conf.Recovery{MaxBackoff: 5 * time.Minute},
+				Record:      conf.Record{Filter: []conf.CanId{0x180, 0x300}},
+				Promiscuous: true,
+			},
+			Mqtt: conf.Mqtt{
+				Server:   "tcp://broker:1883",
+				ClientId: "echoctl-2",
+				User:     "echoctl",
+				Password: "#secret: yes",
+			},
+			Subscriptions: []conf.Subscription{{Command: "t_dhw", Delay: 30 * time.Second}},
+		}, configuration)
+	})
+
+	t.Run("rejects unknown and malformed variables", func(t *testing.T) {
+		t.Parallel()
+
+		var configuration conf.Configuration
+		assert.ErrorContains(t, conf.ApplyEnvironment(&configuration, []string{"ECHOCTL_MQTT_SERVR=tcp://broker:1883"}), "ECHOCTL_MQTT_SERVR")
+		assert.ErrorContains(t, conf.ApplyEnvironment(&configuration, []string{"ECHOCTL_CAN_PROMISCUOUS=maybe"}), "ECHOCTL_CAN_PROMISCUOUS")
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("reads the password from password-file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		passwordFile := filepath.Join(dir, "mqtt_password")
+		if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		configFile := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(configFile, []byte("mqtt:\n  user: echoctl\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		configuration, err := conf.LoadConfig(configFile, []string{"ECHOCTL_MQTT_PASSWORD_FILE=" + passwordFile})
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cret", configuration.Mqtt.Password)
+	})
+
+	t.Run("reads the influxdb token from token-file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		tokenFile := filepath.Join(dir, "influxdb_token")
+		if err := os.WriteFile(tokenFile, []byte("t0ken\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		configFile := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(configFile, []byte("influxdb:\n  token-file: "+tokenFile+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		configuration, err := conf.LoadConfig(configFile, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "t0ken", configuration.Influxdb.Token)
+	})
+
+	t.Run("prefers a set password over password-file", func(t *testing.T) {
+		t.Parallel()
+
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configFile, []byte("mqtt:\n  password-file: /nonexistent\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		configuration, err := conf.LoadConfig(configFile, []string{"ECHOCTL_MQTT_PASSWORD=s3cret"})
+		assert.NoError(t, err, "The password file should not be read")
+		assert.Equal(t, "s3cret", configuration.Mqtt.Password)
+	})
+
+	t.Run("fails if password-file is missing", func(t *testing.T) {
+		t.Parallel()
+
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configFile, []byte("mqtt:\n  password-file: /nonexistent\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := conf.LoadConfig(configFile, nil)
+		assert.ErrorContains(t, err, "mqtt.password-file")
+	})
+}
@@ -0,0 +1,107 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"os"
+)
+
+// DefaultCommandFile is the command database, if the configuration lists no command files.
+const DefaultCommandFile = "commands_hpsu.json"
+
+// ReadCommandFiles reads the command database from layered files. The first file is the base database, each following file is a JSON merge patch (RFC 7396) of it: a command present in a later file overrides the given fields of the existing command, f.e. `{"t_dhw": {"divisor": 100, "name": {"fr": "T-ECS"}}}`, or adds a new command. `null` removes a field, or disables a whole command, f.e. `{"t_room": null}`.
+func ReadCommandFiles(fileNames []string) (map[string]Command, error) {
+	layers := newCommandLayers()
+	for _, fileName := range fileNames {
+		if err := layers.apply(fileName); err != nil {
+			return nil, err
+		}
+	}
+	commands := make(map[string]Command, len(layers.commands))
+	for _, id := range layers.ids() {
+		command, err := layers.decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: command %s: %w", layers.origins[id], id, err)
+		}
+		commands[id] = command
+	}
+	return commands, nil
+}
+
+// commandLayers merges command files, keeping the commands undecoded, so a later file can fix a command which is invalid on its own.
+type commandLayers struct {
+	commands map[string]any
+
+	// origins maps command ids to the last file defining or overriding the command.
+	origins map[string]string
+}
+
+func newCommandLayers() *commandLayers {
+	return &commandLayers{
+		commands: make(map[string]any),
+		origins:  make(map[string]string),
+	}
+}
+
+func (l *commandLayers) apply(fileName string) error {
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	// Keep numbers as written.
+	decoder.UseNumber()
+	var layer map[string]any
+	if err := decoder.Decode(&layer); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	for id, patch := range layer {
+		if patch == nil {
+			delete(l.commands, id)
+			delete(l.origins, id)
+			continue
+		}
+		l.commands[id] = mergePatch(l.commands[id], patch)
+		l.origins[id] = fileName
+	}
+	return nil
+}
+
+func (l *commandLayers) ids() []string {
+	ids := maps.Keys(l.commands)
+	slices.Sort(ids)
+	return ids
+}
+
+func (l *commandLayers) decode(id string) (Command, error) {
+	buf, err := json.Marshal(l.commands[id])
+	if err != nil {
+		return Command{}, err
+	}
+	var command Command
+	err = json.Unmarshal(buf, &command)
+	return command, err
+}
+
+// mergePatch applies patch to target according to RFC 7396.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any, len(patchObject))
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
@@ -0,0 +1,72 @@
+package conf_test
+
+import (
+	"echoctl/conf"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	fileName := filepath.Join(dir, name)
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadCommandFiles(t *testing.T) {
+	t.Run("merges later files into earlier files", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		base := writeFile(t, dir, "base.json", `{
+  "t_dhw": {
+    "id": "t_dhw",
+    "name": {"de": "Warmwasser-Temperatur", "en": "T-DHW"},
+    "request": {"can_id": "190", "command": "31 00 0E 00 00 00 00"},
+    "response": {"can_id": "180", "command": "32 10 0E"},
+    "type": "float",
+    "divisor": 10,
+    "unit": "deg"
+  },
+  "t_room": {"id": "t_room", "type": "float", "divisor": 10}
+}`)
+		overrides := writeFile(t, dir, "overrides.json", `{
+  "t_dhw": {"divisor": 100, "name": {"fr": "T-ECS", "de": null}},
+  "t_room": null,
+  "mode": {"id": "mode", "response": {"can_id": "180", "command": "32 10 FA 01 12"}, "type": "value"}
+}`)
+
+		commands, err := conf.ReadCommandFiles([]string{base, overrides})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]conf.Command{
+			"t_dhw": {
+				Id:       "t_dhw",
+				Name:     map[string]string{"en": "T-DHW", "fr": "T-ECS"},
+				Request:  conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}},
+				Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}},
+				Type:     conf.TypeFloat,
+				Divisor:  100,
+				Unit:     conf.UnitDeg,
+			},
+			"mode": {
+				Id:       "mode",
+				Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}},
+				Type:     conf.TypeValue,
+			},
+		}, commands)
+	})
+
+	t.Run("names the file of an invalid command", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		base := writeFile(t, dir, "base.json", `{"t_dhw": {"id": "t_dhw", "type": "float"}}`)
+		overrides := writeFile(t, dir, "overrides.json", `{"t_dhw": {"unit": "furlong"}}`)
+
+		_, err := conf.ReadCommandFiles([]string{base, overrides})
+		assert.ErrorContains(t, err, overrides+": command t_dhw")
+	})
+}
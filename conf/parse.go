@@ -1,9 +1,12 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 )
 
 func ReadCommands(fileName string) (commands map[string]Command, err error) {
@@ -25,3 +28,60 @@ func ReadConfig(fileName string) (conf Configuration, err error) {
 	err = yaml.Unmarshal(buf, &conf)
 	return
 }
+
+// LoadConfig reads the configuration from fileName, applies the ECHOCTL_* overrides of environ (see ApplyEnvironment), and reads the secrets from their files.
+func LoadConfig(fileName string, environ []string) (Configuration, error) {
+	configuration, err := ReadConfig(fileName)
+	if err != nil {
+		return Configuration{}, err
+	}
+	if err := ApplyEnvironment(&configuration, environ); err != nil {
+		return Configuration{}, err
+	}
+	if err := readSecrets(&configuration); err != nil {
+		return Configuration{}, err
+	}
+	return configuration, nil
+}
+
+func readSecrets(configuration *Configuration) error {
+	if err := readSecret("mqtt.password-file", configuration.Mqtt.PasswordFile, &configuration.Mqtt.Password); err != nil {
+		return err
+	}
+	return readSecret("influxdb.token-file", configuration.Influxdb.TokenFile, &configuration.Influxdb.Token)
+}
+
+// readSecret sets secret to the content of fileName, unless fileName is empty, or secret is already set, f.e. by an environment variable. key names fileName in errors.
+func readSecret(key string, fileName string, secret *string) error {
+	if fileName == "" || *secret != "" {
+		return nil
+	}
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	// Secret files usually end with a newline, which is not part of the secret.
+	*secret = strings.TrimRight(string(buf), "\r\n")
+	return nil
+}
+
+// WriteCommands writes commands to fileName in the format of commands_hpsu.json.
+func WriteCommands(fileName string, commands map[string]Command) error {
+	buf, err := MarshalCommands(commands)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, buf, 0644)
+}
+
+// MarshalCommands encodes commands in the format of commands_hpsu.json.
+func MarshalCommands(commands map[string]Command) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(commands); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,303 @@
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is a problem found in the configuration or the command database. Key locates the problem in File, f.e. `subscriptions[3].command` or `t_dhw.divisor`.
+type Problem struct {
+	File     string
+	Key      string
+	Message  string
+	Severity Severity
+}
+
+func (p Problem) String() string {
+	location := p.File
+	switch {
+	case location == "":
+		location = p.Key
+	case p.Key != "":
+		location += ": " + p.Key
+	}
+	return fmt.Sprintf("%s: %s: %s", p.Severity, location, p.Message)
+}
+
+// HasErrors reports whether problems contain a problem of SeverityError.
+func HasErrors(problems []Problem) bool {
+	return slices.IndexFunc(problems, func(p Problem) bool { return p.Severity == SeverityError }) >= 0
+}
+
+// CheckFiles reads and validates the configuration with the overrides of environ, and the command database. commandFiles overrides the command files of the configuration, if not empty. Unlike LoadConfig and ReadCommandFiles, it does not stop at the first problem, but returns all problems found.
+func CheckFiles(configFile string, commandFiles []string, environ []string) []Problem {
+	configuration, problems := readConfigChecked(configFile)
+	if configuration == nil {
+		configuration = &Configuration{}
+	}
+	if err := ApplyEnvironment(configuration, environ); err != nil {
+		problems = append(problems, Problem{File: "environment", Message: err.Error(), Severity: SeverityError})
+	}
+	if err := readSecrets(configuration); err != nil {
+		problems = append(problems, Problem{File: configFile, Message: err.Error(), Severity: SeverityError})
+	}
+	if len(commandFiles) == 0 {
+		commandFiles = configuration.CommandFiles()
+	}
+	commands, origins, commandProblems := readCommandsChecked(commandFiles)
+	problems = append(problems, commandProblems...)
+	return append(problems, Validate(configFile, *configuration, commands, origins)...)
+}
+
+// Validate checks configuration and commands for problems, which would make the daemon fail, or silently miss values. origins maps command ids to the command file, problems of the command are reported for, and may be nil. Problems of commands, which are not subscribed, are reported as warnings.
+func Validate(configFile string, configuration Configuration, commands map[string]Command, origins map[string]string) []Problem {
+	v := validator{configFile: configFile, origins: origins}
+	subscribed := v.validateConfig(configuration, commands)
+	v.validateCommands(commands, subscribed, configuration.Lang)
+	return v.problems
+}
+
+type validator struct {
+	configFile string
+	origins    map[string]string
+	problems   []Problem
+}
+
+func (v *validator) config(severity Severity, key string, format string, args ...any) {
+	v.problems = append(v.problems, Problem{File: v.configFile, Key: key, Message: fmt.Sprintf(format, args...), Severity: severity})
+}
+
+func (v *validator) command(severity Severity, id string, field string, format string, args ...any) {
+	v.problems = append(v.problems, Problem{File: v.origins[id], Key: id + "." + field, Message: fmt.Sprintf(format, args...), Severity: severity})
+}
+
+// validateConfig returns the ids of the subscribed commands.
+func (v *validator) validateConfig(configuration Configuration, commands map[string]Command) map[string]bool {
+	if configuration.Can.Iface == "" {
+		v.config(SeverityError, "can.iface", "missing")
+	}
+	if configuration.Mqtt.Server == "" {
+		v.config(SeverityError, "mqtt.server", "missing")
+	}
+	if configuration.Lang == "" {
+		v.config(SeverityError, "lang", "missing")
+	}
+
+	subscribed := make(map[string]bool)
+	for i, subscription := range configuration.Subscriptions {
+		key := fmt.Sprintf("subscriptions[%d]", i)
+		if _, ok := commands[subscription.Command]; !ok {
+			v.config(SeverityError, key+".command", "command %q not found in the command database", subscription.Command)
+		}
+		if subscription.Delay <= 0 {
+			v.config(SeverityError, key+".delay", "must be positive, got %v", subscription.Delay)
+		}
+		if subscribed[subscription.Command] {
+			v.config(SeverityWarning, key+".command", "command %q is subscribed more than once", subscription.Command)
+		}
+		subscribed[subscription.Command] = true
+	}
+
+	v.validateRestartPolicy("supervision.default", configuration.Supervision.Default)
+	components := maps.Keys(configuration.Supervision.Components)
+	slices.Sort(components)
+	for _, component := range components {
+		key := "supervision.components." + component
+		if !slices.Contains(SupervisedComponents, component) {
+			v.config(SeverityError, key, "unknown component, expected one of %s", strings.Join(SupervisedComponents, ", "))
+		}
+		v.validateRestartPolicy(key, configuration.Supervision.Components[component])
+	}
+
+	v.validateSinkPolicy("dispatch.default", configuration.Dispatch.Default)
+	sinks := maps.Keys(configuration.Dispatch.Sinks)
+	slices.Sort(sinks)
+	for _, sink := range sinks {
+		key := "dispatch.sinks." + sink
+		if !slices.Contains(KnownSinks, sink) {
+			v.config(SeverityError, key, "unknown sink, expected one of %s", strings.Join(KnownSinks, ", "))
+		}
+		v.validateSinkPolicy(key, configuration.Dispatch.Sinks[sink])
+	}
+
+	v.validateInfluxdb(configuration.Influxdb)
+	return subscribed
+}
+
+func (v *validator) validateInfluxdb(influxdb Influxdb) {
+	if influxdb.Url != "" {
+		if u, err := url.Parse(influxdb.Url); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			v.config(SeverityError, "influxdb.url", "must be an http or https URL, got %q", influxdb.Url)
+		}
+		if influxdb.Org == "" {
+			v.config(SeverityError, "influxdb.org", "missing, required with influxdb.url")
+		}
+		if influxdb.Bucket == "" {
+			v.config(SeverityError, "influxdb.bucket", "missing, required with influxdb.url")
+		}
+		if influxdb.Udp != "" {
+			v.config(SeverityWarning, "influxdb.udp", "ignored, values are written to influxdb.url")
+		}
+	}
+	if influxdb.BatchSize < 0 {
+		v.config(SeverityError, "influxdb.batch-size", "must not be negative, got %d", influxdb.BatchSize)
+	}
+	if influxdb.MaxBuffer < 0 {
+		v.config(SeverityError, "influxdb.max-buffer", "must not be negative, got %d", influxdb.MaxBuffer)
+	}
+	if influxdb.FlushInterval < 0 {
+		v.config(SeverityError, "influxdb.flush-interval", "must not be negative, got %v", influxdb.FlushInterval)
+	}
+}
+
+func (v *validator) validateSinkPolicy(key string, policy SinkPolicy) {
+	if policy.Overflow != "" && policy.Overflow != OverflowDrop && policy.Overflow != OverflowBlock {
+		v.config(SeverityError, key+".overflow", "unknown overflow %q, expected %s or %s", policy.Overflow, OverflowDrop, OverflowBlock)
+	}
+	if policy.Queue < 0 {
+		v.config(SeverityError, key+".queue", "must not be negative, got %d", policy.Queue)
+	}
+}
+
+func (v *validator) validateRestartPolicy(key string, policy RestartPolicy) {
+	if policy.Restart != "" && policy.Restart != RestartPermanent && policy.Restart != RestartTransient {
+		v.config(SeverityError, key+".restart", "unknown restart %q, expected %s or %s", policy.Restart, RestartPermanent, RestartTransient)
+	}
+	if policy.MaxRestarts < 0 {
+		v.config(SeverityError, key+".max-restarts", "must not be negative, got %d", policy.MaxRestarts)
+	}
+}
+
+func (v *validator) validateCommands(commands map[string]Command, subscribed map[string]bool, lang string) {
+	ids := maps.Keys(commands)
+	slices.Sort(ids)
+	for _, id := range ids {
+		c := commands[id]
+		// Problems of unsubscribed commands only matter, once they are subscribed.
+		severity := SeverityWarning
+		if subscribed[id] {
+			severity = SeverityError
+		}
+
+		if c.Id != id {
+			v.command(SeverityWarning, id, "id", "%q differs from the key, values are published under the id, not the key", c.Id)
+		}
+		if len(c.Name) == 0 {
+			v.command(severity, id, "name", "missing")
+		} else if _, ok := c.Name[lang]; lang != "" && !ok {
+			v.command(SeverityWarning, id, "name", "missing %q translation", lang)
+		}
+		if len(c.Request.CommandBytes) == 0 {
+			v.command(severity, id, "request.command", "missing")
+		}
+		if len(c.Response.CommandBytes) == 0 {
+			v.command(severity, id, "response.command", "missing, values are never received")
+		}
+		if c.Type == TypeNoType {
+			v.command(severity, id, "type", "missing")
+		}
+		if (c.Type == TypeFloat || c.Type == TypeLongint) && c.Divisor == 0 {
+			// Every received value would fail to convert, no matter if subscribed.
+			v.command(SeverityError, id, "divisor", "missing, required for type %s", c.Type)
+		}
+	}
+	v.validateResponses(commands, ids)
+}
+
+// validateResponses finds ambiguous responses. The dispatcher compares the response as prefix of the received data, and picks the first matching command.
+func (v *validator) validateResponses(commands map[string]Command, ids []string) {
+	for i, id := range ids {
+		response := commands[id].Response
+		if len(response.CommandBytes) == 0 {
+			continue
+		}
+		for _, otherId := range ids[i+1:] {
+			other := commands[otherId].Response
+			if len(other.CommandBytes) == 0 || response.CanId != other.CanId {
+				continue
+			}
+			switch {
+			case bytes.Equal(response.CommandBytes, other.CommandBytes):
+				v.command(SeverityWarning, id, "response", "same as the response of command %q, values are dispatched to only one of them", otherId)
+			case bytes.HasPrefix(other.CommandBytes, response.CommandBytes), bytes.HasPrefix(response.CommandBytes, other.CommandBytes):
+				v.command(SeverityWarning, id, "response", "overlaps with the response of command %q, values may be dispatched to the wrong command", otherId)
+			}
+		}
+	}
+}
+
+// readCommandsChecked decodes each command separately, to report all malformed commands.
+func readCommandsChecked(fileNames []string) (map[string]Command, map[string]string, []Problem) {
+	var problems []Problem
+	layers := newCommandLayers()
+	for _, fileName := range fileNames {
+		if err := layers.apply(fileName); err != nil {
+			problems = append(problems, Problem{File: fileName, Message: err.Error(), Severity: SeverityError})
+		}
+	}
+
+	commands := make(map[string]Command, len(layers.commands))
+	for _, id := range layers.ids() {
+		command, err := layers.decode(id)
+		if err != nil {
+			problems = append(problems, Problem{File: layers.origins[id], Key: id, Message: err.Error(), Severity: SeverityError})
+			continue
+		}
+		commands[id] = command
+	}
+	return commands, layers.origins, problems
+}
+
+// readConfigChecked rejects unknown keys, which are most likely typos. Returns a nil configuration, if the file can't be parsed at all.
+func readConfigChecked(fileName string) (*Configuration, []Problem) {
+	problem := func(key string, message string) Problem {
+		return Problem{File: fileName, Key: key, Message: message, Severity: SeverityError}
+	}
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, []Problem{problem("", err.Error())}
+	}
+
+	var configuration Configuration
+	decoder := yaml.NewDecoder(bytes.NewReader(buf))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&configuration)
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		// Decoding continued after type errors. Report each.
+		var problems []Problem
+		for _, e := range typeError.Errors {
+			key, message, ok := strings.Cut(e, ": ")
+			if !ok {
+				key, message = "", e
+			}
+			problems = append(problems, problem(key, message))
+		}
+		return &configuration, problems
+	}
+	if err != nil {
+		return nil, []Problem{problem("", err.Error())}
+	}
+	return &configuration, nil
+}
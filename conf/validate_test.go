@@ -0,0 +1,183 @@
+package conf_test
+
+import (
+	"echoctl/conf"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const validConfig = `
+can:
+  iface: vcan0
+mqtt:
+  server: tcp://localhost:1883
+lang: en
+subscriptions:
+  - command: t_dhw
+    delay: 5s
+`
+
+const validCommands = `{
+  "t_dhw": {
+    "id": "t_dhw",
+    "name": {"de": "Warmwasser-Temperatur", "en": "T-DHW"},
+    "request": {"can_id": "190", "command": "31 00 0E 00 00 00 00"},
+    "response": {"can_id": "180", "command": "32 10 0E"},
+    "type": "float",
+    "divisor": 10,
+    "unit": "deg"
+  }
+}`
+
+func writeFiles(t *testing.T, config string, commands string) (string, string) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	commandsFile := filepath.Join(dir, "commands.json")
+	if err := os.WriteFile(configFile, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(commandsFile, []byte(commands), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return configFile, commandsFile
+}
+
+func messages(problems []conf.Problem) []string {
+	var result []string
+	for _, problem := range problems {
+		result = append(result, problem.Severity.String()+": "+problem.Key+": "+problem.Message)
+	}
+	return result
+}
+
+func TestCheckFiles(t *testing.T) {
+	t.Run("accepts valid files", func(t *testing.T) {
+		t.Parallel()
+
+		configFile, commandsFile := writeFiles(t, validConfig, validCommands)
+		assert.Empty(t, conf.CheckFiles(configFile, []string{commandsFile}, nil))
+	})
+
+	t.Run("accepts the shipped files", func(t *testing.T) {
+		t.Parallel()
+
+		problems := conf.CheckFiles("../config.yaml", []string{"../commands_hpsu.json"}, nil)
+		assert.False(t, conf.HasErrors(problems), messages(problems))
+	})
+
+	t.Run("reports all problems of the configuration", func(t *testing.T) {
+		t.Parallel()
+
+		configFile, commandsFile := writeFiles(t, `
+can:
+  ifac: vcan0
+mqtt:
+  server: tcp://localhost:1883
+lang: en
+subscriptions:
+  - command: t_dwh
+    delay: 5s
+  - command: t_dhw
+    delay: 0s
+supervision:
+  default:
+    restart: always
+  components:
+    publsher:
+      max-restarts: -1
+dispatch:
+  default:
+    overflow: wait
+  sinks:
+    webhook:
+      queue: -1
+influxdb:
+  url: localhost:8086
+  bucket: heatpump
+  batch-size: -1
+  flush-interval: -10s
+`, validCommands)
+		problems := conf.CheckFiles(configFile, []string{commandsFile}, nil)
+		assert.True(t, conf.HasErrors(problems))
+		assert.ElementsMatch(t, []string{
+			"error: line 3: field ifac not found in type conf.Can",
+			"error: can.iface: missing",
+			"error: subscriptions[0].command: command \"t_dwh\" not found in the command database",
+			"error: subscriptions[1].delay: must be positive, got 0s",
+			"error: supervision.default.restart: unknown restart \"always\", expected permanent or transient",
+			"error: supervision.components.publsher: unknown component, expected one of publisher, unknown-publisher, bus-state-publisher, health-publisher, poller, dispatcher, reader, recorder, health-monitor, discovery, influxdb, store, http",
+			"error: supervision.components.publsher.max-restarts: must not be negative, got -1",
+			"error: dispatch.default.overflow: unknown overflow \"wait\", expected drop or block",
+			"error: dispatch.sinks.webhook: unknown sink, expected one of mqtt, poller, influxdb, store",
+			"error: dispatch.sinks.webhook.queue: must not be negative, got -1",
+			"error: influxdb.url: must be an http or https URL, got \"localhost:8086\"",
+			"error: influxdb.org: missing, required with influxdb.url",
+			"error: influxdb.batch-size: must not be negative, got -1",
+			"error: influxdb.flush-interval: must not be negative, got -10s",
+		}, messages(problems))
+		for _, problem := range problems {
+			assert.Equal(t, configFile, problem.File)
+		}
+	})
+
+	t.Run("reports all problems of the commands", func(t *testing.T) {
+		t.Parallel()
+
+		configFile, commandsFile := writeFiles(t, validConfig, `{
+  "t_dhw": {
+    "id": "t_dhw",
+    "name": {"de": "Warmwasser-Temperatur"},
+    "request": {"can_id": "190", "command": "31 00 0E 00 00 00 00"},
+    "response": {"can_id": "180", "command": "32 10 0E"},
+    "type": "float",
+    "unit": "deg"
+  },
+  "t_dhw_copy": {
+    "id": "t_dhw_kopie",
+    "name": {"en": "T-DHW copy"},
+    "request": {"can_id": "190", "command": "31 00 0E 00 00 00 00"},
+    "response": {"can_id": "180", "command": "32 10 0E 00"},
+    "type": "value",
+    "unit": "deg"
+  },
+  "bad_unit": {
+    "id": "bad_unit",
+    "type": "value",
+    "unit": "furlong"
+  }
+}`)
+		problems := conf.CheckFiles(configFile, []string{commandsFile}, nil)
+		assert.True(t, conf.HasErrors(problems))
+		assert.ElementsMatch(t, []string{
+			"error: bad_unit: furlong does not belong to Unit values",
+			"warning: t_dhw.name: missing \"en\" translation",
+			"error: t_dhw.divisor: missing, required for type float",
+			"warning: t_dhw.response: overlaps with the response of command \"t_dhw_copy\", values may be dispatched to the wrong command",
+			"warning: t_dhw_copy.id: \"t_dhw_kopie\" differs from the key, values are published under the id, not the key",
+		}, messages(problems))
+	})
+
+	t.Run("reports unreadable files", func(t *testing.T) {
+		t.Parallel()
+
+		configFile, commandsFile := writeFiles(t, "can: [", "{")
+		problems := conf.CheckFiles(configFile, []string{commandsFile}, nil)
+		assert.Len(t, problems, 2+3)
+		assert.True(t, conf.HasErrors(problems))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("locates command problems by key without origins", func(t *testing.T) {
+		t.Parallel()
+
+		configuration := conf.Configuration{Can: conf.Can{Iface: "vcan0"}, Mqtt: conf.Mqtt{Server: "tcp://localhost:1883"}, Lang: "en"}
+		commands := map[string]conf.Command{"t_dhw": {Id: "t_dhw", Name: map[string]string{"en": "DHW"}, Type: conf.TypeFloat}}
+		problems := conf.Validate("config.yaml", configuration, commands, nil)
+		if assert.Len(t, problems, 3) {
+			assert.Equal(t, "error: t_dhw.divisor: missing, required for type float", problems[2].String())
+		}
+	})
+}
@@ -0,0 +1,200 @@
+// Package dashboard serves a web UI for commissioning: the subscribed values grouped by category with live updates, controls for writable commands, and a monitor of the received CAN frames.
+package dashboard
+
+import (
+	"echoctl/api"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/store"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// keepAliveInterval is the interval of comments sent on idle event streams, to keep proxies from closing them.
+const keepAliveInterval = 15 * time.Second
+
+//go:embed static
+var static embed.FS
+
+// categories are the names of the groups, in display order.
+var categories = []string{"Temperatures", "Pressures", "Flow rates", "Power", "Energy", "Percentages", "Times", "States", "Settings", "Other"}
+
+// Entry is a subscribed command in the State.
+type Entry struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Unit       conf.Unit  `json:"unit"`
+	Type       string     `json:"type"`
+	Writable   bool       `json:"writable"`
+	ValueCodes []string   `json:"value_codes,omitempty"`
+	Value      *api.Value `json:"value,omitempty"`
+}
+
+// Group is a category of entries.
+type Group struct {
+	Name    string  `json:"name"`
+	Entries []Entry `json:"entries"`
+}
+
+// State is the initial state of the dashboard. Later changes are sent as events.
+type State struct {
+	AllowWrites bool    `json:"allow_writes"`
+	Groups      []Group `json:"groups"`
+}
+
+type dashboard struct {
+	subscriptions []can.Subscription
+	lang          string
+	values        store.Store
+	allowWrites   bool
+	feed          Feed
+	files         http.Handler
+	log           *zap.Logger
+}
+
+// NewHandler creates the handler of the dashboard below /dashboard/. The UI reads and writes values with the API, see api.NewHandler. values are the latest values, names are in lang.
+func NewHandler(subscriptions []can.Subscription, lang string, values store.Store, allowWrites bool, feed Feed, log *zap.Logger) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return &dashboard{
+		subscriptions: subscriptions,
+		lang:          lang,
+		values:        values,
+		allowWrites:   allowWrites,
+		feed:          feed,
+		files:         http.StripPrefix("/dashboard/", http.FileServer(http.FS(files))),
+		log:           log,
+	}
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/dashboard/state":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.state())
+	case "/dashboard/events":
+		d.events(w, r)
+	default:
+		d.files.ServeHTTP(w, r)
+	}
+}
+
+// state returns the subscribed commands grouped by category, with their latest values.
+func (d *dashboard) state() State {
+	entries := d.values.All()
+	groups := make(map[string][]Entry)
+	for _, subscription := range d.subscriptions {
+		command := subscription.Command
+		name, ok := command.Name[d.lang]
+		if !ok {
+			name = command.Id
+		}
+		entry := Entry{Id: command.Id, Name: name, Unit: command.Unit, Type: command.Type.String(), Writable: command.Writable}
+		if command.Type == conf.TypeValue {
+			entry.ValueCodes = maps.Keys(command.ValueCode)
+			slices.SortFunc(entry.ValueCodes, func(a, b string) bool { return command.ValueCode[a] < command.ValueCode[b] })
+		}
+		if latest, ok := entries[command.Id]; ok {
+			value := api.ToValue(latest, d.lang)
+			entry.Value = &value
+		}
+		group := category(command)
+		groups[group] = append(groups[group], entry)
+	}
+
+	state := State{AllowWrites: d.allowWrites}
+	for _, name := range categories {
+		if entries, ok := groups[name]; ok {
+			state.Groups = append(state.Groups, Group{Name: name, Entries: entries})
+		}
+	}
+	return state
+}
+
+// category returns the dashboard group of command: writable commands are settings, value codes are states, all others are grouped by unit.
+func category(command conf.Command) string {
+	switch {
+	case command.Writable:
+		return "Settings"
+	case command.Type == conf.TypeValue:
+		return "States"
+	}
+	switch command.Unit {
+	case conf.UnitDeg:
+		return "Temperatures"
+	case conf.UnitBar:
+		return "Pressures"
+	case conf.UnitLh:
+		return "Flow rates"
+	case conf.UnitW, conf.UnitKw:
+		return "Power"
+	case conf.UnitWh, conf.UnitKwh:
+		return "Energy"
+	case conf.UnitPercent:
+		return "Percentages"
+	case conf.UnitSec, conf.UnitMin, conf.UnitHour:
+		return "Times"
+	default:
+		return "Other"
+	}
+}
+
+// events streams new values and the events of the feed as Server-Sent Events, until the request is cancelled.
+func (d *dashboard) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := d.feed.Subscribe()
+	defer cancel()
+	values, unsubscribe := d.values.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case entry := <-values:
+			if err := d.writeEvent(w, Event{Kind: KindValue, Data: api.ToValue(entry, d.lang)}); err != nil {
+				return
+			}
+		case event := <-events:
+			if err := d.writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes event to an event stream. Events failing to encode are logged and skipped.
+func (d *dashboard) writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		d.log.Error("encoding event", zap.Error(err))
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data)
+	return err
+}
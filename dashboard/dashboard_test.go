@@ -0,0 +1,109 @@
+package dashboard_test
+
+import (
+	"bufio"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/dashboard"
+	"echoctl/dispatcher"
+	"echoctl/store"
+	"encoding/json"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var subscriptions = []can.Subscription{
+	{Command: conf.Command{Id: "t_dhw", Name: map[string]string{"en": "DHW temperature"}, Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg}},
+	{Command: conf.Command{Id: "mode", Type: conf.TypeValue, ValueCode: map[string]int{"heating": 1, "standby": 0}, Writable: true}},
+	{Command: conf.Command{Id: "t_flow", Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg}},
+	{Command: conf.Command{Id: "pump", Type: conf.TypeFloat, Unit: conf.UnitPercent}},
+}
+
+func TestDashboard(t *testing.T) {
+	t.Run("groups the subscribed commands", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		values.Put(dispatcher.CommandValue{Cmd: subscriptions[0].Command, Value: 485})
+		server := httptest.NewServer(dashboard.NewHandler(subscriptions, "en", values, true, dashboard.NewFeed(), zap.NewNop()))
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/dashboard/state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var state dashboard.State
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&state))
+
+		assert.True(t, state.AllowWrites)
+		assert.Len(t, state.Groups, 3)
+		assert.Equal(t, "Temperatures", state.Groups[0].Name)
+		assert.Equal(t, "Percentages", state.Groups[1].Name)
+		assert.Equal(t, "Settings", state.Groups[2].Name)
+
+		temperatures := state.Groups[0].Entries
+		assert.Equal(t, "DHW temperature", temperatures[0].Name)
+		assert.Equal(t, 48.5, temperatures[0].Value.Value)
+		assert.Equal(t, "t_flow", temperatures[1].Name)
+		assert.Nil(t, temperatures[1].Value)
+
+		mode := state.Groups[2].Entries[0]
+		assert.True(t, mode.Writable)
+		assert.Equal(t, []string{"standby", "heating"}, mode.ValueCodes)
+	})
+
+	t.Run("serves the static files", func(t *testing.T) {
+		t.Parallel()
+
+		server := httptest.NewServer(dashboard.NewHandler(subscriptions, "en", store.NewStore(nil, nil), false, dashboard.NewFeed(), zap.NewNop()))
+		defer server.Close()
+
+		for _, path := range []string{"/dashboard/", "/dashboard/app.js", "/dashboard/style.css"} {
+			response, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = response.Body.Close()
+			assert.Equal(t, http.StatusOK, response.StatusCode, path)
+		}
+	})
+
+	t.Run("streams values and frames", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		feed := dashboard.NewFeed()
+		server := httptest.NewServer(dashboard.NewHandler(subscriptions, "en", values, false, feed, zap.NewNop()))
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/dashboard/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+		values.Put(dispatcher.CommandValue{Cmd: subscriptions[0].Command, Value: 485})
+		feed.FrameReceived(canbus.Frame{ID: 0x180, Data: []byte{0x31, 0x00, 0xFA}})
+
+		lines := bufio.NewScanner(response.Body)
+		var received []string
+		for len(received) < 4 && lines.Scan() {
+			if lines.Text() != "" {
+				received = append(received, lines.Text())
+			}
+		}
+		// Values and frames are streamed in any order.
+		stream := strings.Join(received, "\n")
+		assert.Contains(t, stream, "event: value\ndata: {\"id\":\"t_dhw\"")
+		assert.Contains(t, stream, `"value":48.5`)
+		assert.Contains(t, stream, "event: frame\ndata: {")
+		assert.Contains(t, stream, `"can_id":"180","data":"31 00 FA"`)
+	})
+}
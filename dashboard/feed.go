@@ -0,0 +1,87 @@
+package dashboard
+
+import (
+	"fmt"
+	"github.com/go-daq/canbus"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events buffered per subscriber. Further events are dropped.
+const subscriberBuffer = 100
+
+// Kinds of events.
+const (
+	KindValue = "value"
+	KindFrame = "frame"
+)
+
+// Event is sent to the connected dashboards. Data is an api.Value or a Frame. Values are taken from the store.Store, the Feed only sends frames.
+type Event struct {
+	Kind string
+	Data any
+}
+
+// Frame is a received CAN frame.
+type Frame struct {
+	At    time.Time `json:"at"`
+	CanId string    `json:"can_id"`
+	Data  string    `json:"data"`
+}
+
+type feed struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// Feed is a dispatcher.FrameListener, which forwards received frames to the subscribed dashboards. It never blocks the caller: events are dropped for slow subscribers.
+type Feed interface {
+	// FrameReceived sends frame to the subscribers.
+	FrameReceived(frame canbus.Frame)
+
+	// Subscribe returns a channel receiving the events, and a function cancelling the subscription.
+	Subscribe() (<-chan Event, func())
+}
+
+var _ Feed = (*feed)(nil)
+
+// NewFeed creates a Feed.
+func NewFeed() Feed {
+	return &feed{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+func (f *feed) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.subscribers[events] = struct{}{}
+	return events, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.subscribers, events)
+	}
+}
+
+func (f *feed) FrameReceived(frame canbus.Frame) {
+	f.send(KindFrame, func() any {
+		return Frame{At: time.Now(), CanId: fmt.Sprintf("%X", frame.ID), Data: fmt.Sprintf("% X", frame.Data)}
+	})
+}
+
+// send sends the event with the data returned by data to all subscribers. data is only called, if there are subscribers.
+func (f *feed) send(kind string, data func() any) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.subscribers) == 0 {
+		return
+	}
+	event := Event{Kind: kind, Data: data()}
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+"use strict";
+
+// maxFrames is the number of frames kept in the monitor.
+const maxFrames = 200;
+
+const rows = new Map();
+let allowWrites = false;
+
+function formatValue(value) {
+  if (!value) {
+    return "–";
+  }
+  if (typeof value.value === "number") {
+    return value.unit ? `${value.value} ${value.unit}` : `${value.value}`;
+  }
+  return value.value;
+}
+
+function formatAge(at) {
+  const seconds = Math.round((Date.now() - new Date(at).getTime()) / 1000);
+  if (seconds < 60) {
+    return `${seconds}s ago`;
+  }
+  if (seconds < 3600) {
+    return `${Math.round(seconds / 60)}m ago`;
+  }
+  return `${Math.round(seconds / 3600)}h ago`;
+}
+
+function element(tag, properties, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, properties);
+  e.append(...children);
+  return e;
+}
+
+async function request(method, url, body) {
+  const response = await fetch(url, {
+    method,
+    headers: body === undefined ? {} : {"Content-Type": "application/json"},
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const json = await response.json();
+  if (!response.ok) {
+    throw new Error(json.error || response.statusText);
+  }
+  return json;
+}
+
+function show(row, value) {
+  row.value = value;
+  row.valueCell.textContent = formatValue(value);
+  row.ageCell.textContent = value ? formatAge(value.at) : "";
+  row.ageCell.title = value ? value.source : "";
+  row.tr.classList.toggle("stale", Boolean(value && value.stale));
+}
+
+function update(value) {
+  const row = rows.get(value.id);
+  if (!row) {
+    return;
+  }
+  show(row, value);
+  row.tr.classList.remove("updated");
+  void row.tr.offsetWidth;
+  row.tr.classList.add("updated");
+}
+
+function control(entry, row) {
+  const input = entry.value_codes
+    ? element("select", {}, ...entry.value_codes.map((code) => element("option", {value: code}, code)))
+    : element("input", {type: "number", step: "any"});
+  const button = element("button", {textContent: "write"});
+  input.disabled = button.disabled = !allowWrites;
+  if (!allowWrites) {
+    button.title = "writes are disabled, see http.allow-writes";
+  }
+  button.addEventListener("click", async () => {
+    const value = entry.value_codes ? input.value : Number(input.value);
+    row.errorCell.textContent = "";
+    button.disabled = true;
+    try {
+      update(await request("PUT", `../api/values/${encodeURIComponent(entry.id)}`, {value}));
+    } catch (e) {
+      row.errorCell.textContent = e.message;
+    } finally {
+      button.disabled = false;
+    }
+  });
+  return element("span", {}, input, button);
+}
+
+function renderEntry(entry) {
+  const tr = element("tr");
+  const row = {
+    tr,
+    value: null,
+    valueCell: element("td", {className: "value"}),
+    ageCell: element("td", {className: "age"}),
+    errorCell: element("span", {className: "error"}),
+  };
+  rows.set(entry.id, row);
+
+  const refresh = element("button", {textContent: "↻", title: "read from the heat pump"});
+  refresh.addEventListener("click", async () => {
+    row.errorCell.textContent = "";
+    refresh.disabled = true;
+    try {
+      update(await request("GET", `../api/values/${encodeURIComponent(entry.id)}?refresh=true`));
+    } catch (e) {
+      row.errorCell.textContent = e.message;
+    } finally {
+      refresh.disabled = false;
+    }
+  });
+
+  const actions = element("td", {}, refresh);
+  if (entry.writable) {
+    actions.append(control(entry, row));
+  }
+  actions.append(row.errorCell);
+  tr.append(element("td", {textContent: entry.name, title: entry.id}), row.valueCell, row.ageCell, actions);
+  show(row, entry.value);
+  return tr;
+}
+
+function render(state) {
+  allowWrites = state.allow_writes;
+  rows.clear();
+  const groups = document.getElementById("groups");
+  groups.replaceChildren(...(state.groups || []).map((group) =>
+    element("div", {className: "group"},
+      element("h2", {textContent: group.name}),
+      element("table", {}, element("tbody", {}, ...group.entries.map(renderEntry))))));
+}
+
+function addFrame(frame) {
+  if (document.getElementById("pause").checked) {
+    return;
+  }
+  const filter = document.getElementById("filter").value.trim().toUpperCase();
+  if (filter && !frame.can_id.includes(filter) && !frame.data.includes(filter)) {
+    return;
+  }
+  const frames = document.getElementById("frames");
+  frames.prepend(element("tr", {},
+    element("td", {textContent: new Date(frame.at).toLocaleTimeString()}),
+    element("td", {textContent: frame.can_id}),
+    element("td", {textContent: frame.data})));
+  while (frames.childElementCount > maxFrames) {
+    frames.lastElementChild.remove();
+  }
+}
+
+function connect() {
+  const connection = document.getElementById("connection");
+  const events = new EventSource("events");
+  events.addEventListener("open", async () => {
+    connection.textContent = "connected";
+    connection.className = "connected";
+    // Values received while disconnected are only in the state.
+    render(await request("GET", "state"));
+  });
+  events.addEventListener("error", () => {
+    connection.textContent = "disconnected";
+    connection.className = "disconnected";
+  });
+  events.addEventListener("value", (e) => update(JSON.parse(e.data)));
+  events.addEventListener("frame", (e) => addFrame(JSON.parse(e.data)));
+}
+
+document.getElementById("clear").addEventListener("click", () => document.getElementById("frames").replaceChildren());
+setInterval(() => rows.forEach((row) => {
+  if (row.value) {
+    row.ageCell.textContent = formatAge(row.value.at);
+  }
+}), 1000);
+connect();
@@ -3,57 +3,112 @@ package dispatcher
 import (
 	"echoctl/conf"
 	"echoctl/flowcontrol"
+	"echoctl/metrics"
 	"encoding/binary"
 	"github.com/go-daq/canbus"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
 	tombPkg "gopkg.in/tomb.v2"
+	"sync"
+	"time"
 )
 
 type CommandValue struct {
 	Cmd   conf.Command
 	Value int16
+
+	// At is the time the frame was received.
+	At time.Time
 }
 
 type dispatcher struct {
 	inbound         <-chan canbus.Frame
 	commands        []conf.Command
-	toRequestor     chan<- conf.Command
-	toMqttPublisher chan<- CommandValue
 	tomb            *tombPkg.Tomb
 	log             *zap.Logger
 	unknownCommands *unknownCommandCollector
+	collector       metrics.Collector
+
+	mutex     sync.Mutex
+	sinks     []*sink
+	listeners []FrameListener
+}
+
+// FrameListener is notified of every frame received by the Dispatcher, f.e. to show the bus traffic. It must not block the Dispatcher.
+type FrameListener interface {
+	FrameReceived(frame canbus.Frame)
 }
 
 type Dispatcher interface {
+	// Dispatch starts dispatching. It can be called again after the returned tomb died, to restart dispatching. The catalog of unknown commands is kept.
 	Dispatch() *tombPkg.Tomb
+
+	// UnknownCommands returns the catalog of received frames, which did not match any known command. Safe to call from any go routine.
+	UnknownCommands() []UnknownCommand
+
+	// Register adds a Sink named name, receiving every dispatched value. Register sinks before the first Dispatch, values dispatched before are not queued.
+	Register(name string, policy conf.SinkPolicy) Sink
+
+	// Sinks returns the registered sinks. Safe to call from any go routine.
+	Sinks() []Sink
+
+	// Listen adds listener, notified of every received frame, known or not. Add listeners before the first Dispatch.
+	Listen(listener FrameListener)
 }
 
 var _ Dispatcher = (*dispatcher)(nil)
 
-func NewDispatcher(inbound <-chan canbus.Frame, commands []conf.Command, toRequestor chan<- conf.Command, toMqttPublisher chan<- CommandValue, log *zap.Logger) Dispatcher {
-	tomb := new(tombPkg.Tomb)
-
+func NewDispatcher(inbound <-chan canbus.Frame, commands []conf.Command, collector metrics.Collector, log *zap.Logger) Dispatcher {
 	return &dispatcher{
 		inbound:         inbound,
 		commands:        commands,
-		toRequestor:     toRequestor,
-		toMqttPublisher: toMqttPublisher,
-		tomb:            tomb,
 		log:             log,
 		unknownCommands: newUnknownCommandCollector(log),
+		collector:       collector,
 	}
 }
 
 func (d *dispatcher) Dispatch() *tombPkg.Tomb {
+	d.tomb = new(tombPkg.Tomb)
 	d.tomb.Go(d.dispatch)
 	return d.tomb
 }
 
+func (d *dispatcher) UnknownCommands() []UnknownCommand {
+	return d.unknownCommands.snapshot()
+}
+
+func (d *dispatcher) Register(name string, policy conf.SinkPolicy) Sink {
+	s := newSink(name, policy, d.collector, d.log)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.sinks = append(d.sinks, s)
+	return s
+}
+
+func (d *dispatcher) Sinks() []Sink {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	result := make([]Sink, len(d.sinks))
+	for i, s := range d.sinks {
+		result[i] = s
+	}
+	return result
+}
+
+func (d *dispatcher) Listen(listener FrameListener) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.listeners = append(d.listeners, listener)
+}
+
 func (d *dispatcher) dispatch() error {
 	for {
 		select {
 		case frame := <-d.inbound:
+			at := time.Now()
+			d.collector.FrameReceived(frame)
+			d.notify(frame)
 			cmd, err := d.findCmd(frame)
 			if flowcontrol.IsCanSkip(err) {
 				d.logNotFound(err)
@@ -63,25 +118,32 @@ func (d *dispatcher) dispatch() error {
 				return err
 			}
 
-			d.publishToRequester(cmd)
-			d.publishToMqttPublisher(cmd, extractValue(cmd, frame.Data))
+			value := extractValue(cmd, frame.Data)
+			d.collector.Value(cmd, value)
+			d.publish(CommandValue{cmd, value, at})
 		case <-d.tomb.Dying():
 			return tombPkg.ErrDying
 		}
 	}
 }
 
-func (d *dispatcher) publishToRequester(cmd conf.Command) {
-	select {
-	case d.toRequestor <- cmd:
-	case <-d.tomb.Dying():
+// publish queues value to all sinks.
+func (d *dispatcher) publish(value CommandValue) {
+	d.mutex.Lock()
+	sinks := d.sinks
+	d.mutex.Unlock()
+	for _, s := range sinks {
+		s.offer(value, d.tomb.Dying())
 	}
 }
 
-func (d *dispatcher) publishToMqttPublisher(cmd conf.Command, value int16) {
-	select {
-	case d.toMqttPublisher <- CommandValue{cmd, value}:
-	case <-d.tomb.Dying():
+// notify passes frame to all listeners.
+func (d *dispatcher) notify(frame canbus.Frame) {
+	d.mutex.Lock()
+	listeners := d.listeners
+	d.mutex.Unlock()
+	for _, l := range listeners {
+		l.FrameReceived(frame)
 	}
 }
 
@@ -107,13 +169,7 @@ func (d *dispatcher) findCmd(frame canbus.Frame) (conf.Command, error) {
 func (d *dispatcher) logNotFound(err error) {
 	if notFoundError, isNotFound := err.(commandNotFoundError); isNotFound {
 		d.unknownCommands.addCommand(notFoundError.canId, notFoundError.commandBytes)
-		//if len(notFoundError.commandBytes) < 2 {
-		//	d.log.Debug("======== UNKNOWN COMMAND ==========", zap.String("id", fmt.Sprintf("0x%X", notFoundError.canId)), zap.String("data", fmt.Sprintf("% X", notFoundError.commandBytes)))
-		//} else {
-		//	data := notFoundError.commandBytes
-		//	value := binary.BigEndian.Uint16(data[len(data)-2:])
-		//	d.log.Debug("======== UNKNOWN COMMAND ==========", zap.String("id", fmt.Sprintf("0x%X", notFoundError.canId)), zap.String("data", fmt.Sprintf("% X", notFoundError.commandBytes)), zap.Uint16("value", value))
-		//}
+		d.collector.UnknownFrame(notFoundError.canId)
 	}
 }
 
@@ -1,8 +1,10 @@
 package dispatcher_test
 
 import (
+	"bytes"
 	"echoctl/conf"
 	"echoctl/dispatcher"
+	"echoctl/metrics"
 	"github.com/go-daq/canbus"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -13,10 +15,7 @@ import (
 func TestBlockingFlow(t *testing.T) {
 	t.Run("Exits on blocking inbound", func(t *testing.T) {
 		t.Parallel()
-		inbound := make(chan canbus.Frame, 1)
-		toRequestor := make(chan conf.Command, 1)
-		toMqttPublisher := make(chan dispatcher.CommandValue, 1)
-		d := NewDispatcherWithChannels(inbound, toRequestor, toMqttPublisher, []conf.Command{})
+		d, _, _, _ := NewDispatcher([]conf.Command{})
 
 		tmb := d.Dispatch()
 		tmb.Kill(nil)
@@ -27,25 +26,84 @@ func TestBlockingFlow(t *testing.T) {
 		}
 	})
 
-	t.Run("Exits on blocking toRequestor", func(t *testing.T) {
+	t.Run("Exits on blocking sink", func(t *testing.T) {
 		t.Parallel()
-		inbound := make(chan canbus.Frame, 1)
-		toRequestor := make(chan conf.Command)
-		toMqttPublisher := make(chan dispatcher.CommandValue, 1)
-		sendToInboundAndKill(t, inbound, toRequestor, toMqttPublisher)
+		d, inbound, _, _ := NewDispatcher([]conf.Command{
+			{
+				Response: conf.RequestCommand{
+					CanId:        123,
+					CommandBytes: []byte{3, 7, 5},
+				},
+			},
+		})
+		tmb := d.Dispatch()
+		// The first value fills the queues, the second blocks the dispatcher.
+		inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
+		inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 1}}
+
+		tmb.Kill(nil)
+		select {
+		case <-tmb.Dead():
+		case <-time.After(time.Second):
+			t.Error("Dispatcher failed to shut down in 1s")
+		}
 	})
+}
 
-	t.Run("Exits on blocking toMqttPublisher", func(t *testing.T) {
+func TestSinks(t *testing.T) {
+	t.Run("Drops values for a full sink without stalling other sinks", func(t *testing.T) {
 		t.Parallel()
 		inbound := make(chan canbus.Frame, 1)
-		toRequestor := make(chan conf.Command, 1)
-		toMqttPublisher := make(chan dispatcher.CommandValue)
-		sendToInboundAndKill(t, inbound, toRequestor, toMqttPublisher)
+		d := dispatcher.NewDispatcher(inbound, []conf.Command{
+			{
+				Id: "001",
+				Response: conf.RequestCommand{
+					CanId:        123,
+					CommandBytes: []byte{3, 7, 5},
+				},
+			},
+		}, metrics.Nop, zap.NewNop())
+		slow := d.Register("slow", conf.SinkPolicy{Queue: 1, Overflow: conf.OverflowDrop})
+		fast := d.Register("fast", conf.SinkPolicy{Queue: 1, Overflow: conf.OverflowBlock})
+
+		startAndRun(t, d, func() {
+			for i := byte(0); i < 3; i++ {
+				inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, i}}
+				select {
+				case value := <-fast.Values():
+					assert.Equal(t, int16(i), value.Value)
+				case <-time.After(time.Second):
+					t.Fatal("Timeout waiting for data from the fast sink.")
+				}
+			}
+		})
+
+		assert.Equal(t, int16(0), (<-slow.Values()).Value, "The first value should be queued, the others dropped")
+		health := slow.Health()
+		assert.Equal(t, uint64(2), health.Dropped)
+		assert.Equal(t, dispatcher.SinkDropping, health.State())
+		assert.Equal(t, dispatcher.SinkOk, fast.Health().State())
+		assert.Equal(t, []string{"slow", "fast"}, []string{d.Sinks()[0].Name(), d.Sinks()[1].Name()})
+	})
+
+	t.Run("Uses the default queue for a negative queue", func(t *testing.T) {
+		t.Parallel()
+		d := dispatcher.NewDispatcher(make(chan canbus.Frame), nil, metrics.Nop, zap.NewNop())
+		sink := d.Register("poller", conf.SinkPolicy{Queue: -1})
+		assert.Equal(t, 100, sink.Health().Capacity)
+	})
+
+	t.Run("Reports a full queue", func(t *testing.T) {
+		t.Parallel()
+		health := dispatcher.SinkHealth{Queued: 10, Capacity: 10}
+		assert.Equal(t, dispatcher.SinkFull, health.State())
+		health.LastDrop = time.Now().Add(-time.Hour)
+		assert.Equal(t, dispatcher.SinkFull, health.State(), "Drops long ago should not be reported")
 	})
 }
 
 func TestFunction(t *testing.T) {
-	t.Run("Passes matched command to requestor", func(t *testing.T) {
+	t.Run("Passes matched command to sinks", func(t *testing.T) {
 		t.Parallel()
 		d, inbound, toRequestor, _ := NewDispatcher([]conf.Command{
 			{
@@ -60,8 +118,9 @@ func TestFunction(t *testing.T) {
 		startAndRun(t, d, func() {
 			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
 			select {
-			case cmd := <-toRequestor:
-				assert.Equal(t, "001", cmd.Id, "ID is different. Wrong match?")
+			case value := <-toRequestor:
+				assert.Equal(t, "001", value.Cmd.Id, "ID is different. Wrong match?")
+				assert.WithinDuration(t, time.Now(), value.At, time.Second, "Value should be stamped with the time received")
 			case <-time.After(time.Second):
 				t.Log("Timeout waiting for data from toRequestor.")
 			}
@@ -97,8 +156,8 @@ func TestFunction(t *testing.T) {
 		startAndRun(t, d, func() {
 			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
 			select {
-			case cmd := <-toRequestor:
-				assert.Equal(t, "003", cmd.Id, "ID is different. Wrong match?")
+			case value := <-toRequestor:
+				assert.Equal(t, "003", value.Cmd.Id, "ID is different. Wrong match?")
 			case <-time.After(time.Second):
 				t.Log("Timeout waiting for data from toRequestor.")
 			}
@@ -167,8 +226,8 @@ func TestFunction(t *testing.T) {
 			inbound <- canbus.Frame{ID: 123, Data: []byte{1, 2, 3, 0, 0}}
 			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 4, 3}}
 			select {
-			case cmd := <-toRequestor:
-				assert.Equal(t, "001", cmd.Id, "ID is different. Wrong match?")
+			case value := <-toRequestor:
+				assert.Equal(t, "001", value.Cmd.Id, "ID is different. Wrong match?")
 			case <-time.After(time.Second):
 				t.Log("Timeout waiting for data from toRequestor.")
 			}
@@ -177,36 +236,128 @@ func TestFunction(t *testing.T) {
 
 }
 
-func sendToInboundAndKill(t *testing.T, inbound chan canbus.Frame, toRequestor chan conf.Command, toMqttPublisher chan dispatcher.CommandValue) {
-	d := NewDispatcherWithChannels(inbound, toRequestor, toMqttPublisher, []conf.Command{
-		{
-			Response: conf.RequestCommand{
-				CanId:        123,
-				CommandBytes: []byte{3, 7, 5},
+func TestUnknownCommands(t *testing.T) {
+	t.Run("Collects unknown commands deduplicated", func(t *testing.T) {
+		t.Parallel()
+		d, inbound, toRequestor, _ := NewDispatcher([]conf.Command{
+			{
+				Id: "001",
+				Response: conf.RequestCommand{
+					CanId:        123,
+					CommandBytes: []byte{3, 7, 5},
+				},
 			},
-		},
+		})
+		startAndRun(t, d, func() {
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x20, 0x0A, 0xFA, 0x01, 0x12, 0x00, 0x05}}
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x20, 0x0A, 0xFA, 0x01, 0x12, 0x00, 0x02}}
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x20, 0x0A, 0xFA, 0x01, 0x12, 0x00, 0x03}}
+			inbound <- canbus.Frame{ID: 0x10A, Data: []byte{0x31, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00}}
+			// A known command passes all unknown commands before it through the dispatcher.
+			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
+			select {
+			case <-toRequestor:
+			case <-time.After(time.Second):
+				t.Log("Timeout waiting for data from toRequestor.")
+			}
+
+			unknown := d.UnknownCommands()
+			if assert.Len(t, unknown, 2, "Expected one entry per CAN ID and code") {
+				assert.Equal(t, conf.CanId(0x10A), unknown[0].CanId, "Entries should be sorted by CAN ID")
+				assert.Equal(t, conf.CanId(0x180), unknown[1].CanId, "Entries should be sorted by CAN ID")
+				assert.Equal(t, conf.CommandBytes{0x20, 0x0A, 0xFA, 0x01, 0x12}, unknown[1].Code)
+				assert.Equal(t, uint64(3), unknown[1].Count)
+				assert.Equal(t, uint16(3), unknown[1].Value, "Value should be the last received value")
+				assert.Equal(t, uint16(2), unknown[1].Min)
+				assert.Equal(t, uint16(5), unknown[1].Max)
+			}
+		})
 	})
-	tmb := d.Dispatch()
-	inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
+	t.Run("Splits the value from short registers", func(t *testing.T) {
+		t.Parallel()
+		d, inbound, toRequestor, _ := NewDispatcher([]conf.Command{
+			{
+				Id: "001",
+				Response: conf.RequestCommand{
+					CanId:        123,
+					CommandBytes: []byte{3, 7, 5},
+				},
+			},
+		})
+		startAndRun(t, d, func() {
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x22, 0x0A, 0x0E, 0x01, 0xE0, 0x00, 0x00}}
+			inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x22, 0x0A, 0x0E, 0x01, 0xF4, 0x00, 0x00}}
+			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 0}}
+			select {
+			case <-toRequestor:
+			case <-time.After(time.Second):
+				t.Log("Timeout waiting for data from toRequestor.")
+			}
 
-	tmb.Kill(nil)
-	select {
-	case <-tmb.Dead():
-	case <-time.After(time.Second):
-		t.Log("Dispatcher failed to shut down in 1s")
-	}
+			unknown := d.UnknownCommands()
+			if assert.Len(t, unknown, 1, "Expected one entry for the register, whatever its value") {
+				assert.Equal(t, conf.CommandBytes{0x22, 0x0A, 0x0E}, unknown[0].Code)
+				assert.Equal(t, uint16(0x01E0), unknown[0].Min)
+				assert.Equal(t, uint16(0x01F4), unknown[0].Max)
+			}
+		})
+	})
+	t.Run("Writes unknown commands in hand-collected format", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		err := dispatcher.WriteUnknownCommands(&buf, []dispatcher.UnknownCommand{
+			{CanId: 0x10A, Code: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x48}, Value: 0},
+			{CanId: 0x180, Code: conf.CommandBytes{0x20, 0x0A, 0xFA, 0x01, 0x12}, Value: 2816},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "id: 0x10A, code: 31 00 FA 01 48, value: 0\nid: 0x180, code: 20 0A FA 01 12, value: 2816\n", buf.String())
+	})
 }
 
-func NewDispatcherWithChannels(inbound <-chan canbus.Frame, toRequestor chan<- conf.Command, toMqttPublisher chan<- dispatcher.CommandValue, commands []conf.Command) (d dispatcher.Dispatcher) {
-	d = dispatcher.NewDispatcher(inbound, commands, toRequestor, toMqttPublisher, zap.NewNop())
-	return
+func TestListeners(t *testing.T) {
+	t.Run("Notifies listeners of known and unknown frames", func(t *testing.T) {
+		t.Parallel()
+		d, inbound, toRequestor, toMqttPublisher := NewDispatcher([]conf.Command{
+			{
+				Response: conf.RequestCommand{
+					CanId:        123,
+					CommandBytes: []byte{3, 7, 5},
+				},
+			},
+		})
+		frames := make(frameListener, 2)
+		d.Listen(frames)
+
+		startAndRun(t, d, func() {
+			inbound <- canbus.Frame{ID: 123, Data: []byte{3, 7, 5, 0, 1}}
+			inbound <- canbus.Frame{ID: 456, Data: []byte{1, 2, 3, 0, 1}}
+			for _, id := range []uint32{123, 456} {
+				select {
+				case frame := <-frames:
+					assert.Equal(t, id, frame.ID)
+				case <-time.After(time.Second):
+					t.Fatal("Timeout waiting for the listener.")
+				}
+			}
+			assert.Len(t, toRequestor, 1)
+			assert.Len(t, toMqttPublisher, 1)
+		})
+	})
+}
+
+// frameListener is a dispatcher.FrameListener, passing the frames to the channel.
+type frameListener chan canbus.Frame
+
+func (l frameListener) FrameReceived(frame canbus.Frame) {
+	l <- frame
 }
 
-func NewDispatcher(commands []conf.Command) (d dispatcher.Dispatcher, inbound chan canbus.Frame, toRequestor chan conf.Command, toMqttPublisher chan dispatcher.CommandValue) {
+// NewDispatcher creates a dispatcher with the blocking sinks poller and mqtt, queueing one value each.
+func NewDispatcher(commands []conf.Command) (d dispatcher.Dispatcher, inbound chan canbus.Frame, toRequestor <-chan dispatcher.CommandValue, toMqttPublisher <-chan dispatcher.CommandValue) {
 	inbound = make(chan canbus.Frame, 1)
-	toRequestor = make(chan conf.Command, 1)
-	toMqttPublisher = make(chan dispatcher.CommandValue, 1)
-	d = dispatcher.NewDispatcher(inbound, commands, toRequestor, toMqttPublisher, zap.NewNop())
+	d = dispatcher.NewDispatcher(inbound, commands, metrics.Nop, zap.NewNop())
+	toRequestor = d.Register("poller", conf.SinkPolicy{Queue: 1, Overflow: conf.OverflowBlock}).Values()
+	toMqttPublisher = d.Register("mqtt", conf.SinkPolicy{Queue: 1, Overflow: conf.OverflowBlock}).Values()
 	return
 }
 
@@ -0,0 +1,145 @@
+package dispatcher
+
+import (
+	"echoctl/conf"
+	"echoctl/metrics"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultQueue is the queue length of sinks without a configured, positive queue.
+	defaultQueue = 100
+
+	// dropHealthWindow is the time a sink is reported as dropping after its last dropped value.
+	dropHealthWindow = time.Minute
+)
+
+// States of a sink, see SinkHealth.State.
+const (
+	SinkOk       = "ok"
+	SinkFull     = "full"
+	SinkDropping = "dropping"
+)
+
+// Sink is an output of the Dispatcher. Every sink receives all dispatched values from its own queue, so a slow sink does not stall the other sinks or reading the bus, unless its overflow policy blocks.
+type Sink interface {
+	// Name identifies the sink in the configuration, see conf.KnownSinks.
+	Name() string
+
+	// Values returns the queue of the sink.
+	Values() <-chan CommandValue
+
+	// Health returns the state of the queue. Safe to call from any go routine.
+	Health() SinkHealth
+}
+
+// SinkHealth is the state of the queue of a Sink.
+type SinkHealth struct {
+	Queued   int
+	Capacity int
+	Dropped  uint64
+
+	// LastDrop is the time the last value was dropped, zero if none was dropped.
+	LastDrop time.Time
+}
+
+// State returns SinkDropping, if a value was dropped within the last minute, SinkFull, if the queue is full, and SinkOk otherwise.
+func (h SinkHealth) State() string {
+	switch {
+	case !h.LastDrop.IsZero() && time.Since(h.LastDrop) < dropHealthWindow:
+		return SinkDropping
+	case h.Queued >= h.Capacity:
+		return SinkFull
+	default:
+		return SinkOk
+	}
+}
+
+type sink struct {
+	name      string
+	overflow  conf.Overflow
+	values    chan CommandValue
+	collector metrics.Collector
+	log       *zap.Logger
+
+	mutex    sync.Mutex
+	dropped  uint64
+	lastDrop time.Time
+	dropping bool
+}
+
+var _ Sink = (*sink)(nil)
+
+func newSink(name string, policy conf.SinkPolicy, collector metrics.Collector, log *zap.Logger) *sink {
+	queue := policy.Queue
+	if queue <= 0 {
+		queue = defaultQueue
+	}
+	overflow := policy.Overflow
+	if overflow == "" {
+		overflow = conf.OverflowDrop
+	}
+	return &sink{
+		name:      name,
+		overflow:  overflow,
+		values:    make(chan CommandValue, queue),
+		collector: collector,
+		log:       log.With(zap.String("sink", name)),
+	}
+}
+
+func (s *sink) Name() string {
+	return s.name
+}
+
+func (s *sink) Values() <-chan CommandValue {
+	return s.values
+}
+
+func (s *sink) Health() SinkHealth {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SinkHealth{Queued: len(s.values), Capacity: cap(s.values), Dropped: s.dropped, LastDrop: s.lastDrop}
+}
+
+// offer queues value according to the overflow policy. A blocking sink waits until dying is closed.
+func (s *sink) offer(value CommandValue, dying <-chan struct{}) {
+	select {
+	case s.values <- value:
+		s.queued()
+		return
+	default:
+	}
+	if s.overflow == conf.OverflowBlock {
+		select {
+		case s.values <- value:
+			s.queued()
+		case <-dying:
+		}
+		return
+	}
+	s.drop(value)
+}
+
+func (s *sink) queued() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.dropping {
+		s.dropping = false
+		s.log.Info("sink recovered", zap.Uint64("dropped", s.dropped))
+	}
+}
+
+func (s *sink) drop(value CommandValue) {
+	s.collector.ValueDropped(s.name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dropped++
+	s.lastDrop = time.Now()
+	if !s.dropping {
+		s.dropping = true
+		s.log.Warn("queue of sink full, dropping values", zap.String("command", value.Cmd.Id))
+	}
+}
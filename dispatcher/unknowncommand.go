@@ -1,21 +1,39 @@
 package dispatcher
 
 import (
+	"bufio"
+	"bytes"
+	"echoctl/conf"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// UnknownCommand is an entry of the unknown command catalog. It aggregates all received frames with the same CAN ID and register (code), which did not match any known command.
+type UnknownCommand struct {
+	CanId     conf.CanId        `json:"can_id"`
+	Code      conf.CommandBytes `json:"code"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+	Count     uint64            `json:"count"`
+	Value     uint16            `json:"value"`
+	Min       uint16            `json:"min"`
+	Max       uint16            `json:"max"`
+}
+
 type unknownCommandCollector struct {
-	commands []unknownCommand
+	mutex    sync.Mutex
+	commands []UnknownCommand
 	log      *zap.Logger
 }
-type unknownCommand struct {
-	canId uint32
-	code  []byte
-	value uint16
-}
 
 func newUnknownCommandCollector(log *zap.Logger) *unknownCommandCollector {
 	return &unknownCommandCollector{
@@ -24,40 +42,185 @@ func newUnknownCommandCollector(log *zap.Logger) *unknownCommandCollector {
 }
 
 func (c *unknownCommandCollector) addCommand(canId uint32, data []byte) {
-	if len(data) < 4 {
-		c.log.Error("unknown command is too short: ", zap.Object("command", &unknownCommand{canId, data, 0}))
+	code, rest, ok := SplitCode(data)
+	if !ok || len(rest) < 2 {
+		c.log.Error("unknown command is too short: ", zap.Object("command", &UnknownCommand{CanId: conf.CanId(canId), Code: data}))
+		return
+	}
+	value := binary.BigEndian.Uint16(rest)
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if cmd := c.find(conf.CanId(canId), code); cmd != nil {
+		cmd.LastSeen = now
+		cmd.Count++
+		cmd.Value = value
+		cmd.Min = min(cmd.Min, value)
+		cmd.Max = max(cmd.Max, value)
 		return
 	}
-	cmd := convert(canId, data)
-	c.log.Debug(fmt.Sprintf("UNKNOWN COMMAND id: 0x%02X, code: % X, value: %d\n", cmd.canId, cmd.code, cmd.value))
 
-	//if c.exists(cmd) {
-	//	return
-	//}
-	//c.commands = append(c.commands, cmd)
-	//c.logCommands()
+	c.commands = append(c.commands, UnknownCommand{
+		CanId:     conf.CanId(canId),
+		Code:      slices.Clone(code),
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     1,
+		Value:     value,
+		Min:       value,
+		Max:       value,
+	})
+	c.logCommands()
 }
 
-func convert(canId uint32, data []byte) unknownCommand {
-	value := binary.BigEndian.Uint16(data[len(data)-2:])
-	return unknownCommand{canId, data[:len(data)-2], value}
+// snapshot returns a copy of the collected commands, sorted by CAN ID and code.
+func (c *unknownCommandCollector) snapshot() []UnknownCommand {
+	c.mutex.Lock()
+	result := slices.Clone(c.commands)
+	c.mutex.Unlock()
+
+	slices.SortFunc(result, func(a, b UnknownCommand) bool {
+		if a.CanId != b.CanId {
+			return a.CanId < b.CanId
+		}
+		return bytes.Compare(a.Code, b.Code) < 0
+	})
+	return result
 }
 
-func (c *unknownCommandCollector) exists(cmd unknownCommand) bool {
+// extendedRegister prefixes two byte register addresses, f.e. `FA 01 12`. Registers without it are one byte.
+const extendedRegister = 0xFA
+
+// SplitCode splits the data of a frame into the code, the two header bytes and the register (`20 0A FA 01 12` or `20 0A 0E`), and the rest, starting with the value. Returns false, if data is too short for the register.
+func SplitCode(data []byte) (code []byte, rest []byte, ok bool) {
+	length := 3
+	if len(data) >= 3 && data[2] == extendedRegister {
+		length = 5
+	}
+	if len(data) < length {
+		return nil, nil, false
+	}
+	return data[:length], data[length:], true
+}
+
+// find returns the collected command with the given CAN ID and code, or nil. Must be called with c.mutex held.
+func (c *unknownCommandCollector) find(canId conf.CanId, code []byte) *UnknownCommand {
 	for i := range c.commands {
-		if cmd.canId == c.commands[i].canId && slices.Equal(cmd.code, c.commands[i].code) {
-			return true
+		if canId == c.commands[i].CanId && slices.Equal(code, c.commands[i].Code) {
+			return &c.commands[i]
 		}
 	}
-	return false
+	return nil
 }
 
+// logCommands logs the whole catalog. Must be called with c.mutex held.
 func (c *unknownCommandCollector) logCommands() {
-	var bytes []byte
-	fmt.Appendf(bytes, "======== UNKNOWN COMMANDS ==========\n")
-	for i := range c.commands {
-		cmd := &c.commands[i]
-		bytes = fmt.Appendf(bytes, "id: 0x%02X, code: % X, value: %d\n", cmd.canId, cmd.code, cmd.value)
+	if !c.log.Core().Enabled(zap.DebugLevel) {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("======== UNKNOWN COMMANDS ==========\n")
+	_ = WriteUnknownCommands(&buf, c.commands)
+	c.log.Debug(buf.String())
+}
+
+// WriteUnknownCommands writes commands in the line format we use when reverse-engineering registers:
+//
+//	id: 0x180, code: 20 0A FA 01 12, value: 2816
+func WriteUnknownCommands(w io.Writer, commands []UnknownCommand) error {
+	for i := range commands {
+		cmd := &commands[i]
+		if _, err := fmt.Fprintf(w, "id: 0x%02X, code: % X, value: %d\n", uint32(cmd.CanId), []byte(cmd.Code), cmd.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteUnknownCommandsFile writes commands to the file fileName, replacing its contents. See WriteUnknownCommands for the format.
+func WriteUnknownCommandsFile(fileName string, commands []UnknownCommand) error {
+	var buf bytes.Buffer
+	if err := WriteUnknownCommands(&buf, commands); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// ReadUnknownCommandsFile reads an unknown command catalog. Accepts the JSON array published to mqtt, and the line format written by WriteUnknownCommands. The line format carries the last value only, which is used as min and max.
+func ReadUnknownCommandsFile(fileName string) ([]UnknownCommand, error) {
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '[' {
+		var commands []UnknownCommand
+		err = json.Unmarshal(trimmed, &commands)
+		return commands, err
+	}
+	return ReadUnknownCommands(bytes.NewReader(buf))
+}
+
+// ReadUnknownCommands parses the line format written by WriteUnknownCommands. Empty lines, and lines not starting with "id:" (like the header logged by the collector) are skipped.
+func ReadUnknownCommands(r io.Reader) ([]UnknownCommand, error) {
+	var commands []UnknownCommand
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "id:") {
+			continue
+		}
+		cmd, err := parseUnknownCommand(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, scanner.Err()
+}
+
+func parseUnknownCommand(line string) (UnknownCommand, error) {
+	parts := strings.Split(line, ", ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "id: 0x") || !strings.HasPrefix(parts[1], "code: ") || !strings.HasPrefix(parts[2], "value: ") {
+		return UnknownCommand{}, fmt.Errorf("malformed unknown command %q", line)
+	}
+	canId, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "id: 0x"), 16, 32)
+	if err != nil {
+		return UnknownCommand{}, fmt.Errorf("malformed CAN ID in %q: %w", line, err)
+	}
+	var code []byte
+	for _, b := range strings.Fields(strings.TrimPrefix(parts[1], "code: ")) {
+		num, err := strconv.ParseUint(b, 16, 8)
+		if err != nil {
+			return UnknownCommand{}, fmt.Errorf("malformed code in %q: %w", line, err)
+		}
+		code = append(code, byte(num))
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(parts[2], "value: "), 10, 16)
+	if err != nil {
+		return UnknownCommand{}, fmt.Errorf("malformed value in %q: %w", line, err)
+	}
+	return UnknownCommand{
+		CanId: conf.CanId(canId),
+		Code:  code,
+		Count: 1,
+		Value: uint16(value),
+		Min:   uint16(value),
+		Max:   uint16(value),
+	}, nil
+}
+
+func min(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b uint16) uint16 {
+	if a > b {
+		return a
 	}
-	c.log.Debug(string(bytes))
+	return b
 }
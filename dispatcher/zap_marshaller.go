@@ -5,7 +5,7 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-type unknownCommands []unknownCommand
+type unknownCommands []UnknownCommand
 
 func (commands unknownCommands) MarshalLogArray(encoder zapcore.ArrayEncoder) error {
 	for i := range commands {
@@ -16,9 +16,10 @@ func (commands unknownCommands) MarshalLogArray(encoder zapcore.ArrayEncoder) er
 	return nil
 }
 
-func (u *unknownCommand) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
-	encoder.AddString("canId", fmt.Sprintf("0x%X", u.canId))
-	encoder.AddString("code", fmt.Sprintf("% X", u.code))
-	encoder.AddUint16("value", u.value)
+func (u *UnknownCommand) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddString("canId", fmt.Sprintf("0x%X", uint32(u.CanId)))
+	encoder.AddString("code", fmt.Sprintf("% X", []byte(u.Code)))
+	encoder.AddUint16("value", u.Value)
+	encoder.AddUint64("count", u.Count)
 	return nil
 }
@@ -0,0 +1,406 @@
+package harness
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// Message is a message published to the Broker.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Broker is a minimal in-process MQTT 3.1.1 broker for tests. It accepts every client, keeps retained messages, and delivers to subscribers with QoS 0. All published messages are kept, so tests can wait for them.
+type Broker struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	retained map[string]Message
+	messages []Message
+	changed  chan struct{}
+	sessions map[*session]struct{}
+	wg       sync.WaitGroup
+}
+
+type session struct {
+	conn          net.Conn
+	writeMutex    sync.Mutex
+	subscriptions []string
+}
+
+// NewBroker starts a Broker listening on a random port of the loopback interface.
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("broker listen: %w", err)
+	}
+	b := &Broker{
+		listener: listener,
+		retained: make(map[string]Message),
+		changed:  make(chan struct{}),
+		sessions: make(map[*session]struct{}),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Addr returns the server address in the form expected by the mqtt client.
+func (b *Broker) Addr() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops listening and disconnects all clients.
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+	b.mutex.Lock()
+	for s := range b.sessions {
+		_ = s.conn.Close()
+	}
+	b.mutex.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+// Retained returns the retained message of topic.
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	msg, ok := b.retained[topic]
+	return msg, ok
+}
+
+// Messages returns all messages published so far, in order.
+func (b *Broker) Messages() []Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// WaitForMessage returns the first message published to topic. It blocks until such a message is published, or ctx is done.
+func (b *Broker) WaitForMessage(ctx context.Context, topic string) (Message, error) {
+	for {
+		b.mutex.Lock()
+		for _, msg := range b.messages {
+			if msg.Topic == topic {
+				b.mutex.Unlock()
+				return msg, nil
+			}
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("waiting for message on %s: %w", topic, ctx.Err())
+		}
+	}
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		s := &session{conn: conn}
+		b.mutex.Lock()
+		b.sessions[s] = struct{}{}
+		b.mutex.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			_ = b.serve(s)
+			b.mutex.Lock()
+			delete(b.sessions, s)
+			b.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (b *Broker) serve(s *session) error {
+	r := bufio.NewReader(s.conn)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case packetConnect:
+			err = s.write(packetConnack<<4, []byte{0, 0})
+		case packetPublish:
+			err = b.publish(s, header, body)
+		case packetPubrel:
+			err = s.write(packetPubcomp<<4, body[:2])
+		case packetSubscribe:
+			err = b.subscribe(s, body)
+		case packetUnsubscribe:
+			err = b.unsubscribe(s, body)
+		case packetPingreq:
+			err = s.write(packetPingresp<<4, nil)
+		case packetDisconnect:
+			return nil
+		default:
+			err = fmt.Errorf("unsupported packet type %d", header>>4)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Broker) publish(s *session, header byte, body []byte) error {
+	qos := (header >> 1) & 0x03
+	topic, rest, err := readString(body)
+	if err != nil {
+		return err
+	}
+	var packetId []byte
+	if qos > 0 {
+		if len(rest) < 2 {
+			return errors.New("publish: missing packet id")
+		}
+		packetId, rest = rest[:2], rest[2:]
+	}
+	msg := Message{Topic: topic, Payload: append([]byte(nil), rest...), Retained: header&0x01 != 0}
+	b.store(msg)
+	b.deliver(msg)
+
+	switch qos {
+	case 1:
+		return s.write(packetPuback<<4, packetId)
+	case 2:
+		return s.write(packetPubrec<<4, packetId)
+	default:
+		return nil
+	}
+}
+
+func (b *Broker) store(msg Message) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.messages = append(b.messages, msg)
+	if msg.Retained {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Broker) deliver(msg Message) {
+	b.mutex.Lock()
+	var receivers []*session
+	for s := range b.sessions {
+		if s.subscribed(msg.Topic) {
+			receivers = append(receivers, s)
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, s := range receivers {
+		// Delivery errors surface in the receiving session's read loop.
+		_ = s.writePublish(Message{Topic: msg.Topic, Payload: msg.Payload})
+	}
+}
+
+func (b *Broker) subscribe(s *session, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("subscribe: missing packet id")
+	}
+	packetId, rest := body[:2], body[2:]
+	granted := append([]byte(nil), packetId...)
+	var filters []string
+	for len(rest) > 0 {
+		filter, tail, err := readString(rest)
+		if err != nil || len(tail) < 1 {
+			return errors.New("subscribe: malformed topic filter")
+		}
+		filters = append(filters, filter)
+		granted = append(granted, 0)
+		rest = tail[1:]
+	}
+
+	b.mutex.Lock()
+	s.subscriptions = append(s.subscriptions, filters...)
+	var retained []Message
+	for _, msg := range b.retained {
+		for _, filter := range filters {
+			if matchTopic(filter, msg.Topic) {
+				retained = append(retained, msg)
+				break
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	if err := s.write(packetSuback<<4, granted); err != nil {
+		return err
+	}
+	for _, msg := range retained {
+		if err := s.writePublish(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) unsubscribe(s *session, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("unsubscribe: missing packet id")
+	}
+	packetId, rest := body[:2], body[2:]
+	b.mutex.Lock()
+	for len(rest) > 0 {
+		filter, tail, err := readString(rest)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+		for i, subscription := range s.subscriptions {
+			if subscription == filter {
+				s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+				break
+			}
+		}
+		rest = tail
+	}
+	b.mutex.Unlock()
+	return s.write(packetUnsuback<<4, packetId)
+}
+
+// subscribed must be called with the broker mutex held.
+func (s *session) subscribed(topic string) bool {
+	for _, filter := range s.subscriptions {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) writePublish(msg Message) error {
+	header := byte(packetPublish << 4)
+	if msg.Retained {
+		header |= 0x01
+	}
+	body := appendString(nil, msg.Topic)
+	body = append(body, msg.Payload...)
+	return s.write(header, body)
+}
+
+func (s *session) write(header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// matchTopic reports whether topic matches filter, which may contain the wildcards `+` and `#`.
+func matchTopic(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
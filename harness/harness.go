@@ -0,0 +1,102 @@
+// Package harness runs the complete echoctl daemon in-process for end-to-end tests: the fx graph of the daemon, an in-memory CAN bus with a simulated HPSU, and an embedded MQTT broker.
+package harness
+
+import (
+	"context"
+	"echoctl/app"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/simulate"
+	"github.com/go-daq/canbus"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"testing"
+	"time"
+)
+
+// startTimeout limits the start and stop of the daemon.
+const startTimeout = 10 * time.Second
+
+// Options configures the harness. Configuration.Mqtt.Server is overwritten with the address of the embedded broker.
+type Options struct {
+	Configuration conf.Configuration
+	Commands      map[string]conf.Command
+
+	// Values configures the simulated HPSU.
+	Values simulate.Config
+
+	// Log defaults to a no-op logger. Components keep logging shortly after the test finished, which rules out zaptest.
+	Log *zap.Logger
+}
+
+// Harness is a running daemon, connected to a simulated HPSU and an embedded MQTT broker.
+type Harness struct {
+	Broker    *Broker
+	Bus       *can.MemoryBus
+	Simulator simulate.Simulator
+}
+
+// Start starts the broker, the simulator and the daemon. Everything is stopped on test cleanup.
+func Start(t testing.TB, options Options) *Harness {
+	t.Helper()
+	log := options.Log
+	if log == nil {
+		log = zap.NewNop()
+	}
+
+	broker, err := NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+
+	bus := can.NewMemoryBus()
+	simulator := startSimulator(t, bus, options, log.Named("sim"))
+
+	configuration := options.Configuration
+	configuration.Mqtt.Server = broker.Addr()
+	daemon := fx.New(
+		fx.NopLogger,
+		fx.Supply(log),
+		fx.Provide(func() can.Socket { return bus.Socket() }),
+		app.Daemon(configuration, options.Commands),
+	)
+	startCtx, startCtxCancel := context.WithTimeout(context.Background(), startTimeout)
+	defer startCtxCancel()
+	if err := daemon.Start(startCtx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stopCtx, stopCtxCancel := context.WithTimeout(context.Background(), startTimeout)
+		defer stopCtxCancel()
+		if err := daemon.Stop(stopCtx); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return &Harness{
+		Broker:    broker,
+		Bus:       bus,
+		Simulator: simulator,
+	}
+}
+
+func startSimulator(t testing.TB, bus *can.MemoryBus, options Options, log *zap.Logger) simulate.Simulator {
+	socket := bus.Socket()
+	inbound := make(chan canbus.Frame, 10)
+	simulator, err := simulate.NewSimulator(socket, inbound, maps.Values(options.Commands), options.Values, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := can.NewReader(socket, inbound, log.Named("reader"))
+	simulatorTomb, readerTomb := simulator.Simulate(), reader.Read()
+	t.Cleanup(func() {
+		readerTomb.Kill(nil)
+		simulatorTomb.Kill(nil)
+		_ = readerTomb.Wait()
+		_ = simulatorTomb.Wait()
+		_ = socket.Close()
+	})
+	return simulator
+}
@@ -0,0 +1,190 @@
+package harness_test
+
+import (
+	"context"
+	"echoctl/conf"
+	"echoctl/harness"
+	"echoctl/simulate"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	commands, err := conf.ReadCommands("../commands_hpsu.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("announces subscriptions to homeassistant", func(t *testing.T) {
+		t.Parallel()
+
+		h := harness.Start(t, harness.Options{
+			Configuration: configuration("announce", "t_dhw", "mode"),
+			Commands:      commands,
+		})
+
+		for _, id := range []string{"t_dhw", "mode"} {
+			msg := waitForMessage(t, h, "test-homeassistant/sensor/daikin_altherma/"+id+"/config")
+			assert.True(t, msg.Retained)
+			retained, ok := h.Broker.Retained(msg.Topic)
+			assert.True(t, ok)
+			assert.Equal(t, msg.Payload, retained.Payload)
+
+			var entity map[string]any
+			assert.NoError(t, json.Unmarshal(msg.Payload, &entity))
+			assert.Equal(t, "daikin/"+id, entity["state_topic"])
+			assert.Equal(t, "daikin/"+id, entity["unique_id"])
+		}
+
+		msg := waitForMessage(t, h, "test-homeassistant/sensor/daikin_altherma/can_bus_state/config")
+		assert.Contains(t, string(msg.Payload), `"state_topic":"daikin/_health"`)
+		assert.Contains(t, string(msg.Payload), `"entity_category":"diagnostic"`)
+	})
+
+	t.Run("publishes simulated values", func(t *testing.T) {
+		t.Parallel()
+
+		h := harness.Start(t, harness.Options{
+			Configuration: configuration("values", "t_dhw", "mode"),
+			Commands:      commands,
+			Values: simulate.Config{Values: map[string]simulate.ValueConfig{
+				"t_dhw": {Value: "48.5"},
+				"mode":  {Value: "heating"},
+			}},
+		})
+
+		msg := waitForMessage(t, h, "daikin/t_dhw")
+		assert.Equal(t, "48.5000", string(msg.Payload))
+		assert.False(t, msg.Retained)
+
+		msg = waitForMessage(t, h, "daikin/mode")
+		assert.Equal(t, "heating", string(msg.Payload))
+
+		msg = waitForMessage(t, h, "daikin/_health")
+		assert.Contains(t, string(msg.Payload), `"state":"error-active"`)
+		assert.True(t, msg.Retained)
+	})
+
+	t.Run("serves metrics", func(t *testing.T) {
+		t.Parallel()
+
+		c := configuration("metrics", "t_dhw", "mode")
+		c.Http.Listen = freeAddress(t)
+		harness.Start(t, harness.Options{
+			Configuration: c,
+			Commands:      commands,
+			Values: simulate.Config{Values: map[string]simulate.ValueConfig{
+				"t_dhw": {Value: "48.5"},
+				"mode":  {Value: "heating"},
+			}},
+		})
+
+		waitForHttp(t, "http://"+c.Http.Listen+"/metrics", `echoctl_value{id="t_dhw",unit="deg",name="T-DHW"} 48.5`)
+		body := waitForHttp(t, "http://"+c.Http.Listen+"/metrics", `echoctl_value{id="mode",unit="none",name="Mode"} 1`)
+		assert.Contains(t, body, `echoctl_can_frames_received_total{can_id="180"}`)
+		assert.Contains(t, body, `echoctl_mqtt_publish_latency_seconds_count`)
+	})
+
+	t.Run("serves health and readiness", func(t *testing.T) {
+		t.Parallel()
+
+		c := configuration("healthz", "t_dhw")
+		c.Http.Listen = freeAddress(t)
+		c.Http.MaxSilence = time.Minute
+		harness.Start(t, harness.Options{
+			Configuration: c,
+			Commands:      commands,
+			Values:        simulate.Config{Values: map[string]simulate.ValueConfig{"t_dhw": {Value: "48.5"}}},
+		})
+
+		waitForHttp(t, "http://"+c.Http.Listen+"/healthz", `"t_dhw":{"last_response":`)
+		body := waitForHttp(t, "http://"+c.Http.Listen+"/readyz", `"status":"ok"`)
+		assert.Contains(t, body, `"poller":{"state":"running","ready":true}`)
+		assert.Contains(t, body, `"mqtt":{"state":"connected","ready":true}`)
+	})
+
+	t.Run("writes and reads values with the api", func(t *testing.T) {
+		t.Parallel()
+
+		c := configuration("api", "t_dhw")
+		c.Http.Listen = freeAddress(t)
+		c.Http.AllowWrites = true
+		h := harness.Start(t, harness.Options{Configuration: c, Commands: commands})
+		waitForHttp(t, "http://"+c.Http.Listen+"/api/values", `"t_dhw"`)
+
+		request, err := http.NewRequest(http.MethodPut, "http://"+c.Http.Listen+"/api/values/anti_leg_temp", strings.NewReader(`{"value": 62.5}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var value map[string]any
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&value))
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, 62.5, value["value"])
+		raw, _ := h.Simulator.Raw("anti_leg_temp")
+		assert.Equal(t, int16(625), raw)
+	})
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitForHttp polls url until the body contains expected.
+func waitForHttp(t *testing.T, url string, expected string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var body string
+	for time.Now().Before(deadline) {
+		if response, err := http.Get(url); err == nil {
+			buf, _ := io.ReadAll(response.Body)
+			_ = response.Body.Close()
+			body = string(buf)
+			if strings.Contains(body, expected) {
+				return body
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s did not contain %q in 5s, last body:\n%s", url, expected, body)
+	return ""
+}
+
+func configuration(clientId string, subscriptions ...string) conf.Configuration {
+	c := conf.Configuration{
+		Lang: "en",
+	}
+	c.Mqtt.ClientId = clientId
+	c.Mqtt.ValueTopicPrefix = "daikin"
+	c.Homeassistant.DiscoveryTopicPrefix = "test-homeassistant"
+	for _, id := range subscriptions {
+		c.Subscriptions = append(c.Subscriptions, conf.Subscription{Command: id, Delay: 50 * time.Millisecond})
+	}
+	return c
+}
+
+func waitForMessage(t *testing.T, h *harness.Harness, topic string) harness.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := h.Broker.WaitForMessage(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
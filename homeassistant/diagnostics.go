@@ -0,0 +1,50 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+)
+
+// diagnostic is a diagnostic entity, showing a field of the bus health published by mqtt.HealthPublisher.
+type diagnostic struct {
+	id         string
+	field      string
+	name       map[string]string
+	icon       string
+	stateClass string
+}
+
+var diagnostics = []diagnostic{
+	{id: "can_bus_state", field: "state", name: map[string]string{"de": "CAN-Bus Zustand", "en": "CAN bus state"}, icon: "mdi:lan-connect"},
+	{id: "can_tx_errors", field: "tx_errors", name: map[string]string{"de": "CAN-Bus Sendefehler", "en": "CAN bus TX errors"}, icon: "mdi:alert-circle-outline", stateClass: "measurement"},
+	{id: "can_rx_errors", field: "rx_errors", name: map[string]string{"de": "CAN-Bus Empfangsfehler", "en": "CAN bus RX errors"}, icon: "mdi:alert-circle-outline", stateClass: "measurement"},
+	{id: "can_error_frames", field: "error_frames", name: map[string]string{"de": "CAN-Bus Fehlerframes", "en": "CAN bus error frames"}, icon: "mdi:alert-circle-outline", stateClass: "total_increasing"},
+	{id: "can_bus_off", field: "bus_off", name: map[string]string{"de": "CAN-Bus Bus-Off", "en": "CAN bus bus-off count"}, icon: "mdi:lan-disconnect", stateClass: "total_increasing"},
+	{id: "can_last_error", field: "last_error", name: map[string]string{"de": "CAN-Bus letzter Fehler", "en": "CAN bus last error"}, icon: "mdi:alert"},
+}
+
+// DiagnosticEntitiesJson returns the entity configurations of the bus health diagnostics, by entity id. The entities read the JSON published to healthTopic.
+func DiagnosticEntitiesJson(healthTopic string, lang string, log *zap.Logger) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(diagnostics))
+	for _, d := range diagnostics {
+		e := entity{
+			Device:         daikinAltherma(),
+			ObjectId:       strPtr(d.id),
+			UniqueId:       strPtr("daikin/" + d.id),
+			Name:           localize(d.id, d.name, lang, log),
+			StateTopic:     strPtr(healthTopic),
+			ValueTemplate:  strPtr("{{ value_json." + d.field + " }}"),
+			Icon:           strPtr(d.icon),
+			EntityCategory: strPtr("diagnostic"),
+		}
+		if d.stateClass != "" {
+			e.StateClass = strPtr(d.stateClass)
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		result[d.id] = payload
+	}
+	return result, nil
+}
@@ -15,6 +15,7 @@ const (
 type discovery struct {
 	subscriptions        []can.Subscription
 	discoveryTopicPrefix string
+	healthTopic          string
 	lang                 string
 	log                  *zap.Logger
 	tomb                 *tomb.Tomb
@@ -22,24 +23,27 @@ type discovery struct {
 }
 
 type DiscoveryAnnouncer interface {
+	// Announce publishes the discovery configurations. It can be called again after the returned tomb died, to announce again.
 	Announce() *tomb.Tomb
 }
 
 var _ DiscoveryAnnouncer = (*discovery)(nil)
 
-func NewDiscoveryAnnouncer(subscriptions []can.Subscription, discoveryTopicPrefix string, lang string, client mqtt.Client, log *zap.Logger) DiscoveryAnnouncer {
+// NewDiscoveryAnnouncer creates a DiscoveryAnnouncer for the subscriptions. If healthTopic is not empty, the bus health diagnostics are announced, too.
+func NewDiscoveryAnnouncer(subscriptions []can.Subscription, discoveryTopicPrefix string, healthTopic string, lang string, client mqtt.Client, log *zap.Logger) DiscoveryAnnouncer {
 	p := &discovery{
 		discoveryTopicPrefix: discoveryTopicPrefix,
+		healthTopic:          healthTopic,
 		subscriptions:        subscriptions,
 		lang:                 lang,
 		client:               client,
 		log:                  log,
-		tomb:                 new(tomb.Tomb),
 	}
 	return p
 }
 
 func (p *discovery) Announce() *tomb.Tomb {
+	p.tomb = new(tomb.Tomb)
 	p.tomb.Go(p.announce)
 	return p.tomb
 }
@@ -63,7 +67,7 @@ func (p *discovery) publishNodeConfigurations() error {
 			return err
 		}
 	}
-	return nil
+	return p.publishDiagnostics()
 }
 
 func (p *discovery) publishNodeConf(subscription *can.Subscription) error {
@@ -71,9 +75,28 @@ func (p *discovery) publishNodeConf(subscription *can.Subscription) error {
 	if err != nil {
 		return fmt.Errorf("publish node configuration for command %s: %w", subscription.Command.Id, err)
 	}
+	return p.publishEntity(subscription.Command.Id, json)
+}
+
+func (p *discovery) publishDiagnostics() error {
+	if p.healthTopic == "" {
+		return nil
+	}
+	entities, err := DiagnosticEntitiesJson(p.healthTopic, p.lang, p.log)
+	if err != nil {
+		return fmt.Errorf("publish diagnostics configuration: %w", err)
+	}
+	for id, json := range entities {
+		if err := p.publishEntity(id, json); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (p *discovery) publishEntity(id string, json []byte) error {
 	token := p.client.Publish(
-		p.discoveryTopicPrefix+"/sensor/daikin_altherma/"+subscription.Command.Id+"/config",
+		p.discoveryTopicPrefix+"/sensor/daikin_altherma/"+id+"/config",
 		qos,
 		true,
 		json,
@@ -20,6 +20,7 @@ type entity struct {
 	ValueTemplate             *string `json:"value_template,omitempty"`
 	ExpiresAfter              *int64  `json:"expires_after,omitempty"`
 	SuggestedDisplayPrecision *int    `json:"suggested_display_precision,omitempty"`
+	EntityCategory            *string `json:"entity_category,omitempty"`
 }
 
 func daikinAltherma() *device {
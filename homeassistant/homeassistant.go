@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"go.uber.org/zap"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 func AsEntityJson(subscription *can.Subscription, lang string, log *zap.Logger) ([]byte, error) {
@@ -147,7 +148,13 @@ func localize(id string, dict map[string]string, lang string, log *zap.Logger) *
 	text, ok := dict[lang]
 	if !ok {
 		log.Error("dict is missing translation", zap.String("id", id), zap.String("lang", lang))
-		text = maps.Values(dict)[0]
+		if len(dict) == 0 {
+			return &id
+		}
+		// Pick a translation deterministically.
+		langs := maps.Keys(dict)
+		slices.Sort(langs)
+		text = dict[langs[0]]
 	}
 	return &text
 }
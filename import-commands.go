@@ -0,0 +1,43 @@
+package main
+
+import (
+	"echoctl/conf"
+	"echoctl/upstream"
+	"fmt"
+	"io"
+	"os"
+)
+
+func runImportCommands(cliOpts commandLineOptions) {
+	var read func(io.Reader, upstream.Options) (map[string]conf.Command, []upstream.Skipped, error)
+	switch cliOpts.Format {
+	case "pyhpsu":
+		read = upstream.ReadPyHPSU
+	case "fhem":
+		read = upstream.ReadFHEM
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported format %q, use pyhpsu or fhem\n", cliOpts.Format)
+		os.Exit(1)
+	}
+
+	file, err := os.Open(cliOpts.Source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading %s: %s\n", cliOpts.Source, err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	commands, skipped, err := read(file, upstream.Options{Lang: cliOpts.Lang})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading %s: %s\n", cliOpts.Source, err)
+		os.Exit(1)
+	}
+
+	for _, s := range skipped {
+		fmt.Println(s)
+	}
+	if err := conf.WriteCommands(cliOpts.Out, commands); err != nil {
+		fmt.Fprintf(os.Stderr, "writing %s: %s\n", cliOpts.Out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d imported commands to %s, %d problems\n", len(commands), cliOpts.Out, len(skipped))
+}
@@ -0,0 +1,59 @@
+package influxdb
+
+import (
+	"echoctl/dispatcher"
+	"strconv"
+	"strings"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// line formats value in the line protocol: measurement, the tags id, name (in lang) and unit, the fields value, raw and label, and the time value was received. value is the decoded value, the code for value codes, which are labelled by label.
+//
+//	echoctl,id=mode,name=Operating\ mode,unit=none value=1,raw=1i,label="heating" 1700000000000000000
+func line(measurement string, lang string, value dispatcher.CommandValue) string {
+	command := value.Cmd
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+	writeTag(&b, "id", command.Id)
+	if name, ok := command.Name[lang]; ok {
+		writeTag(&b, "name", name)
+	}
+	writeTag(&b, "unit", command.Unit.String())
+
+	raw := strconv.Itoa(int(value.Value))
+	decoded := command.Decode(value.Value)
+	b.WriteString(" value=")
+	if number, ok := decoded.(float64); ok {
+		b.WriteString(strconv.FormatFloat(number, 'f', -1, 64))
+	} else {
+		b.WriteString(raw)
+	}
+	b.WriteString(",raw=")
+	b.WriteString(raw)
+	b.WriteString("i")
+	if label, ok := decoded.(string); ok {
+		b.WriteString(`,label="`)
+		b.WriteString(stringEscaper.Replace(label))
+		b.WriteString(`"`)
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(value.At.UnixNano(), 10))
+	return b.String()
+}
+
+// writeTag writes the tag key=value. Empty values are not allowed by the line protocol, and skipped.
+func writeTag(b *strings.Builder, key string, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString(",")
+	b.WriteString(key)
+	b.WriteString("=")
+	b.WriteString(tagEscaper.Replace(value))
+}
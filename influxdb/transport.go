@@ -0,0 +1,116 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// requestTimeout limits a write request to the HTTP API.
+	requestTimeout = 10 * time.Second
+
+	// maxDatagram is the maximum size of a UDP datagram, which is not fragmented on common networks.
+	maxDatagram = 1400
+)
+
+// transport sends lines of the line protocol.
+type transport interface {
+	// send writes lines. Errors implementing flowcontrol.CanSkip reject the lines, which must not be sent again. All other errors are retried.
+	send(ctx context.Context, lines []string) error
+}
+
+// rejectedError is returned, if InfluxDB rejected the lines, f.e. for a field type conflict.
+type rejectedError struct {
+	status int
+	body   string
+}
+
+func (e rejectedError) Error() string {
+	return fmt.Sprintf("influxdb rejected the write: %d %s", e.status, e.body)
+}
+
+func (e rejectedError) CanSkip() bool {
+	return true
+}
+
+type httpTransport struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// newHttpTransport creates a transport writing to the HTTP write API of the InfluxDB v2 server at baseUrl.
+func newHttpTransport(baseUrl string, org string, bucket string, token string) transport {
+	query := url.Values{"org": {org}, "bucket": {bucket}, "precision": {"ns"}}
+	return &httpTransport{
+		url:    strings.TrimSuffix(baseUrl, "/") + "/api/v2/write?" + query.Encode(),
+		token:  token,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (t *httpTransport) send(ctx context.Context, lines []string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.token != "" {
+		request.Header.Set("Authorization", "Token "+t.token)
+	}
+	response, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	switch response.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		// The lines are malformed, or conflict with the schema. Sending them again fails again.
+		return rejectedError{response.StatusCode, strings.TrimSpace(string(body))}
+	default:
+		return fmt.Errorf("influxdb: %s %s", response.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+type udpTransport struct {
+	address string
+}
+
+// newUdpTransport creates a transport sending datagrams to a line protocol listener at address.
+func newUdpTransport(address string) transport {
+	return &udpTransport{address: address}
+}
+
+func (t *udpTransport) send(ctx context.Context, lines []string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", t.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Pack as many lines as fit into each datagram. Longer lines are sent alone.
+	var datagram bytes.Buffer
+	for _, line := range lines {
+		if datagram.Len() > 0 && datagram.Len()+len(line)+1 > maxDatagram {
+			if _, err := conn.Write(datagram.Bytes()); err != nil {
+				return err
+			}
+			datagram.Reset()
+		}
+		datagram.WriteString(line)
+		datagram.WriteString("\n")
+	}
+	_, err = conn.Write(datagram.Bytes())
+	return err
+}
@@ -0,0 +1,197 @@
+// Package influxdb writes the dispatched values to InfluxDB v2 in the line protocol, over the HTTP write API or to a UDP listener like Telegraf. Values are written independently of MQTT and Home Assistant, and are buffered while InfluxDB is unavailable.
+package influxdb
+
+import (
+	"context"
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/flowcontrol"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMeasurement   = "echoctl"
+	defaultBatchSize     = 500
+	defaultFlushInterval = 10 * time.Second
+	defaultMaxBuffer     = 10000
+
+	// stopTimeout limits writing the buffered values on stop.
+	stopTimeout = 2 * time.Second
+)
+
+// States of a Writer.
+const (
+	StateOk       = "ok"
+	StateRetrying = "retrying"
+	StateDisabled = "disabled"
+)
+
+type writer struct {
+	transport     transport
+	measurement   string
+	lang          string
+	batchSize     int
+	flushInterval time.Duration
+	maxBuffer     int
+	inbound       <-chan dispatcher.CommandValue
+	tomb          *tomb.Tomb
+	log           *zap.Logger
+
+	mutex sync.Mutex
+	// buffer are the lines not written yet. It is kept across restarts.
+	buffer   []string
+	retrying bool
+	dropped  uint64
+}
+
+// Writer writes the values read from inbound to InfluxDB in batches. A batch is written, when it is full, or after the flush interval. Failed writes are retried every flush interval, while the values are buffered.
+type Writer interface {
+	// Write starts writing. It can be called again after the returned tomb died, to restart writing. Buffered values are kept. If writing is disabled, it idles until killed.
+	Write() *tomb.Tomb
+
+	// State returns StateRetrying while writes fail, StateDisabled, if no server is configured, and StateOk otherwise. Safe to call from any go routine.
+	State() string
+}
+
+var _ Writer = (*writer)(nil)
+
+// NewWriter creates a Writer of the values from inbound, with names in lang. Writing is disabled, unless configuration is conf.Influxdb.Enabled. Sizes and intervals, which are not positive, fall back to the defaults.
+func NewWriter(configuration conf.Influxdb, lang string, inbound <-chan dispatcher.CommandValue, log *zap.Logger) Writer {
+	w := &writer{
+		measurement:   configuration.Measurement,
+		lang:          lang,
+		batchSize:     configuration.BatchSize,
+		flushInterval: configuration.FlushInterval,
+		maxBuffer:     configuration.MaxBuffer,
+		inbound:       inbound,
+		log:           log,
+	}
+	switch {
+	case configuration.Url != "":
+		w.transport = newHttpTransport(configuration.Url, configuration.Org, configuration.Bucket, configuration.Token)
+	case configuration.Udp != "":
+		w.transport = newUdpTransport(configuration.Udp)
+	}
+	if w.measurement == "" {
+		w.measurement = defaultMeasurement
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = defaultBatchSize
+	}
+	if w.flushInterval <= 0 {
+		w.flushInterval = defaultFlushInterval
+	}
+	if w.maxBuffer <= 0 {
+		w.maxBuffer = defaultMaxBuffer
+	}
+	return w
+}
+
+func (w *writer) Write() *tomb.Tomb {
+	w.tomb = new(tomb.Tomb)
+	w.tomb.Go(w.write)
+	return w.tomb
+}
+
+func (w *writer) State() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch {
+	case w.transport == nil:
+		return StateDisabled
+	case w.retrying:
+		return StateRetrying
+	default:
+		return StateOk
+	}
+}
+
+func (w *writer) write() error {
+	if w.transport == nil {
+		<-w.tomb.Dying()
+		return tomb.ErrDying
+	}
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case value := <-w.inbound:
+			if w.add(line(w.measurement, w.lang, value)) {
+				w.flush(w.tomb.Context(context.Background()))
+			}
+		case <-ticker.C:
+			w.flush(w.tomb.Context(context.Background()))
+		case <-w.tomb.Dying():
+			// Values still buffered are written after a restart.
+			ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+			w.flush(ctx)
+			cancel()
+			return tomb.ErrDying
+		}
+	}
+}
+
+// add buffers line, and returns true, if a batch is full and due to be written. While retrying, batches are only written every flush interval. A full buffer drops the oldest line.
+func (w *writer) add(line string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.buffer) >= w.maxBuffer {
+		if w.dropped == 0 {
+			w.log.Warn("buffer full, dropping the oldest values", zap.Int("max-buffer", w.maxBuffer))
+		}
+		w.buffer = w.buffer[1:]
+		w.dropped++
+	}
+	w.buffer = append(w.buffer, line)
+	return len(w.buffer) >= w.batchSize && !w.retrying
+}
+
+// flush writes the buffered lines in batches, until the buffer is empty or a write fails. A cancelled ctx fails the write, the lines stay buffered.
+func (w *writer) flush(ctx context.Context) {
+	for {
+		w.mutex.Lock()
+		n := len(w.buffer)
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		batch := w.buffer[:n]
+		w.mutex.Unlock()
+		if n == 0 {
+			return
+		}
+
+		err := w.transport.send(ctx, batch)
+		if err != nil && !flowcontrol.IsCanSkip(err) {
+			w.setRetrying(err)
+			return
+		}
+		if err != nil {
+			w.log.Error("dropping rejected values", zap.Int("values", n), zap.Error(err))
+		}
+		w.sent(n)
+	}
+}
+
+func (w *writer) setRetrying(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.retrying {
+		w.log.Warn("writing failed, buffering values", zap.Duration("retry-interval", w.flushInterval), zap.Error(err))
+	}
+	w.retrying = true
+}
+
+// sent removes the first n lines of the buffer after they were sent.
+func (w *writer) sent(n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buffer = w.buffer[n:]
+	if w.retrying {
+		w.log.Info("writing recovered", zap.Int("buffered", len(w.buffer)), zap.Uint64("dropped", w.dropped))
+	}
+	w.retrying = false
+	w.dropped = 0
+}
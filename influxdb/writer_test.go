@@ -0,0 +1,223 @@
+package influxdb_test
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/influxdb"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	tDhw = conf.Command{Id: "t_dhw", Name: map[string]string{"en": "DHW temperature, top"}, Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg}
+	mode = conf.Command{Id: "mode", Type: conf.TypeValue, ValueCode: map[string]int{"standby": 0, "heating": 1}}
+	at   = time.Unix(1700000000, 0)
+)
+
+func TestWriter(t *testing.T) {
+	t.Run("writes batches to the HTTP write API", func(t *testing.T) {
+		t.Parallel()
+
+		server := newServer()
+		defer server.Close()
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Url: server.URL, Org: "home", Bucket: "heat pump", Token: "s3cret", BatchSize: 2, FlushInterval: time.Hour}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+		defer tomb.Kill(nil)
+
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 485, At: at}
+		inbound <- dispatcher.CommandValue{Cmd: mode, Value: 1, At: at}
+		lines := server.waitForLines(t, 2)
+
+		request := server.firstRequest()
+		assert.Equal(t, "/api/v2/write", request.URL.Path)
+		assert.Equal(t, "home", request.URL.Query().Get("org"))
+		assert.Equal(t, "heat pump", request.URL.Query().Get("bucket"))
+		assert.Equal(t, "ns", request.URL.Query().Get("precision"))
+		assert.Equal(t, "Token s3cret", request.Header.Get("Authorization"))
+		assert.Regexp(t, `^echoctl,id=t_dhw,name=DHW\\ temperature\\,\\ top,unit=deg value=48.5,raw=485i 1700000000000000000$`, lines[0])
+		assert.Regexp(t, `^echoctl,id=mode,unit=none value=1,raw=1i,label="heating" 1700000000000000000$`, lines[1])
+		assert.Equal(t, influxdb.StateOk, writer.State())
+	})
+
+	t.Run("writes incomplete batches after the flush interval", func(t *testing.T) {
+		t.Parallel()
+
+		server := newServer()
+		defer server.Close()
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Url: server.URL, Org: "home", Bucket: "hpsu", FlushInterval: 10 * time.Millisecond}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+		defer tomb.Kill(nil)
+
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 485, At: at}
+		assert.Len(t, server.waitForLines(t, 1), 1)
+	})
+
+	t.Run("buffers values while writing fails", func(t *testing.T) {
+		t.Parallel()
+
+		server := newServer()
+		defer server.Close()
+		server.fail(http.StatusServiceUnavailable)
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Url: server.URL, Org: "home", Bucket: "hpsu", BatchSize: 2, FlushInterval: 10 * time.Millisecond, MaxBuffer: 3}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+		defer tomb.Kill(nil)
+
+		for raw := int16(1); raw <= 4; raw++ {
+			inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: raw, At: at}
+		}
+		waitFor(t, func() bool { return writer.State() == influxdb.StateRetrying })
+
+		server.fail(0)
+		lines := server.waitForLines(t, 3)
+		assert.Len(t, lines, 3, "The oldest value should be dropped from the full buffer")
+		assert.Contains(t, lines[0], "raw=2i")
+		assert.Contains(t, lines[2], "raw=4i")
+		waitFor(t, func() bool { return writer.State() == influxdb.StateOk })
+	})
+
+	t.Run("drops rejected values", func(t *testing.T) {
+		t.Parallel()
+
+		server := newServer()
+		defer server.Close()
+		server.fail(http.StatusBadRequest)
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Url: server.URL, Org: "home", Bucket: "hpsu", BatchSize: 1, FlushInterval: time.Hour}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+		defer tomb.Kill(nil)
+
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 1, At: at}
+		waitFor(t, func() bool { return server.requestCount() == 1 })
+		server.fail(0)
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 2, At: at}
+		lines := server.waitForLines(t, 1)
+		assert.Contains(t, lines[0], "raw=2i", "The rejected value should not be sent again")
+		assert.Equal(t, influxdb.StateOk, writer.State())
+	})
+
+	t.Run("writes to a UDP listener", func(t *testing.T) {
+		t.Parallel()
+
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Udp: listener.LocalAddr().String(), Measurement: "hpsu", BatchSize: 1}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+		defer tomb.Kill(nil)
+
+		inbound <- dispatcher.CommandValue{Cmd: mode, Value: 0, At: at}
+		buf := make([]byte, 1500)
+		if err := listener.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := listener.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Regexp(t, `^hpsu,id=mode,unit=none value=0,raw=0i,label="standby" 1700000000000000000\n$`, string(buf[:n]))
+	})
+
+	t.Run("falls back to the defaults for negative settings", func(t *testing.T) {
+		t.Parallel()
+
+		server := newServer()
+		defer server.Close()
+		inbound := make(chan dispatcher.CommandValue)
+		writer := influxdb.NewWriter(conf.Influxdb{Url: server.URL, Org: "home", Bucket: "hpsu", BatchSize: -1, FlushInterval: -time.Second, MaxBuffer: -1}, "en", inbound, zap.NewNop())
+		tomb := writer.Write()
+
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 485, At: at}
+		tomb.Kill(nil)
+		assert.NoError(t, tomb.Wait())
+		assert.Len(t, server.waitForLines(t, 1), 1, "The buffered value should be written on stop")
+	})
+
+	t.Run("idles, if disabled", func(t *testing.T) {
+		t.Parallel()
+
+		writer := influxdb.NewWriter(conf.Influxdb{}, "en", nil, zap.NewNop())
+		tomb := writer.Write()
+		assert.Equal(t, influxdb.StateDisabled, writer.State())
+		tomb.Kill(nil)
+		assert.NoError(t, tomb.Wait())
+	})
+}
+
+// server is a stand-in of the HTTP write API, recording the written lines.
+type server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	lines    []string
+	status   int
+}
+
+func newServer() *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, r)
+		if s.status != 0 {
+			http.Error(w, "failing", s.status)
+			return
+		}
+		s.lines = append(s.lines, strings.Split(string(body), "\n")...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return s
+}
+
+// fail responds with status from now on, 0 succeeds.
+func (s *server) fail(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+}
+
+func (s *server) firstRequest() *http.Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[0]
+}
+
+func (s *server) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.requests)
+}
+
+func (s *server) waitForLines(t *testing.T, n int) []string {
+	waitFor(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return len(s.lines) >= n
+	})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
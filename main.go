@@ -1,20 +1,16 @@
 package main
 
 import (
+	"echoctl/app"
 	"echoctl/can"
 	"echoctl/conf"
-	"echoctl/dispatcher"
-	"echoctl/homeassistant"
-	"echoctl/mqtt"
-	"echoctl/schedule"
 	"fmt"
 	"github.com/docopt/docopt-go"
-	phaoMqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/go-daq/canbus"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
 	"golang.org/x/exp/maps"
+	"golang.org/x/sys/unix"
 	"os"
 )
 
@@ -25,6 +21,9 @@ cansend vcan0 123#00FFAA5501020304
 cansend vcan0 '180#3210FAC0F60100'
 cansend vcan0 '180#3210FA01800100'
 cansend vcan0 '180#3210FAC0F60004'
+
+Or let the simulator answer all requests:
+echoctl simulate --iface vcan0 --values simulate.example.yaml
 */
 
 const version = "1.2.6_2"
@@ -32,82 +31,155 @@ const usage = `Altherma ECH₂O Control.
 
 Usage:
   echoctl [options]
+  echoctl check-config [options]
+  echoctl print-commands [options]
+  echoctl propose-commands [options] [--out=<file>] <catalog>
+  echoctl import-commands [options] --format=<format> [--lang=<lang>] [--out=<file>] <source>
+  echoctl scan [options] --range=<range> [--target=<can-id>] [--receiver=<can-id>] [--rate=<n>] [--timeout=<duration>] [--state=<file>] [--out=<file>]
+  echoctl simulate [options] --iface=<iface> [--values=<file>]
+
+Commands:
+  check-config      Validate the configuration and the command database, and report all problems found.
+  print-commands    Print the effective command database, after merging the command files of the configuration.
+  import-commands   Convert a pyHPSU CSV command file or an FHEM module command table into command definitions.
+  propose-commands  Turn an unknown command catalog (file or JSON from mqtt) into skeleton command definitions.
+  scan              Send read requests for a register range (f.e. FA:0000-FA:FFFF), and write the responding registers as command definitions.
+  simulate          Simulate the HPSU: answer requests for all known commands on a (virtual) CAN interface.
 
 Options:
-  -h --help     Show this screen.
-  --version     Show version.
-  --debug       Turn on debug logging [default: false].
+  -h --help               Show this screen.
+  --version               Show version.
+  --debug                 Turn on debug logging [default: false].
+  --config=<file>         Configuration file. Keys can be overridden by ECHOCTL_* environment variables, f.e. ECHOCTL_MQTT_SERVER [default: config.yaml].
+  --commands=<file>       Command database, instead of the command files of the configuration.
+  --out=<file>            Output file, by default proposed_commands.json, imported_commands.json or scanned_commands.json.
+  --format=<format>       Format of the imported file: pyhpsu or fhem.
+  --lang=<lang>           Language of imported labels without language [default: en].
+  --range=<range>         Register range to scan.
+  --target=<can-id>       CAN ID (hex) the scan requests are sent on [default: 190].
+  --receiver=<can-id>     CAN ID (hex) of the device to scan [default: 180].
+  --rate=<n>              Maximum scan requests per second [default: 10].
+  --timeout=<duration>    Time to wait for an answer per register [default: 500ms].
+  --state=<file>          Scan state file, an interrupted scan resumes from it [default: scan_state.json].
+  --replay=<file>         Receive frames from a candump -L log file instead of the CAN interface.
+  --speed=<factor>        Replay speed, 1 is real time, 0 as fast as possible [default: 1].
+  --iface=<iface>         CAN interface to simulate the HPSU on, see can.iface in the configuration.
+  --values=<file>         Simulated values (yaml), see simulate.example.yaml.
 `
 
 type commandLineOptions struct {
-	Debug bool
+	Debug           bool
+	Config          string
+	Commands        string
+	CheckConfig     bool `docopt:"check-config"`
+	PrintCommands   bool `docopt:"print-commands"`
+	ProposeCommands bool `docopt:"propose-commands"`
+	Out             string
+	Catalog         string
+	ImportCommands  bool `docopt:"import-commands"`
+	Format          string
+	Lang            string
+	Source          string
+	Scan            bool
+	Range           string
+	Target          string
+	Receiver        string
+	Rate            float64
+	Timeout         string
+	State           string
+	Replay          string
+	Speed           float64
+	Simulate        bool
+	Iface           string
+	Values          string
 }
 
 func main() {
 	cliOpts := parseArgs()
 
-	commands, err := conf.ReadCommands("commands_hpsu.json")
+	switch {
+	case cliOpts.CheckConfig:
+		runCheckConfig(cliOpts)
+	case cliOpts.PrintCommands:
+		runPrintCommands(cliOpts)
+	case cliOpts.ImportCommands:
+		runImportCommands(cliOpts)
+	case cliOpts.ProposeCommands:
+		runProposeCommands(cliOpts)
+	case cliOpts.Scan:
+		runScan(cliOpts)
+	case cliOpts.Simulate:
+		runSimulate(cliOpts)
+	default:
+		runDaemon(cliOpts)
+	}
+}
+
+func runDaemon(cliOpts commandLineOptions) {
+	configuration, err := conf.LoadConfig(cliOpts.Config, os.Environ())
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "reading configuration: %s\n", err)
+		os.Exit(1)
 	}
-	configuration, err := conf.ReadConfig("config.yaml")
+	commands, err := conf.ReadCommandFiles(commandFiles(cliOpts, configuration))
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "reading commands: %s\n", err)
+		os.Exit(1)
+	}
+	// Refuse to start with problems, which would make components fail later. Warnings are reported by check-config.
+	if problems := conf.Validate(cliOpts.Config, configuration, commands, nil); conf.HasErrors(problems) {
+		for _, problem := range problems {
+			if problem.Severity == conf.SeverityError {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "invalid configuration, see echoctl check-config")
+		os.Exit(1)
 	}
-
-	subscriptions := attachCommand(configuration.Subscriptions, commands)
-
-	dispatcherToRequestor := make(chan conf.Command, 10)
-	dispatcherToMqttPublisher := make(chan dispatcher.CommandValue, 10)
-	canReaderToDispatcher := make(chan canbus.Frame, 10)
 
 	fx.New(
 		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
 			return &fxevent.ZapLogger{Logger: log.Named("fx")}
 		}),
 		fx.Provide(
-			func() (can.Socket, error) {
-				return can.NewSocket(configuration.Can.Iface)
-			},
-			func(socket can.Socket, log *zap.Logger) can.Poller {
-				return can.NewPoller(socket, subscriptions, dispatcherToRequestor, schedule.NewScheduler[can.Subscription](), log.Named("poller"))
-			},
-			func(log *zap.Logger) dispatcher.Dispatcher {
-				return dispatcher.NewDispatcher(canReaderToDispatcher, maps.Values(commands), dispatcherToRequestor, dispatcherToMqttPublisher, log.Named("disp"))
-			},
-			func(log *zap.Logger) (phaoMqtt.Client, error) {
-				return mqtt.NewClient(configuration.Mqtt.Server, configuration.Mqtt.ClientId, configuration.Mqtt.User, configuration.Mqtt.Password, log.Named("mqtt"), nil)
-			},
-			func(client phaoMqtt.Client, log *zap.Logger) mqtt.Publisher {
-				return mqtt.NewPublisher(configuration.Mqtt.ValueTopicPrefix, dispatcherToMqttPublisher, client, log.Named("publ"))
-			},
-			func(client phaoMqtt.Client, log *zap.Logger) homeassistant.DiscoveryAnnouncer {
-				return homeassistant.NewDiscoveryAnnouncer(subscriptions, configuration.Homeassistant.DiscoveryTopicPrefix, configuration.Lang, client, log.Named("anou"))
-			},
-			func(socket can.Socket, log *zap.Logger) can.Reader {
-				return can.NewReader(socket, canReaderToDispatcher, log.Named("reader"))
+			func(log *zap.Logger) (can.Socket, error) {
+				if cliOpts.Replay != "" {
+					return can.NewReplaySocket(cliOpts.Replay, cliOpts.Speed, log.Named("replay"))
+				}
+				var filters []unix.CanFilter
+				if !configuration.Can.Promiscuous {
+					filters = can.ResponseFilters(maps.Values(commands))
+				}
+				return can.NewRecoveringSocket(func() (can.Socket, error) {
+					return can.Open(configuration.Can.Iface, filters, log.Named("socket"))
+				}, configuration.Can.Recovery, log.Named("socket"))
 			},
 			getLogConfig(cliOpts.Debug).Build,
 		),
-
-		fx.Invoke(func(publisher mqtt.Publisher, poller can.Poller, dispatcher dispatcher.Dispatcher, reader can.Reader, shutdowner fx.Shutdowner, discoveryAnnouncer homeassistant.DiscoveryAnnouncer, lc fx.Lifecycle, client phaoMqtt.Client, socket can.Socket, log *zap.Logger) {
-			daemonize(
-				lc,
-				shutdowner,
-				fx.DefaultTimeout,
-				log,
-				client,
-				socket,
-				publisher.Publish(),
-				poller.Poll(),
-				dispatcher.Dispatch(),
-				reader.Read(),
-				discoveryAnnouncer.Announce(),
-			)
-		}),
+		app.Daemon(configuration, commands),
 	).Run()
 }
 
+// commandFiles returns the --commands file, or the command files of the configuration.
+func commandFiles(cliOpts commandLineOptions, configuration conf.Configuration) []string {
+	if cliOpts.Commands != "" {
+		return []string{cliOpts.Commands}
+	}
+	return configuration.CommandFiles()
+}
+
+// readCommands reads the command database of the command line. The configuration is only read, if --commands is not given.
+func readCommands(cliOpts commandLineOptions) (map[string]conf.Command, error) {
+	if cliOpts.Commands != "" {
+		return conf.ReadCommandFiles([]string{cliOpts.Commands})
+	}
+	configuration, err := conf.LoadConfig(cliOpts.Config, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	return conf.ReadCommandFiles(configuration.CommandFiles())
+}
+
 func parseArgs() commandLineOptions {
 	arguments, _ := docopt.ParseArgs(usage, os.Args[1:], version)
 	var cliOpts commandLineOptions
@@ -115,9 +187,24 @@ func parseArgs() commandLineOptions {
 	if err != nil {
 		panic(err)
 	}
+	if cliOpts.Out == "" {
+		cliOpts.Out = defaultOut(cliOpts)
+	}
 	return cliOpts
 }
 
+// defaultOut returns the output file of the subcommand, if --out is not given. Each subcommand has its own file, so one does not overwrite the result of another.
+func defaultOut(cliOpts commandLineOptions) string {
+	switch {
+	case cliOpts.ImportCommands:
+		return "imported_commands.json"
+	case cliOpts.Scan:
+		return "scanned_commands.json"
+	default:
+		return "proposed_commands.json"
+	}
+}
+
 func getLogConfig(debugLogging bool) zap.Config {
 	config := zap.NewDevelopmentConfig()
 	if debugLogging {
@@ -127,17 +214,3 @@ func getLogConfig(debugLogging bool) zap.Config {
 	}
 	return config
 }
-
-func attachCommand(subscriptions []conf.Subscription, commands map[string]conf.Command) []can.Subscription {
-	result := make([]can.Subscription, len(subscriptions))
-	for i := range subscriptions {
-		var ok bool
-		result[i].Command, ok = commands[subscriptions[i].Command]
-		if !ok {
-			panic(fmt.Errorf("error parsing configuration file: command '%s' not found in commands_hpsu.json", subscriptions[i].Command))
-		}
-		result[i].Delay = subscriptions[i].Delay
-	}
-
-	return result
-}
@@ -0,0 +1,53 @@
+// Package metrics collects measurements of the daemon, and exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"echoctl/conf"
+	"github.com/go-daq/canbus"
+	"time"
+)
+
+// Collector receives measurements from the components of the daemon. Implementations are safe for concurrent use.
+type Collector interface {
+	// FrameReceived counts a received frame.
+	FrameReceived(frame canbus.Frame)
+
+	// UnknownFrame counts a received frame on canId, which matched no known command.
+	UnknownFrame(canId uint32)
+
+	// Value records the current raw value of command.
+	Value(command conf.Command, raw int16)
+
+	// RequestSent counts a sent read request of command, or a write request, if write is set.
+	RequestSent(command conf.Command, write bool)
+
+	// ValueDropped counts a value, which the dispatcher dropped, because the queue of sink was full.
+	ValueDropped(sink string)
+
+	// SendRetry counts a request, which is sent again because the send buffer was full.
+	SendRetry()
+
+	// SchedulerLateness records how late a scheduled request was sent.
+	SchedulerLateness(lateness time.Duration)
+
+	// Published records the latency of a successful publish to the MQTT server.
+	Published(latency time.Duration)
+
+	// PublishFailed counts a failed publish to the MQTT server.
+	PublishFailed()
+}
+
+// Nop is a Collector discarding all measurements.
+var Nop Collector = nop{}
+
+type nop struct{}
+
+func (nop) FrameReceived(canbus.Frame)      {}
+func (nop) UnknownFrame(uint32)             {}
+func (nop) Value(conf.Command, int16)       {}
+func (nop) RequestSent(conf.Command, bool)  {}
+func (nop) ValueDropped(string)             {}
+func (nop) SendRetry()                      {}
+func (nop) SchedulerLateness(time.Duration) {}
+func (nop) Published(time.Duration)         {}
+func (nop) PublishFailed()                  {}
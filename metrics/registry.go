@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"echoctl/conf"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the histogram buckets of latencies.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Registry is a Collector, which serves the collected measurements in the Prometheus text format.
+type Registry interface {
+	Collector
+	http.Handler
+}
+
+type value struct {
+	command conf.Command
+	value   float64
+}
+
+type request struct {
+	id   string
+	kind string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+type registry struct {
+	lang string
+
+	mutex             sync.Mutex
+	values            map[string]value
+	framesReceived    map[uint32]uint64
+	unknownFrames     map[uint32]uint64
+	requestsSent      map[request]uint64
+	valuesDropped     map[string]uint64
+	sendRetries       uint64
+	schedulerLateness histogram
+	publishLatency    histogram
+	publishErrors     uint64
+}
+
+var _ Registry = (*registry)(nil)
+
+// NewRegistry creates a Registry. The name label of values is in lang.
+func NewRegistry(lang string) Registry {
+	return &registry{
+		lang:              lang,
+		values:            make(map[string]value),
+		framesReceived:    make(map[uint32]uint64),
+		unknownFrames:     make(map[uint32]uint64),
+		requestsSent:      make(map[request]uint64),
+		valuesDropped:     make(map[string]uint64),
+		schedulerLateness: histogram{counts: make([]uint64, len(latencyBuckets))},
+		publishLatency:    histogram{counts: make([]uint64, len(latencyBuckets))},
+	}
+}
+
+func (r *registry) FrameReceived(frame canbus.Frame) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.framesReceived[frame.ID]++
+}
+
+func (r *registry) UnknownFrame(canId uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unknownFrames[canId]++
+}
+
+func (r *registry) Value(command conf.Command, raw int16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[command.Id] = value{command: command, value: numeric(command, raw)}
+}
+
+func (r *registry) RequestSent(command conf.Command, write bool) {
+	kind := "read"
+	if write {
+		kind = "write"
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requestsSent[request{id: command.Id, kind: kind}]++
+}
+
+func (r *registry) ValueDropped(sink string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.valuesDropped[sink]++
+}
+
+func (r *registry) SendRetry() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sendRetries++
+}
+
+func (r *registry) SchedulerLateness(lateness time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.schedulerLateness.observe(lateness)
+}
+
+func (r *registry) Published(latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.publishLatency.observe(latency)
+}
+
+func (r *registry) PublishFailed() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.publishErrors++
+}
+
+// numeric converts raw with conf.Command.Decode, like the MQTT publisher, but leaves enum values as their code.
+func numeric(command conf.Command, raw int16) float64 {
+	switch value := command.Decode(raw).(type) {
+	case float64:
+		return value
+	case string:
+		return float64(command.ValueCode[value])
+	default:
+		return float64(raw)
+	}
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// A slow scraper must not block the collector methods, which are called for every frame.
+	r.mutex.Lock()
+	body := r.render()
+	r.mutex.Unlock()
+	_, _ = io.WriteString(w, body)
+}
+
+// render formats all metrics in the text exposition format. The caller must hold the mutex.
+func (r *registry) render() string {
+	var b strings.Builder
+
+	header(&b, "echoctl_value", "gauge", "Current value of a command. Enum values are exposed as their code.")
+	ids := maps.Keys(r.values)
+	slices.Sort(ids)
+	for _, id := range ids {
+		v := r.values[id]
+		name, ok := v.command.Name[r.lang]
+		if !ok {
+			name = id
+		}
+		sample(&b, "echoctl_value", labels("id", id, "unit", v.command.Unit.String(), "name", name), v.value)
+	}
+
+	header(&b, "echoctl_can_frames_received_total", "counter", "Frames received, per CAN ID.")
+	writeCanIdCounters(&b, "echoctl_can_frames_received_total", r.framesReceived)
+	header(&b, "echoctl_can_unknown_frames_total", "counter", "Received frames matching no known command, per CAN ID.")
+	writeCanIdCounters(&b, "echoctl_can_unknown_frames_total", r.unknownFrames)
+	header(&b, "echoctl_can_requests_sent_total", "counter", "Requests sent, per command and kind.")
+	requests := maps.Keys(r.requestsSent)
+	slices.SortFunc(requests, func(a, b request) bool { return a.id < b.id || a.id == b.id && a.kind < b.kind })
+	for _, request := range requests {
+		sample(&b, "echoctl_can_requests_sent_total", labels("id", request.id, "kind", request.kind), float64(r.requestsSent[request]))
+	}
+	header(&b, "echoctl_can_send_retries_total", "counter", "Requests sent again, because the send buffer was full.")
+	sample(&b, "echoctl_can_send_retries_total", "", float64(r.sendRetries))
+
+	header(&b, "echoctl_dispatcher_values_dropped_total", "counter", "Values dropped, because the queue of the sink was full.")
+	sinks := maps.Keys(r.valuesDropped)
+	slices.Sort(sinks)
+	for _, sink := range sinks {
+		sample(&b, "echoctl_dispatcher_values_dropped_total", labels("sink", sink), float64(r.valuesDropped[sink]))
+	}
+
+	header(&b, "echoctl_scheduler_lateness_seconds", "histogram", "Delay between the due time of a request and sending it.")
+	writeHistogram(&b, "echoctl_scheduler_lateness_seconds", &r.schedulerLateness)
+	header(&b, "echoctl_mqtt_publish_latency_seconds", "histogram", "Time until the MQTT server acknowledged a published value.")
+	writeHistogram(&b, "echoctl_mqtt_publish_latency_seconds", &r.publishLatency)
+	header(&b, "echoctl_mqtt_publish_errors_total", "counter", "Values, which failed to publish.")
+	sample(&b, "echoctl_mqtt_publish_errors_total", "", float64(r.publishErrors))
+
+	return b.String()
+}
+
+func header(b *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func sample(b *strings.Builder, name string, labels string, value float64) {
+	fmt.Fprintf(b, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeCanIdCounters(b *strings.Builder, name string, counters map[uint32]uint64) {
+	canIds := maps.Keys(counters)
+	slices.Sort(canIds)
+	for _, canId := range canIds {
+		sample(b, name, labels("can_id", fmt.Sprintf("%X", canId)), float64(counters[canId]))
+	}
+}
+
+func writeHistogram(b *strings.Builder, name string, h *histogram) {
+	for i, bound := range latencyBuckets {
+		sample(b, name+"_bucket", labels("le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(h.counts[i]))
+	}
+	sample(b, name+"_bucket", labels("le", "+Inf"), float64(h.count))
+	sample(b, name+"_sum", "", h.sum)
+	sample(b, name+"_count", "", float64(h.count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name value pairs as label set.
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"echoctl/conf"
+	"echoctl/metrics"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("exposes measurements in the Prometheus text format", func(t *testing.T) {
+		t.Parallel()
+
+		registry := metrics.NewRegistry("de")
+		registry.Value(conf.Command{Id: "t_dhw", Name: map[string]string{"de": "Warmwasser \"WW\""}, Type: conf.TypeFloat, Divisor: 10, Unit: conf.UnitDeg}, 485)
+		registry.Value(conf.Command{Id: "flow_rate", Type: conf.TypeLongint, Divisor: 1, Unit: conf.UnitLh}, -12)
+		registry.FrameReceived(canbus.Frame{ID: 0x180})
+		registry.FrameReceived(canbus.Frame{ID: 0x180})
+		registry.FrameReceived(canbus.Frame{ID: 0x300})
+		registry.UnknownFrame(0x300)
+		registry.RequestSent(conf.Command{Id: "t_dhw"}, false)
+		registry.RequestSent(conf.Command{Id: "t_dhw"}, false)
+		registry.RequestSent(conf.Command{Id: "t_dhw"}, true)
+		registry.ValueDropped("mqtt")
+		registry.SendRetry()
+		registry.SchedulerLateness(20 * time.Millisecond)
+		registry.Published(3 * time.Millisecond)
+		registry.Published(2 * time.Second)
+		registry.PublishFailed()
+
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body := recorder.Body.String()
+
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+		for _, line := range []string{
+			"# TYPE echoctl_value gauge",
+			`echoctl_value{id="flow_rate",unit="lh",name="flow_rate"} -12`,
+			`echoctl_value{id="t_dhw",unit="deg",name="Warmwasser \"WW\""} 48.5`,
+			`echoctl_can_frames_received_total{can_id="180"} 2`,
+			`echoctl_can_frames_received_total{can_id="300"} 1`,
+			`echoctl_can_unknown_frames_total{can_id="300"} 1`,
+			`echoctl_can_requests_sent_total{id="t_dhw",kind="read"} 2`,
+			`echoctl_can_requests_sent_total{id="t_dhw",kind="write"} 1`,
+			"echoctl_can_send_retries_total 1",
+			`echoctl_dispatcher_values_dropped_total{sink="mqtt"} 1`,
+			`echoctl_scheduler_lateness_seconds_bucket{le="0.01"} 0`,
+			`echoctl_scheduler_lateness_seconds_bucket{le="0.05"} 1`,
+			"echoctl_scheduler_lateness_seconds_count 1",
+			`echoctl_mqtt_publish_latency_seconds_bucket{le="0.005"} 1`,
+			`echoctl_mqtt_publish_latency_seconds_bucket{le="5"} 2`,
+			`echoctl_mqtt_publish_latency_seconds_bucket{le="+Inf"} 2`,
+			"echoctl_mqtt_publish_latency_seconds_sum 2.003",
+			"echoctl_mqtt_publish_errors_total 1",
+		} {
+			assert.Contains(t, body, line+"\n")
+		}
+	})
+
+	t.Run("decodes values like the other consumers", func(t *testing.T) {
+		t.Parallel()
+
+		registry := metrics.NewRegistry("en")
+		registry.Value(conf.Command{Id: "mode", Type: conf.TypeValue, ValueCode: map[string]int{"standby": 0, "heating": 1}}, 1)
+		registry.Value(conf.Command{Id: "t_ext", Type: conf.TypeFloat, Unit: conf.UnitDeg}, 55)
+
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body := recorder.Body.String()
+
+		assert.Contains(t, body, `echoctl_value{id="mode",unit="none",name="mode"} 1`+"\n", "enum values are exposed by code")
+		assert.Contains(t, body, `echoctl_value{id="t_ext",unit="deg",name="t_ext"} 55`+"\n", "a missing divisor is 1")
+	})
+}
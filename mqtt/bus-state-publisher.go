@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"echoctl/can"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+)
+
+const busStateTopic = "_bus"
+
+type busStatePublisher struct {
+	topic  string
+	states <-chan can.BusState
+	client mqtt.Client
+	log    *zap.Logger
+	tomb   *tomb.Tomb
+}
+
+// BusStatePublisher publishes every change of the CAN bus state (`up` or `down`) to `<topicPrefix>/_bus`. The message is retained, so consumers see the current state.
+type BusStatePublisher interface {
+	// Publish starts publishing. It can be called again after the returned tomb died, to restart publishing.
+	Publish() *tomb.Tomb
+}
+
+var _ BusStatePublisher = (*busStatePublisher)(nil)
+
+// NewBusStatePublisher creates a BusStatePublisher. A nil states channel disables publishing, f.e. if the socket does not recover.
+func NewBusStatePublisher(topicPrefix string, states <-chan can.BusState, client mqtt.Client, log *zap.Logger) BusStatePublisher {
+	return &busStatePublisher{
+		topic:  topicPrefix + "/" + busStateTopic,
+		states: states,
+		client: client,
+		log:    log,
+	}
+}
+
+func (p *busStatePublisher) Publish() *tomb.Tomb {
+	p.tomb = new(tomb.Tomb)
+	p.tomb.Go(p.publish)
+	return p.tomb
+}
+
+func (p *busStatePublisher) publish() error {
+	for {
+		select {
+		case state := <-p.states:
+			if err := p.publishState(state); err != nil {
+				return err
+			}
+		case <-p.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (p *busStatePublisher) publishState(state can.BusState) error {
+	p.log.Debug("mqtt: publishing bus state", zap.String("topic", p.topic), zap.Stringer("state", state))
+	token := p.client.Publish(p.topic, qos, true, state.String())
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("BusStatePublisher.mqttClient.Publish(): %w", err)
+		}
+		return nil
+	case <-p.tomb.Dying():
+		return tomb.ErrDying
+	}
+}
@@ -0,0 +1,55 @@
+package mqtt_test
+
+import (
+	"echoctl/can"
+	"echoctl/mqtt"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestBusStatePublisher(t *testing.T) {
+	t.Run("Publishes bus state changes as retained message", func(t *testing.T) {
+		t.Parallel()
+
+		mqttClient := NewClientStub()
+		states := make(chan can.BusState, 1)
+		publisher := mqtt.NewBusStatePublisher("prfx", states, mqttClient, zap.NewNop())
+
+		tmb := publisher.Publish()
+		for _, state := range []can.BusState{can.BusUp, can.BusDown} {
+			states <- state
+			readWithTimeout(t, mqttClient.GetPublished(), func(frame Frame) {
+				assert.Equal(t, "prfx/_bus", frame.topic, "Topic should be the same")
+				assert.True(t, frame.retained, "Bus state should be retained")
+				assert.Equal(t, state.String(), frame.payload)
+			})
+		}
+		tmb.Kill(nil)
+		select {
+		case <-tmb.Dead():
+		case <-time.After(time.Second):
+			assert.Fail(t, "BusStatePublisher failed to shut down in 1s")
+		}
+	})
+
+	t.Run("Publishes again after a restart", func(t *testing.T) {
+		t.Parallel()
+
+		mqttClient := NewClientStub()
+		states := make(chan can.BusState, 1)
+		publisher := mqtt.NewBusStatePublisher("prfx", states, mqttClient, zap.NewNop())
+
+		tmb := publisher.Publish()
+		tmb.Kill(nil)
+		<-tmb.Dead()
+
+		tmb = publisher.Publish()
+		defer tmb.Kill(nil)
+		states <- can.BusDown
+		readWithTimeout(t, mqttClient.GetPublished(), func(frame Frame) {
+			assert.Equal(t, can.BusDown.String(), frame.payload)
+		})
+	})
+}
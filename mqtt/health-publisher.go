@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"echoctl/can"
+	"encoding/json"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"time"
+)
+
+const healthTopic = "_health"
+
+// healthPublishInterval limits the publish rate. Error frames come in bursts, f.e. thousands per second without ACK.
+const healthPublishInterval = time.Second
+
+type healthPublisher struct {
+	topic    string
+	healths  <-chan can.Health
+	interval time.Duration
+	client   mqtt.Client
+	log      *zap.Logger
+	tomb     *tomb.Tomb
+}
+
+// HealthPublisher publishes the CAN bus health as JSON to `<topicPrefix>/_health`, at most once per second. The message is retained, so consumers see the current health.
+type HealthPublisher interface {
+	// Publish starts publishing. It can be called again after the returned tomb died, to restart publishing.
+	Publish() *tomb.Tomb
+}
+
+var _ HealthPublisher = (*healthPublisher)(nil)
+
+// HealthTopic returns the topic the HealthPublisher publishes to.
+func HealthTopic(topicPrefix string) string {
+	return topicPrefix + "/" + healthTopic
+}
+
+func NewHealthPublisher(topicPrefix string, healths <-chan can.Health, client mqtt.Client, log *zap.Logger) HealthPublisher {
+	return &healthPublisher{
+		topic:    HealthTopic(topicPrefix),
+		healths:  healths,
+		interval: healthPublishInterval,
+		client:   client,
+		log:      log,
+	}
+}
+
+func (p *healthPublisher) Publish() *tomb.Tomb {
+	p.tomb = new(tomb.Tomb)
+	p.tomb.Go(p.publish)
+	return p.tomb
+}
+
+func (p *healthPublisher) publish() error {
+	for {
+		select {
+		case health := <-p.healths:
+			if err := p.publishHealth(health); err != nil {
+				return err
+			}
+		case <-p.tomb.Dying():
+			return tomb.ErrDying
+		}
+
+		// Changes meanwhile are coalesced by the healths channel.
+		select {
+		case <-time.After(p.interval):
+		case <-p.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (p *healthPublisher) publishHealth(health can.Health) error {
+	payload, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("marshal bus health: %w", err)
+	}
+
+	p.log.Debug("mqtt: publishing bus health", zap.String("topic", p.topic), zap.String("state", health.State))
+	token := p.client.Publish(p.topic, qos, true, payload)
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("HealthPublisher.mqttClient.Publish(): %w", err)
+		}
+		return nil
+	case <-p.tomb.Dying():
+		return tomb.ErrDying
+	}
+}
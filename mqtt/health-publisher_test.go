@@ -0,0 +1,36 @@
+package mqtt_test
+
+import (
+	"echoctl/can"
+	"echoctl/mqtt"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestHealthPublisher(t *testing.T) {
+	t.Run("Publishes bus health as retained JSON", func(t *testing.T) {
+		t.Parallel()
+
+		mqttClient := NewClientStub()
+		healths := make(chan can.Health, 1)
+		publisher := mqtt.NewHealthPublisher("prfx", healths, mqttClient, zap.NewNop())
+
+		tmb := publisher.Publish()
+		healths <- can.Health{State: can.StateBusOff, TxErrors: 255, BusOff: 1}
+		readWithTimeout(t, mqttClient.GetPublished(), func(frame Frame) {
+			assert.Equal(t, "prfx/_health", frame.topic, "Topic should be the same")
+			assert.Equal(t, mqtt.HealthTopic("prfx"), frame.topic)
+			assert.True(t, frame.retained, "Bus health should be retained")
+			assert.Contains(t, string(frame.payload.([]byte)), `"state":"bus-off","tx_errors":255,"rx_errors":0`)
+			assert.Contains(t, string(frame.payload.([]byte)), `"bus_off":1`)
+		})
+		tmb.Kill(nil)
+		select {
+		case <-tmb.Dead():
+		case <-time.After(time.Second):
+			assert.Fail(t, "HealthPublisher failed to shut down in 1s")
+		}
+	})
+}
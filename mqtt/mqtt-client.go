@@ -5,6 +5,7 @@ import (
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
@@ -18,6 +19,8 @@ type mqttConfigurer struct {
 	routes map[string]func(mqtt.Client, mqtt.Message)
 }
 
+var setLibraryLoggers sync.Once
+
 type Routes = map[string]func(mqtt.Client, mqtt.Message)
 
 type mqttLoggerZapAdapter struct {
@@ -26,11 +29,14 @@ type mqttLoggerZapAdapter struct {
 }
 
 func NewClient(serverAddress string, clientId string, user string, password string, log *zap.Logger, routes Routes) (mqtt.Client, error) {
-	sugaredLogger := log.Sugar()
-	mqtt.ERROR = mqttLoggerZapAdapter{print: sugaredLogger.Error, printf: sugaredLogger.Errorf}
-	mqtt.CRITICAL = mqttLoggerZapAdapter{print: sugaredLogger.Error, printf: sugaredLogger.Errorf}
-	mqtt.WARN = mqttLoggerZapAdapter{print: sugaredLogger.Warn, printf: sugaredLogger.Warnf}
-	mqtt.DEBUG = mqttLoggerZapAdapter{print: sugaredLogger.Debug, printf: sugaredLogger.Debugf}
+	// The loggers of the mqtt library are global, and read by the goroutines of all clients. Set them only once.
+	setLibraryLoggers.Do(func() {
+		sugaredLogger := log.Sugar()
+		mqtt.ERROR = mqttLoggerZapAdapter{print: sugaredLogger.Error, printf: sugaredLogger.Errorf}
+		mqtt.CRITICAL = mqttLoggerZapAdapter{print: sugaredLogger.Error, printf: sugaredLogger.Errorf}
+		mqtt.WARN = mqttLoggerZapAdapter{print: sugaredLogger.Warn, printf: sugaredLogger.Warnf}
+		mqtt.DEBUG = mqttLoggerZapAdapter{print: sugaredLogger.Debug, printf: sugaredLogger.Debugf}
+	})
 
 	configurer := &mqttConfigurer{
 		log:    log,
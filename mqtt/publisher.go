@@ -4,12 +4,14 @@ import (
 	"echoctl/conf"
 	"echoctl/dispatcher"
 	"echoctl/flowcontrol"
+	"echoctl/metrics"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"go.uber.org/zap"
 	"gopkg.in/tomb.v2"
 	"math"
 	"strconv"
+	"time"
 )
 
 type publisher struct {
@@ -18,26 +20,29 @@ type publisher struct {
 	log         *zap.Logger
 	tomb        *tomb.Tomb
 	client      mqtt.Client
+	collector   metrics.Collector
 }
 
 type Publisher interface {
+	// Publish starts publishing. It can be called again after the returned tomb died, to restart publishing.
 	Publish() *tomb.Tomb
 }
 
 var _ Publisher = (*publisher)(nil)
 
-func NewPublisher(topicPrefix string, inbound <-chan dispatcher.CommandValue, client mqtt.Client, log *zap.Logger) Publisher {
+func NewPublisher(topicPrefix string, inbound <-chan dispatcher.CommandValue, client mqtt.Client, collector metrics.Collector, log *zap.Logger) Publisher {
 	p := &publisher{
 		topicPrefix: topicPrefix,
 		client:      client,
 		inbound:     inbound,
 		log:         log,
-		tomb:        new(tomb.Tomb),
+		collector:   collector,
 	}
 	return p
 }
 
 func (p *publisher) Publish() *tomb.Tomb {
+	p.tomb = new(tomb.Tomb)
 	p.tomb.Go(p.publish)
 	return p.tomb
 }
@@ -66,12 +71,15 @@ func (p *publisher) publishCmd(cmd dispatcher.CommandValue) error {
 	if err != nil {
 		return convertError{cmd, err}
 	}
+	start := time.Now()
 	token := p.publishCmdValue(cmd, value)
 	select {
 	case <-token.Done():
 		if err := token.Error(); err == nil {
+			p.collector.Published(time.Since(start))
 			return nil
 		} else {
+			p.collector.PublishFailed()
 			return fmt.Errorf("Publisher.mqttClient.Publish(): %w", err)
 		}
 
@@ -107,10 +115,14 @@ func convert(commandValue dispatcher.CommandValue) (string, error) {
 	case conf.TypeValue:
 		return getLabel(commandValue.Value, commandValue.Cmd.ValueCode)
 	case conf.TypeLongint:
-		assertNonZeroDivisor(commandValue)
+		if err := assertNonZeroDivisor(commandValue); err != nil {
+			return "", err
+		}
 		return strconv.Itoa(int(math.Round(float64(applyDivisor(commandValue.Value, commandValue.Cmd.Divisor))))), nil
 	case conf.TypeFloat:
-		assertNonZeroDivisor(commandValue)
+		if err := assertNonZeroDivisor(commandValue); err != nil {
+			return "", err
+		}
 		return strconv.FormatFloat(float64(applyDivisor(commandValue.Value, commandValue.Cmd.Divisor)), 'f', 4, 32), nil
 	case conf.TypeNoType:
 		fallthrough
@@ -119,10 +131,11 @@ func convert(commandValue dispatcher.CommandValue) (string, error) {
 	}
 }
 
-func assertNonZeroDivisor(commandValue dispatcher.CommandValue) {
+func assertNonZeroDivisor(commandValue dispatcher.CommandValue) error {
 	if commandValue.Cmd.Divisor == 0 {
-		panic(fmt.Sprintf("Divisor must not be 0: %v", commandValue))
+		return fmt.Errorf("divisor of command %s must not be 0", commandValue.Cmd.Id)
 	}
+	return nil
 }
 
 func applyDivisor(value int16, divisor float32) float32 {
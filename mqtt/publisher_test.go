@@ -3,6 +3,7 @@ package mqtt_test
 import (
 	"echoctl/conf"
 	"echoctl/dispatcher"
+	"echoctl/metrics"
 	"echoctl/mqtt"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -75,6 +76,20 @@ func TestNewPublisher(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("Skips command without divisor", func(t *testing.T) {
+		t.Parallel()
+
+		toPublisher, mqttClient, publisher := NewPublisher("")
+
+		startAndRun(t, publisher, func() {
+			toPublisher <- NewFloatCommand("temp", 12345, 0)
+			toPublisher <- NewLongIntCommand("temp_ext", 16, 1)
+			readWithTimeout(t, mqttClient.GetPublished(), func(frame Frame) {
+				assert.Equal(t, "/temp_ext", frame.topic, "Command without divisor should be skipped")
+			})
+		})
+	})
 }
 
 func startAndRun(t *testing.T, publisher mqtt.Publisher, f func()) {
@@ -103,7 +118,7 @@ func NewPublisher(topicPrefix string) (chan dispatcher.CommandValue, *ClientStub
 	toPublisher := make(chan dispatcher.CommandValue, 1)
 	log := zap.NewNop()
 	mqttClient := NewClientStub()
-	publisher := mqtt.NewPublisher(topicPrefix, toPublisher, mqttClient, log)
+	publisher := mqtt.NewPublisher(topicPrefix, toPublisher, mqttClient, metrics.Nop, log)
 	return toPublisher, mqttClient, publisher
 }
 
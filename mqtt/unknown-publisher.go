@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"echoctl/dispatcher"
+	"encoding/json"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"time"
+)
+
+const unknownCommandsTopic = "_unknown"
+
+// UnknownCommandsSource provides the catalog of unknown commands. Implemented by dispatcher.Dispatcher.
+type UnknownCommandsSource interface {
+	UnknownCommands() []dispatcher.UnknownCommand
+}
+
+type unknownPublisher struct {
+	topic    string
+	interval time.Duration
+	source   UnknownCommandsSource
+	client   mqtt.Client
+	log      *zap.Logger
+	tomb     *tomb.Tomb
+}
+
+// UnknownPublisher periodically publishes the unknown command catalog as JSON array to `<topicPrefix>/_unknown`. The message is retained, so it survives restarts of the consumer.
+type UnknownPublisher interface {
+	// Publish starts publishing. It can be called again after the returned tomb died, to restart publishing.
+	Publish() *tomb.Tomb
+}
+
+var _ UnknownPublisher = (*unknownPublisher)(nil)
+
+func NewUnknownPublisher(topicPrefix string, interval time.Duration, source UnknownCommandsSource, client mqtt.Client, log *zap.Logger) UnknownPublisher {
+	return &unknownPublisher{
+		topic:    topicPrefix + "/" + unknownCommandsTopic,
+		interval: interval,
+		source:   source,
+		client:   client,
+		log:      log,
+	}
+}
+
+func (p *unknownPublisher) Publish() *tomb.Tomb {
+	p.tomb = new(tomb.Tomb)
+	p.tomb.Go(p.publish)
+	return p.tomb
+}
+
+func (p *unknownPublisher) publish() error {
+	if p.interval <= 0 {
+		// Publishing is disabled. Wait on Dying() to keep app running.
+		<-p.tomb.Dying()
+		return nil
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.publishCatalog(); err != nil {
+				return err
+			}
+		case <-p.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (p *unknownPublisher) publishCatalog() error {
+	commands := p.source.UnknownCommands()
+	if commands == nil {
+		commands = []dispatcher.UnknownCommand{}
+	}
+	payload, err := json.Marshal(commands)
+	if err != nil {
+		return fmt.Errorf("marshal unknown commands: %w", err)
+	}
+
+	p.log.Debug("mqtt: publishing unknown commands", zap.String("topic", p.topic), zap.Int("count", len(commands)))
+	token := p.client.Publish(p.topic, qos, true, payload)
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("UnknownPublisher.mqttClient.Publish(): %w", err)
+		}
+		return nil
+	case <-p.tomb.Dying():
+		return tomb.ErrDying
+	}
+}
@@ -0,0 +1,48 @@
+package mqtt_test
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/mqtt"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type unknownCommandsSourceStub []dispatcher.UnknownCommand
+
+func (s unknownCommandsSourceStub) UnknownCommands() []dispatcher.UnknownCommand {
+	return s
+}
+
+func TestUnknownPublisher(t *testing.T) {
+	t.Run("Publishes catalog periodically as retained JSON", func(t *testing.T) {
+		t.Parallel()
+
+		mqttClient := NewClientStub()
+		source := unknownCommandsSourceStub{
+			{CanId: 0x180, Code: conf.CommandBytes{0x20, 0x0A, 0xFA, 0x01, 0x12}, Count: 2, Value: 7, Min: 3, Max: 7},
+		}
+		publisher := mqtt.NewUnknownPublisher("prfx", 10*time.Millisecond, source, mqttClient, zap.NewNop())
+
+		tmb := publisher.Publish()
+		readWithTimeout(t, mqttClient.GetPublished(), func(frame Frame) {
+			assert.Equal(t, "prfx/_unknown", frame.topic, "Topic should be the same")
+			assert.True(t, frame.retained, "Catalog should be retained")
+			assert.Contains(t, string(frame.payload.([]byte)), `"can_id":"180","code":"20 0A FA 01 12"`)
+			assert.Contains(t, string(frame.payload.([]byte)), `"count":2,"value":7,"min":3,"max":7`)
+		})
+		tmb.Kill(nil)
+		// Unblock a pending publish of the next tick.
+		select {
+		case <-mqttClient.GetPublished():
+		case <-tmb.Dead():
+		}
+		select {
+		case <-tmb.Dead():
+		case <-time.After(time.Second):
+			t.Log("UnknownPublisher failed to shut down in 1s")
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"echoctl/conf"
+	"fmt"
+	"os"
+)
+
+func runPrintCommands(cliOpts commandLineOptions) {
+	commands, err := readCommands(cliOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading commands: %s\n", err)
+		os.Exit(1)
+	}
+	buf, err := conf.MarshalCommands(commands)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encoding commands: %s\n", err)
+		os.Exit(1)
+	}
+	_, _ = os.Stdout.Write(buf)
+}
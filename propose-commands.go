@@ -0,0 +1,24 @@
+package main
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/propose"
+	"fmt"
+	"os"
+)
+
+func runProposeCommands(cliOpts commandLineOptions) {
+	unknown, err := dispatcher.ReadUnknownCommandsFile(cliOpts.Catalog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading catalog %s: %s\n", cliOpts.Catalog, err)
+		os.Exit(1)
+	}
+
+	proposals := propose.Commands(unknown)
+	if err := conf.WriteCommands(cliOpts.Out, proposals); err != nil {
+		fmt.Fprintf(os.Stderr, "writing %s: %s\n", cliOpts.Out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d proposed commands from %d catalog entries to %s\n", len(proposals), len(unknown), cliOpts.Out)
+}
@@ -0,0 +1,133 @@
+package propose
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// requestCanId is the CAN ID echoctl sends its requests with. Most commands in commands_hpsu.json use it.
+	requestCanId conf.CanId = 0x190
+
+	// requestLength is the length of a request frame, f.e. `31 00 FA 06 95 00 00`.
+	requestLength = 7
+
+	// readRequest is the low nibble of the first command byte, marking a read request.
+	readRequest = 0x1
+
+	// readResponse is the low nibble of the first command byte, marking the response to a read request.
+	readResponse = 0x2
+
+	// extendedRegister prefixes two byte register addresses, f.e. `FA 01 12`.
+	extendedRegister = 0xFA
+
+	// idPrefix is prepended to the ids of proposed commands, so they are easy to spot in the commands database.
+	idPrefix = "unknown_"
+)
+
+// Commands turns the unknown command catalog into skeleton command definitions in the format of commands_hpsu.json. The proposals are meant to be reviewed, named and then merged into the commands database by hand.
+//
+// The response is the observed frame (CAN ID and code), so the proposed command decodes exactly the frames which were observed. The request is a read request sent to the observed CAN ID for the same register, shaped like the existing requests: `31 00 FA 01 12 00 00` for a register observed as `xx xx FA 01 12` on CAN ID 0x180. The first byte addresses the receiver: its high nibble is the receiver CAN ID divided by 0x80.
+//
+// The register is taken from the code as split by dispatcher.SplitCode, catalogs of older versions may carry value bytes of one byte registers in the code. Entries with a code too short for the register are skipped, because no request can be derived. Read requests of other bus participants are skipped as well, they carry no value.
+func Commands(unknown []dispatcher.UnknownCommand) map[string]conf.Command {
+	result := make(map[string]conf.Command, len(unknown))
+	for i := range unknown {
+		cmd, ok := command(&unknown[i])
+		if !ok {
+			continue
+		}
+		result[cmd.Id] = cmd
+	}
+	return result
+}
+
+func command(unknown *dispatcher.UnknownCommand) (conf.Command, bool) {
+	code, _, ok := dispatcher.SplitCode(unknown.Code)
+	if !ok || code[0]&0x0F == readRequest {
+		return conf.Command{}, false
+	}
+	id := commandId(unknown.CanId, code)
+	valueType, divisor := guessType(unknown.Min, unknown.Max)
+	return conf.Command{
+		Id: id,
+		Name: map[string]string{
+			"de": id,
+			"en": id,
+		},
+		Description: map[string]string{
+			"en": description(unknown),
+		},
+		Request:  RequestFor(unknown.CanId, code[2:]),
+		Response: conf.RequestCommand{CanId: unknown.CanId, CommandBytes: code},
+		Divisor:  divisor,
+		Type:     valueType,
+	}, true
+}
+
+// RequestFor returns a read request for register, sent to the receiver with CAN ID target.
+func RequestFor(target conf.CanId, register []byte) conf.RequestCommand {
+	bytes := make(conf.CommandBytes, 0, requestLength)
+	bytes = append(bytes, byte(target/0x80)<<4|readRequest, 0x00)
+	bytes = append(bytes, register...)
+	for len(bytes) < requestLength {
+		bytes = append(bytes, 0x00)
+	}
+	return conf.RequestCommand{CanId: requestCanId, CommandBytes: bytes}
+}
+
+// ResponseFor returns the response to the read request, as sent by the receiver. The receiver answers on the CAN ID addressed by the high nibble of the first request byte. The response starts with the address of the requester, followed by the register: `32 10 FA 01 12` for the request `31 00 FA 01 12 00 00` sent on 0x190, or `32 10 0E` for `31 00 0E 00 00 00 00`, as registers without the FA prefix are one byte. Returns false, if the request is too short to carry a register.
+func ResponseFor(request conf.RequestCommand) (conf.RequestCommand, bool) {
+	if len(request.CommandBytes) < 3 || request.CommandBytes[2] == extendedRegister && len(request.CommandBytes) < 5 {
+		return conf.RequestCommand{}, false
+	}
+	register := request.CommandBytes[2:3]
+	if request.CommandBytes[2] == extendedRegister {
+		register = request.CommandBytes[2:5]
+	}
+	bytes := make(conf.CommandBytes, 0, 2+len(register))
+	bytes = append(bytes, byte(request.CanId/0x80)<<4|readResponse, byte(request.CanId&0x7F))
+	bytes = append(bytes, register...)
+	return conf.RequestCommand{CanId: conf.CanId(request.CommandBytes[0]>>4) * 0x80, CommandBytes: bytes}, true
+}
+
+func commandId(canId conf.CanId, code []byte) string {
+	// The same register is observed with different command bytes (f.e. `20 0A FA 09 3C` and `22 0A FA 09 3C`), so the whole code is part of the id.
+	hex := strings.ReplaceAll(fmt.Sprintf("% x", code), " ", "_")
+	return fmt.Sprintf("%s%x_%s", idPrefix, uint32(canId), hex)
+}
+
+func description(unknown *dispatcher.UnknownCommand) string {
+	text := fmt.Sprintf("Proposed from %d observed frame(s) `% X` on CAN ID 0x%X, raw values %d..%d, last %d.", unknown.Count, []byte(unknown.Code), uint32(unknown.CanId), unknown.Min, unknown.Max, unknown.Value)
+	if !unknown.FirstSeen.IsZero() {
+		text += fmt.Sprintf(" Seen %s - %s.", unknown.FirstSeen.Format(time.RFC3339), unknown.LastSeen.Format(time.RFC3339))
+	}
+	return text
+}
+
+// guessType guesses the value type from the range of observed raw values:
+//   - flags and small numbers are enum values,
+//   - values using the high byte only are enum values (like `anti_leg_day`),
+//   - values which fit a temperature in 1/10 °C are floats with divisor 10 (like most temperatures),
+//   - everything else is a longint.
+func guessType(min, max uint16) (conf.ValueType, float32) {
+	switch {
+	case max <= 16:
+		return conf.TypeValue, 0
+	case min%0x100 == 0 && max%0x100 == 0:
+		return conf.TypeValue, 0
+	case isTemperature(min) && isTemperature(max):
+		return conf.TypeFloat, 10
+	default:
+		return conf.TypeLongint, 1
+	}
+}
+
+// isTemperature reports whether raw interpreted as int16 is a plausible temperature in 1/10 °C.
+func isTemperature(raw uint16) bool {
+	value := int16(raw)
+	return value >= -500 && value <= 1000
+}
@@ -0,0 +1,98 @@
+package propose_test
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/propose"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	t.Run("derives request and response from observed frame", func(t *testing.T) {
+		t.Parallel()
+
+		commands := propose.Commands([]dispatcher.UnknownCommand{
+			newUnknown(0x180, conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}, 0, 1),
+		})
+
+		cmd, ok := commands["unknown_180_32_10_fa_01_12"]
+		if assert.True(t, ok, "expected a proposal with id derived from CAN ID and code") {
+			assert.Equal(t, conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x12, 0x00, 0x00}}, cmd.Request)
+			assert.Equal(t, conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}}, cmd.Response)
+			assert.NotEmpty(t, cmd.Name["en"], "a proposal needs a name, HA discovery depends on it")
+		}
+	})
+
+	t.Run("takes the register of one byte registers without value bytes", func(t *testing.T) {
+		t.Parallel()
+
+		// Catalogs of older versions carry the value of one byte registers in the code.
+		commands := propose.Commands([]dispatcher.UnknownCommand{
+			newUnknown(0x180, conf.CommandBytes{0x32, 0x10, 0x0E, 0x01, 0xE0}, 480, 480),
+		})
+
+		cmd, ok := commands["unknown_180_32_10_0e"]
+		if assert.True(t, ok, "expected a proposal for the register, got %v", commands) {
+			assert.Equal(t, conf.CommandBytes{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}, cmd.Request.CommandBytes)
+			assert.Equal(t, conf.CommandBytes{0x32, 0x10, 0x0E}, cmd.Response.CommandBytes)
+		}
+	})
+
+	t.Run("addresses request to observed CAN ID", func(t *testing.T) {
+		t.Parallel()
+
+		request := propose.RequestFor(0x300, []byte{0xFA, 0x01, 0x2B})
+		assert.Equal(t, conf.CommandBytes{0x61, 0x00, 0xFA, 0x01, 0x2B, 0x00, 0x00}, request.CommandBytes)
+	})
+
+	t.Run("derives response from request", func(t *testing.T) {
+		t.Parallel()
+
+		commands, err := conf.ReadCommands("../commands_hpsu.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, cmd := range commands {
+			if len(cmd.Response.CommandBytes) == 0 {
+				continue
+			}
+			response, ok := propose.ResponseFor(cmd.Request)
+			assert.True(t, ok, id)
+			assert.Equal(t, cmd.Response, response, id)
+		}
+
+		_, ok := propose.ResponseFor(conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01}})
+		assert.False(t, ok, "truncated register")
+	})
+
+	t.Run("skips read requests of other participants", func(t *testing.T) {
+		t.Parallel()
+
+		commands := propose.Commands([]dispatcher.UnknownCommand{
+			newUnknown(0x10A, conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x48}, 0, 0),
+		})
+		assert.Empty(t, commands)
+	})
+
+	t.Run("guesses type from value range", func(t *testing.T) {
+		t.Parallel()
+
+		commands := propose.Commands([]dispatcher.UnknownCommand{
+			newUnknown(0x180, conf.CommandBytes{0x20, 0x0A, 0xFA, 0x00, 0x01}, 0, 1),
+			newUnknown(0x180, conf.CommandBytes{0x20, 0x0A, 0xFA, 0x00, 0x02}, 256, 2816),
+			newUnknown(0x180, conf.CommandBytes{0x20, 0x0A, 0xFA, 0x00, 0x03}, 215, 482),
+			newUnknown(0x180, conf.CommandBytes{0x20, 0x0A, 0xFA, 0x00, 0x04}, 2000, 40000),
+		})
+
+		assert.Equal(t, conf.TypeValue, commands["unknown_180_20_0a_fa_00_01"].Type, "flags are enum values")
+		assert.Equal(t, conf.TypeValue, commands["unknown_180_20_0a_fa_00_02"].Type, "high byte values are enum values")
+		assert.Equal(t, conf.TypeFloat, commands["unknown_180_20_0a_fa_00_03"].Type, "temperature range is float")
+		assert.Equal(t, float32(10), commands["unknown_180_20_0a_fa_00_03"].Divisor, "temperature has divisor 10")
+		assert.Equal(t, conf.TypeLongint, commands["unknown_180_20_0a_fa_00_04"].Type, "everything else is longint")
+	})
+}
+
+func newUnknown(canId conf.CanId, code conf.CommandBytes, min uint16, max uint16) dispatcher.UnknownCommand {
+	return dispatcher.UnknownCommand{CanId: canId, Code: code, Count: 1, Value: max, Min: min, Max: max}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/scan"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func runScan(cliOpts commandLineOptions) {
+	log, err := getLogConfig(cliOpts.Debug).Build()
+	if err != nil {
+		panic(err)
+	}
+	options, err := scanOptions(cliOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	configuration, err := conf.LoadConfig(cliOpts.Config, os.Environ())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	socket, err := can.Open(configuration.Can.Iface, nil, log.Named("socket"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening %s: %s\n", configuration.Can.Iface, err)
+		os.Exit(1)
+	}
+	defer socket.Close()
+
+	frames := make(chan canbus.Frame, 10)
+	readerTomb := can.NewReader(socket, frames, log.Named("reader")).Read()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		// A failing reader ends the scan.
+		<-readerTomb.Dying()
+		cancel()
+	}()
+
+	scanner := scan.NewScanner(socket, frames, options, log.Named("scan"))
+	state, err := scanner.LoadState()
+	if err != nil {
+		_ = socket.Close()
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	scanErr := scanner.Run(ctx, &state)
+	readerTomb.Kill(nil)
+	_ = readerTomb.Wait()
+
+	commands := scanner.Commands(&state)
+	if err := conf.WriteCommands(cliOpts.Out, commands); err != nil {
+		fmt.Fprintf(os.Stderr, "writing %s: %s\n", cliOpts.Out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d responding registers to %s\n", len(commands), cliOpts.Out)
+
+	if readerErr := readerTomb.Err(); readerErr != nil {
+		log.Error("reader", zap.Error(readerErr))
+	}
+	if scanErr != nil && scanErr != context.Canceled {
+		log.Error("scan", zap.Error(scanErr))
+		os.Exit(1)
+	}
+	if !state.Done {
+		fmt.Printf("scan interrupted, resume with the same command line, state is in %s\n", options.StateFile)
+	}
+}
+
+func scanOptions(cliOpts commandLineOptions) (scan.Options, error) {
+	scanRange, err := scan.ParseRange(cliOpts.Range)
+	if err != nil {
+		return scan.Options{}, err
+	}
+	target, err := strconv.ParseUint(cliOpts.Target, 16, 11)
+	if err != nil {
+		return scan.Options{}, fmt.Errorf("--target: %w", err)
+	}
+	receiver, err := strconv.ParseUint(cliOpts.Receiver, 16, 11)
+	if err != nil {
+		return scan.Options{}, fmt.Errorf("--receiver: %w", err)
+	}
+	if cliOpts.Rate <= 0 {
+		return scan.Options{}, fmt.Errorf("--rate must be positive")
+	}
+	timeout, err := time.ParseDuration(cliOpts.Timeout)
+	if err != nil {
+		return scan.Options{}, fmt.Errorf("--timeout: %w", err)
+	}
+	return scan.Options{
+		Range:     scanRange,
+		Target:    conf.CanId(target),
+		Receiver:  conf.CanId(receiver),
+		Interval:  time.Duration(float64(time.Second) / cliOpts.Rate),
+		Timeout:   timeout,
+		StateFile: cliOpts.State,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/exp/slices"
+	"strings"
+)
+
+// Range is a range of register addresses. A register is addressed by optional prefix bytes followed by the address, f.e. the extended register `FA 01 12` has prefix `FA` and the two byte address `01 12`.
+type Range struct {
+	Prefix []byte
+	Start  uint32
+	End    uint32
+
+	// Width is the number of address bytes.
+	Width int
+}
+
+// ParseRange parses ranges like `FA:0000-FA:FFFF` (extended registers) or `00-FF` (registers without prefix). Both ends must have the same prefix and width.
+func ParseRange(s string) (Range, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("range %q: expected <start>-<end>", s)
+	}
+	startPrefix, start, startWidth, err := parseAddress(from)
+	if err != nil {
+		return Range{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	endPrefix, end, endWidth, err := parseAddress(to)
+	if err != nil {
+		return Range{}, fmt.Errorf("range %q: %w", s, err)
+	}
+	if !slices.Equal(startPrefix, endPrefix) || startWidth != endWidth {
+		return Range{}, fmt.Errorf("range %q: start and end must have the same prefix and width", s)
+	}
+	if start > end {
+		return Range{}, fmt.Errorf("range %q: start is after end", s)
+	}
+	return Range{Prefix: startPrefix, Start: start, End: end, Width: startWidth}, nil
+}
+
+func parseAddress(s string) (prefix []byte, address uint32, width int, err error) {
+	prefixPart, addressPart, hasPrefix := strings.Cut(strings.TrimSpace(s), ":")
+	if !hasPrefix {
+		addressPart, prefixPart = prefixPart, ""
+	}
+	prefix, err = hex.DecodeString(prefixPart)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("prefix %q: %w", prefixPart, err)
+	}
+	addressBytes, err := hex.DecodeString(addressPart)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("address %q: %w", addressPart, err)
+	}
+	if len(addressBytes) == 0 || len(addressBytes) > 2 {
+		return nil, 0, 0, fmt.Errorf("address %q: expected one or two bytes", addressPart)
+	}
+	for _, b := range addressBytes {
+		address = address<<8 | uint32(b)
+	}
+	return prefix, address, len(addressBytes), nil
+}
+
+// Register returns the register bytes for address: prefix followed by the address.
+func (r Range) Register(address uint32) []byte {
+	register := slices.Clone(r.Prefix)
+	for i := r.Width - 1; i >= 0; i-- {
+		register = append(register, byte(address>>(8*i)))
+	}
+	return register
+}
+
+// Format formats address in the notation accepted by ParseRange, f.e. `FA:0112`.
+func (r Range) Format(address uint32) string {
+	addressHex := fmt.Sprintf("%0*X", 2*r.Width, address)
+	if len(r.Prefix) == 0 {
+		return addressHex
+	}
+	return fmt.Sprintf("%X:%s", r.Prefix, addressHex)
+}
+
+// ParseAddress parses a single address in the notation returned by Format, and checks that it is part of r.
+func (r Range) ParseAddress(s string) (uint32, error) {
+	prefix, address, width, err := parseAddress(s)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Equal(prefix, r.Prefix) || width != r.Width || address < r.Start || address > r.End {
+		return 0, fmt.Errorf("address %q is not part of range %s", s, r)
+	}
+	return address, nil
+}
+
+// String formats r in the notation accepted by ParseRange, f.e. `FA:0000-FA:FFFF`.
+func (r Range) String() string {
+	return r.Format(r.Start) + "-" + r.Format(r.End)
+}
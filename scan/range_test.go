@@ -0,0 +1,48 @@
+package scan_test
+
+import (
+	"echoctl/scan"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	t.Run("parses extended register range", func(t *testing.T) {
+		t.Parallel()
+
+		r, err := scan.ParseRange("FA:0000-FA:FFFF")
+		assert.NoError(t, err)
+		assert.Equal(t, scan.Range{Prefix: []byte{0xFA}, Start: 0, End: 0xFFFF, Width: 2}, r)
+		assert.Equal(t, []byte{0xFA, 0x01, 0x12}, r.Register(0x0112))
+		assert.Equal(t, "FA:0112", r.Format(0x0112))
+	})
+
+	t.Run("parses range without prefix", func(t *testing.T) {
+		t.Parallel()
+
+		r, err := scan.ParseRange("00-FF")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x0E}, r.Register(0x0E))
+		assert.Equal(t, "0E", r.Format(0x0E))
+	})
+
+	t.Run("rejects mismatching ends", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := scan.ParseRange("FA:0000-FB:FFFF")
+		assert.Error(t, err)
+		_, err = scan.ParseRange("FA:0100-FA:0000")
+		assert.Error(t, err)
+	})
+
+	t.Run("parses address of range", func(t *testing.T) {
+		t.Parallel()
+
+		r, _ := scan.ParseRange("FA:0000-FA:00FF")
+		address, err := r.ParseAddress("FA:0012")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x12), address)
+		_, err = r.ParseAddress("FA:0112")
+		assert.Error(t, err, "address is outside of range")
+	})
+}
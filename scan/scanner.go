@@ -0,0 +1,257 @@
+package scan
+
+import (
+	"context"
+	"echoctl/can"
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/propose"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// answer is the low nibble of the first command byte, marking an answer to a read request.
+	answer = 0x2
+
+	// checkpointEvery is the number of scanned addresses after which the state is saved.
+	checkpointEvery = 64
+)
+
+// Options configure a scan.
+type Options struct {
+	Range Range
+
+	// Target is the CAN ID the read requests are sent on.
+	Target conf.CanId
+
+	// Receiver is the CAN ID of the device which is asked. It is encoded into the first request byte.
+	Receiver conf.CanId
+
+	// Interval is the minimal time between two requests. It limits the bus load caused by the scan.
+	Interval time.Duration
+
+	// Timeout is the time to wait for an answer, before the address is considered as not responding.
+	Timeout time.Duration
+
+	// StateFile persists the scan progress. A scan started with an existing state file resumes where the previous scan stopped. Empty disables persisting.
+	StateFile string
+}
+
+// State is the progress of a scan.
+type State struct {
+	// Range is the scanned range, formatted by Range.String. A state is only resumed by a scan of the same range.
+	Range string `json:"range"`
+
+	// Next is the next address to scan, formatted by Range.Format. Empty if the scan did not start yet.
+	Next string `json:"next"`
+
+	// Done is true if the whole range was scanned.
+	Done bool `json:"done"`
+
+	// Found contains the answers of all responding addresses.
+	Found []dispatcher.UnknownCommand `json:"found"`
+}
+
+// Scanner walks a register range by sending read requests, shaped like the requests in commands_hpsu.json (`31 00 FA xx xx 00 00`), and records which addresses respond.
+type Scanner struct {
+	socket  can.Socket
+	inbound <-chan canbus.Frame
+	options Options
+	log     *zap.Logger
+}
+
+// NewScanner creates a Scanner sending requests to socket. The answers are read from inbound, f.e. fed by a can.Reader on the same socket.
+func NewScanner(socket can.Socket, inbound <-chan canbus.Frame, options Options, log *zap.Logger) *Scanner {
+	return &Scanner{
+		socket:  socket,
+		inbound: inbound,
+		options: options,
+		log:     log,
+	}
+}
+
+// LoadState reads the state of a previous scan from the state file. Returns an empty state if there is no state file. A state file of a scan of another range is an error.
+func (s *Scanner) LoadState() (State, error) {
+	state := State{Range: s.options.Range.String()}
+	if s.options.StateFile == "" {
+		return state, nil
+	}
+	buf, err := os.ReadFile(s.options.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	state = State{}
+	if err = json.Unmarshal(buf, &state); err != nil {
+		return state, fmt.Errorf("state file %s: %w", s.options.StateFile, err)
+	}
+	if err = s.checkRange(state); err != nil {
+		return state, fmt.Errorf("state file %s: %w", s.options.StateFile, err)
+	}
+	return state, nil
+}
+
+// checkRange returns an error, if state is the progress of a scan of another range.
+func (s *Scanner) checkRange(state State) error {
+	if state.Range != s.options.Range.String() {
+		return fmt.Errorf("state of range %q, not %s, use another state file", state.Range, s.options.Range)
+	}
+	return nil
+}
+
+func (s *Scanner) saveState(state *State) error {
+	if s.options.StateFile == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.options.StateFile, buf, 0644)
+}
+
+// Run scans the range, starting at state.Next. It returns when the range was scanned, or ctx is cancelled. The state is saved periodically and on return. state must be the state of a scan of the same range, or a new State.
+func (s *Scanner) Run(ctx context.Context, state *State) (err error) {
+	if state.Range == "" {
+		state.Range = s.options.Range.String()
+	}
+	if err := s.checkRange(*state); err != nil {
+		return err
+	}
+	if state.Done {
+		return nil
+	}
+	address := s.options.Range.Start
+	if state.Next != "" {
+		if address, err = s.options.Range.ParseAddress(state.Next); err != nil {
+			return fmt.Errorf("resuming scan: %w", err)
+		}
+		s.log.Info("resuming scan", zap.String("at", state.Next), zap.Int("found", len(state.Found)))
+	}
+
+	defer func() {
+		if saveErr := s.saveState(state); saveErr != nil && err == nil {
+			err = fmt.Errorf("saving scan state: %w", saveErr)
+		}
+	}()
+
+	limiter := time.NewTicker(s.options.Interval)
+	defer limiter.Stop()
+	for scanned := 1; ; scanned++ {
+		state.Next = s.options.Range.Format(address)
+		select {
+		case <-limiter.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		found, err := s.scanAddress(ctx, address)
+		if err != nil {
+			return err
+		}
+		if found != nil {
+			s.log.Info("found", zap.String("address", s.options.Range.Format(address)), zap.Object("answer", found))
+			state.Found = append(state.Found, *found)
+		}
+
+		if address == s.options.Range.End {
+			state.Next = ""
+			state.Done = true
+			return nil
+		}
+		address++
+		if scanned%checkpointEvery == 0 {
+			state.Next = s.options.Range.Format(address)
+			s.log.Info("progress", zap.String("next", state.Next), zap.Int("found", len(state.Found)))
+			if err := s.saveState(state); err != nil {
+				return fmt.Errorf("saving scan state: %w", err)
+			}
+		}
+	}
+}
+
+// scanAddress requests address and waits for the answer. Returns nil, if the address did not respond within the timeout.
+func (s *Scanner) scanAddress(ctx context.Context, address uint32) (*dispatcher.UnknownCommand, error) {
+	register := s.options.Range.Register(address)
+	request := propose.RequestFor(s.options.Receiver, register)
+	if err := s.send(ctx, canbus.Frame{ID: uint32(s.options.Target), Data: request.CommandBytes}); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(s.options.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case frame := <-s.inbound:
+			if s.isAnswer(frame, register) {
+				return toUnknownCommand(frame, register), nil
+			}
+		case <-timeout.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Scanner) send(ctx context.Context, frame canbus.Frame) error {
+	for {
+		_, err := s.socket.Send(frame)
+		if !errors.Is(err, syscall.ENOBUFS) {
+			return err
+		}
+		// Send buffer full, retry after a short delay like the poller does.
+		select {
+		case <-time.After(can.RetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isAnswer reports whether frame is the receiver's answer to our read request for register: `x2 xx <register> <value>`, with the address of the target in the high nibble and the second byte, like propose.ResponseFor. Answers of other devices, or to other requesters, are ignored.
+func (s *Scanner) isAnswer(frame canbus.Frame, register []byte) bool {
+	codeLength := 2 + len(register)
+	return frame.ID == uint32(s.options.Receiver) &&
+		len(frame.Data) >= codeLength+2 &&
+		frame.Data[0]&0x0F == answer &&
+		frame.Data[0]>>4 == byte(s.options.Target/0x80) &&
+		frame.Data[1] == byte(s.options.Target&0x7F) &&
+		slices.Equal(frame.Data[2:codeLength], register)
+}
+
+func toUnknownCommand(frame canbus.Frame, register []byte) *dispatcher.UnknownCommand {
+	codeLength := 2 + len(register)
+	value := uint16(frame.Data[codeLength])<<8 | uint16(frame.Data[codeLength+1])
+	now := time.Now()
+	return &dispatcher.UnknownCommand{
+		CanId:     conf.CanId(frame.ID),
+		Code:      slices.Clone(frame.Data[:codeLength]),
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     1,
+		Value:     value,
+		Min:       value,
+		Max:       value,
+	}
+}
+
+// Commands turns the found addresses into command definitions in the format of commands_hpsu.json.
+func (s *Scanner) Commands(state *State) map[string]conf.Command {
+	commands := propose.Commands(state.Found)
+	for id, cmd := range commands {
+		cmd.Request.CanId = s.options.Target
+		commands[id] = cmd
+	}
+	return commands
+}
@@ -0,0 +1,154 @@
+package scan_test
+
+import (
+	"context"
+	"echoctl/conf"
+	"echoctl/scan"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// answeringSocket answers read requests for registers in values, like the HPSU does.
+type answeringSocket struct {
+	values  map[uint32]uint16
+	inbound chan canbus.Frame
+	sent    chan canbus.Frame
+
+	// crosstalk precedes each answer by answers for the same register of another device, and to another requester.
+	crosstalk bool
+}
+
+func (s *answeringSocket) Close() error {
+	return nil
+}
+
+func (s *answeringSocket) Send(msg canbus.Frame) (int, error) {
+	s.sent <- msg
+	address := uint32(msg.Data[3])<<8 | uint32(msg.Data[4])
+	if value, ok := s.values[address]; ok {
+		if s.crosstalk {
+			s.inbound <- canbus.Frame{ID: 0x300, Data: []byte{0x32, 0x10, msg.Data[2], msg.Data[3], msg.Data[4], 0, 1}}
+			s.inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x22, 0x0A, msg.Data[2], msg.Data[3], msg.Data[4], 0, 2}}
+		}
+		s.inbound <- canbus.Frame{ID: 0x180, Data: []byte{0x32, 0x10, msg.Data[2], msg.Data[3], msg.Data[4], byte(value >> 8), byte(value)}}
+	}
+	return len(msg.Data), nil
+}
+
+func (s *answeringSocket) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	<-ctx.Done()
+	return canbus.Frame{}, ctx.Err()
+}
+
+func newAnsweringSocket(values map[uint32]uint16) *answeringSocket {
+	return &answeringSocket{
+		values:  values,
+		inbound: make(chan canbus.Frame, 10),
+		sent:    make(chan canbus.Frame, 100),
+	}
+}
+
+func newOptions(t *testing.T, scanRange string) scan.Options {
+	r, err := scan.ParseRange(scanRange)
+	assert.NoError(t, err)
+	return scan.Options{
+		Range:     r,
+		Target:    0x190,
+		Receiver:  0x180,
+		Interval:  time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	}
+}
+
+func TestScanner(t *testing.T) {
+	t.Run("sends read requests and records answers", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newAnsweringSocket(map[uint32]uint16{0x0112: 2816})
+		scanner := scan.NewScanner(socket, socket.inbound, newOptions(t, "FA:0110-FA:0113"), zap.NewNop())
+
+		state, err := scanner.LoadState()
+		assert.NoError(t, err)
+		assert.NoError(t, scanner.Run(context.Background(), &state))
+
+		assert.True(t, state.Done)
+		assert.Len(t, socket.sent, 4, "expected one request per address")
+		first := <-socket.sent
+		assert.Equal(t, uint32(0x190), first.ID)
+		assert.Equal(t, []byte{0x31, 0x00, 0xFA, 0x01, 0x10, 0x00, 0x00}, first.Data)
+
+		if assert.Len(t, state.Found, 1) {
+			assert.Equal(t, conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}, state.Found[0].Code)
+			assert.Equal(t, uint16(2816), state.Found[0].Value)
+		}
+		commands := scanner.Commands(&state)
+		if assert.Len(t, commands, 1) {
+			for _, cmd := range commands {
+				assert.Equal(t, conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x12, 0x00, 0x00}}, cmd.Request)
+			}
+		}
+	})
+
+	t.Run("resumes from state file", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newAnsweringSocket(map[uint32]uint16{0x0110: 1, 0x0113: 2})
+		options := newOptions(t, "FA:0110-FA:0113")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		interrupted := scan.NewScanner(socket, socket.inbound, options, zap.NewNop())
+		state, _ := interrupted.LoadState()
+		state.Next = "FA:0112"
+		assert.ErrorIs(t, interrupted.Run(ctx, &state), context.Canceled)
+
+		resumed := scan.NewScanner(socket, socket.inbound, options, zap.NewNop())
+		state, err := resumed.LoadState()
+		assert.NoError(t, err)
+		assert.Equal(t, "FA:0112", state.Next, "state file should contain the interrupted address")
+		assert.NoError(t, resumed.Run(context.Background(), &state))
+
+		assert.Len(t, socket.sent, 2, "expected requests for the remaining addresses only")
+		if assert.Len(t, state.Found, 1) {
+			assert.Equal(t, uint16(2), state.Found[0].Value)
+		}
+	})
+
+	t.Run("rejects the state of another range", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newAnsweringSocket(map[uint32]uint16{0x0110: 1})
+		options := newOptions(t, "FA:0110-FA:0111")
+		scanner := scan.NewScanner(socket, socket.inbound, options, zap.NewNop())
+		state, _ := scanner.LoadState()
+		assert.NoError(t, scanner.Run(context.Background(), &state))
+
+		other, err := scan.ParseRange("FA:0200-FA:0201")
+		if err != nil {
+			t.Fatal(err)
+		}
+		options.Range = other
+		_, err = scan.NewScanner(socket, socket.inbound, options, zap.NewNop()).LoadState()
+		assert.ErrorContains(t, err, "FA:0110-FA:0111")
+	})
+
+	t.Run("ignores answers of other devices and to other requesters", func(t *testing.T) {
+		t.Parallel()
+
+		socket := newAnsweringSocket(map[uint32]uint16{0x0112: 2816})
+		socket.crosstalk = true
+		scanner := scan.NewScanner(socket, socket.inbound, newOptions(t, "FA:0112-FA:0112"), zap.NewNop())
+		state, _ := scanner.LoadState()
+		assert.NoError(t, scanner.Run(context.Background(), &state))
+
+		if assert.Len(t, state.Found, 1) {
+			assert.Equal(t, conf.CanId(0x180), state.Found[0].CanId)
+			assert.Equal(t, uint16(2816), state.Found[0].Value)
+		}
+	})
+}
@@ -32,6 +32,9 @@ type Trigger[T any] struct {
 
 	// Time when this trigger triggered.
 	TriggeredAt time.Time
+
+	// Time when this trigger was due. It is earlier than TriggeredAt, if the trigger was delayed, f.e. because the consumer didn't read Next in time.
+	DueAt time.Time
 }
 
 type scheduledItem[T any] struct {
@@ -85,7 +88,7 @@ func (s *scheduler[T]) Run(cancel <-chan struct{}) {
 // sendAsNext returns the send status. It returns true if item was passed to next, and return false if it was not able to send (because it would otherwise block).
 func (s *scheduler[T]) sendAsNext(item *scheduledItem[T], triggeredAt time.Time) (sent bool) {
 	select {
-	case s.next <- Trigger[T]{item.data, triggeredAt}:
+	case s.next <- Trigger[T]{Data: item.data, TriggeredAt: triggeredAt, DueAt: item.triggerAt}:
 		sent = true
 	default:
 		sent = false
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"echoctl/app"
+	"echoctl/can"
+	"echoctl/simulate"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"os"
+)
+
+func runSimulate(cliOpts commandLineOptions) {
+	commands, err := readCommands(cliOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading commands: %s\n", err)
+		os.Exit(1)
+	}
+	var values simulate.Config
+	if cliOpts.Values != "" {
+		values, err = simulate.ReadConfig(cliOpts.Values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading %s: %s\n", cliOpts.Values, err)
+			os.Exit(1)
+		}
+	}
+
+	canReaderToSimulator := make(chan canbus.Frame, 10)
+
+	fx.New(
+		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
+			return &fxevent.ZapLogger{Logger: log.Named("fx")}
+		}),
+		fx.Provide(
+			func(log *zap.Logger) (can.Socket, error) {
+				return can.Open(cliOpts.Iface, nil, log.Named("socket"))
+			},
+			func(socket can.Socket, log *zap.Logger) can.Reader {
+				return can.NewReader(socket, canReaderToSimulator, log.Named("reader"))
+			},
+			func(socket can.Socket, log *zap.Logger) (simulate.Simulator, error) {
+				return simulate.NewSimulator(socket, canReaderToSimulator, maps.Values(commands), values, log.Named("sim"))
+			},
+			getLogConfig(cliOpts.Debug).Build,
+		),
+
+		fx.Invoke(func(simulator simulate.Simulator, reader can.Reader, shutdowner fx.Shutdowner, lc fx.Lifecycle, socket can.Socket, log *zap.Logger) {
+			app.RunTombs(
+				lc,
+				shutdowner,
+				fx.DefaultTimeout,
+				log,
+				func(context.Context) {
+					_ = socket.Close()
+				},
+				simulator.Simulate(),
+				reader.Read(),
+			)
+		}),
+	).Run()
+}
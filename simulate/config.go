@@ -0,0 +1,89 @@
+package simulate
+
+import (
+	"fmt"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// Config configures the simulated values. Commands without configuration answer with a plausible default for their unit.
+type Config struct {
+	Values map[string]ValueConfig
+}
+
+// ValueConfig configures the value of a single command. Either Value or Curve must be set.
+type ValueConfig struct {
+	// Value is a static value in the command's unit (f.e. `48.5` for a temperature), or a label of its value codes (f.e. `heating`).
+	Value string
+
+	// Curve maps times of day (`HH:MM`) to values. The value at other times is interpolated linearly, wrapping around at midnight.
+	Curve map[string]float64
+}
+
+func ReadConfig(fileName string) (config Config, err error) {
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = yaml.Unmarshal(buf, &config)
+	return
+}
+
+type curvePoint struct {
+	at    time.Duration
+	value float64
+}
+
+// Curve is a value changing over the day, defined by points in time.
+type Curve struct {
+	points []curvePoint
+}
+
+const day = 24 * time.Hour
+
+// ParseCurve parses the time of day keys of points.
+func ParseCurve(points map[string]float64) (Curve, error) {
+	if len(points) == 0 {
+		return Curve{}, fmt.Errorf("curve needs at least one point")
+	}
+	var curve Curve
+	for timeOfDay, value := range points {
+		at, err := time.Parse("15:04", timeOfDay)
+		if err != nil {
+			return Curve{}, fmt.Errorf("curve point %q: expected HH:MM: %w", timeOfDay, err)
+		}
+		curve.points = append(curve.points, curvePoint{time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute, value})
+	}
+	slices.SortFunc(curve.points, func(a, b curvePoint) bool {
+		return a.at < b.at
+	})
+	return curve, nil
+}
+
+// At returns the interpolated value at the time of day of t.
+func (c Curve) At(t time.Time) float64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Sub(midnight)
+
+	// Find the points before and after now, wrapping around at midnight.
+	last := c.points[len(c.points)-1]
+	before := curvePoint{last.at - day, last.value}
+	for _, point := range c.points {
+		if point.at > now {
+			return interpolate(before, point, now)
+		}
+		before = point
+	}
+	first := c.points[0]
+	return interpolate(before, curvePoint{first.at + day, first.value}, now)
+}
+
+func interpolate(a, b curvePoint, at time.Duration) float64 {
+	if b.at == a.at {
+		return a.value
+	}
+	return a.value + (b.value-a.value)*float64(at-a.at)/float64(b.at-a.at)
+}
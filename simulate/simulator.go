@@ -0,0 +1,264 @@
+package simulate
+
+import (
+	"echoctl/can"
+	"echoctl/conf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-daq/canbus"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/tomb.v2"
+	"math"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// frameLength is the length of frames sent by the HPSU. Responses to short registers are padded with zeros.
+const frameLength = 7
+
+// register is the simulated state of a single command.
+type register struct {
+	command conf.Command
+
+	// raw is the current raw value. Set by static configuration, defaults and writes.
+	raw int16
+
+	// curve overrides raw, if set. A write replaces the curve by the written value.
+	curve *Curve
+}
+
+type simulator struct {
+	socket    can.Socket
+	inbound   <-chan canbus.Frame
+	mutex     sync.Mutex
+	registers []*register
+	now       func() time.Time
+	tomb      *tomb.Tomb
+	log       *zap.Logger
+}
+
+// Simulator simulates the HPSU on the can-bus. It answers every read request of a known command with the command's response and a simulated value, and accepts writes of writable commands.
+type Simulator interface {
+	Simulate() *tomb.Tomb
+
+	// Raw returns the current raw value of the command with id.
+	Raw(id string) (int16, bool)
+}
+
+var _ Simulator = (*simulator)(nil)
+
+// NewSimulator creates a simulator answering the requests read from inbound on socket. Returns an error if config references unknown commands or contains invalid values.
+func NewSimulator(socket can.Socket, inbound <-chan canbus.Frame, commands []conf.Command, config Config, log *zap.Logger) (Simulator, error) {
+	s := &simulator{
+		socket:  socket,
+		inbound: inbound,
+		now:     time.Now,
+		tomb:    new(tomb.Tomb),
+		log:     log,
+	}
+	for i := range commands {
+		s.registers = append(s.registers, &register{command: commands[i], raw: defaultRaw(&commands[i])})
+	}
+	for id, valueConfig := range config.Values {
+		reg := s.find(id)
+		if reg == nil {
+			return nil, fmt.Errorf("simulated value %q: command not found", id)
+		}
+		if err := reg.configure(valueConfig); err != nil {
+			return nil, fmt.Errorf("simulated value %q: %w", id, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *simulator) Simulate() *tomb.Tomb {
+	s.tomb.Go(s.simulate)
+	return s.tomb
+}
+
+func (s *simulator) Raw(id string) (int16, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reg := s.find(id)
+	if reg == nil {
+		return 0, false
+	}
+	return reg.current(s.now()), true
+}
+
+func (s *simulator) simulate() error {
+	for {
+		select {
+		case frame := <-s.inbound:
+			if err := s.process(frame); err != nil {
+				return err
+			}
+		case <-s.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (s *simulator) process(frame canbus.Frame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, reg := range s.registers {
+		if isRequest(frame, reg.command.Request) {
+			return s.answer(reg)
+		}
+		if isWrite(frame, &reg.command) {
+			s.write(reg, frame)
+			return nil
+		}
+	}
+	s.log.Debug("ignoring frame", zap.Uint32("id", frame.ID), zap.String("data", fmt.Sprintf("% X", frame.Data)))
+	return nil
+}
+
+func (s *simulator) answer(reg *register) error {
+	data := make([]byte, 0, frameLength)
+	data = append(data, reg.command.Response.CommandBytes...)
+	data = binary.BigEndian.AppendUint16(data, uint16(reg.current(s.now())))
+	for len(data) < frameLength {
+		data = append(data, 0)
+	}
+	s.log.Debug("answering", zap.String("command", reg.command.Id), zap.String("data", fmt.Sprintf("% X", data)))
+
+	_, err := s.socket.Send(canbus.Frame{ID: uint32(reg.command.Response.CanId), Data: data})
+	if errors.Is(err, syscall.ENOBUFS) {
+		// The requester polls again, drop the answer.
+		s.log.Debug("answering failed. send buffer full. dropping.")
+		return nil
+	}
+	return err
+}
+
+func (s *simulator) write(reg *register, frame canbus.Frame) {
+	if !reg.command.Writable {
+		s.log.Warn("ignoring write to read-only command", zap.String("command", reg.command.Id))
+		return
+	}
+	offset := len(reg.command.Response.CommandBytes)
+	reg.raw = int16(binary.BigEndian.Uint16(frame.Data[offset:]))
+	reg.curve = nil
+	s.log.Info("written", zap.String("command", reg.command.Id), zap.Int16("raw", reg.raw))
+}
+
+func (s *simulator) find(id string) *register {
+	for _, reg := range s.registers {
+		if reg.command.Id == id {
+			return reg
+		}
+	}
+	return nil
+}
+
+// isRequest reports whether frame is the read request of a command, compared like the dispatcher compares responses.
+func isRequest(frame canbus.Frame, request conf.RequestCommand) bool {
+	return frame.ID == uint32(request.CanId) &&
+		len(frame.Data) >= len(request.CommandBytes) &&
+		slices.Equal(frame.Data[:len(request.CommandBytes)], request.CommandBytes)
+}
+
+// isWrite reports whether frame writes the register of command. A write is the read request with the low nibble of the first byte cleared, and the value following the register: `30 00 FA 06 95 00 01` writes 1 to the register read by `31 00 FA 06 95 00 00`.
+func isWrite(frame canbus.Frame, command *conf.Command) bool {
+	request := command.Request.CommandBytes
+	offset := len(command.Response.CommandBytes)
+	return frame.ID == uint32(command.Request.CanId) &&
+		len(request) >= offset && offset >= 2 &&
+		len(frame.Data) >= offset+2 &&
+		frame.Data[0] == request[0]&0xF0 &&
+		frame.Data[1] == request[1] &&
+		slices.Equal(frame.Data[2:offset], request[2:offset])
+}
+
+func (r *register) configure(config ValueConfig) error {
+	switch {
+	case len(config.Curve) > 0:
+		curve, err := ParseCurve(config.Curve)
+		if err != nil {
+			return err
+		}
+		r.curve = &curve
+		return nil
+	case config.Value != "":
+		raw, err := toRaw(&r.command, config.Value)
+		if err != nil {
+			return err
+		}
+		r.raw = raw
+		return nil
+	default:
+		return fmt.Errorf("either value or curve must be set")
+	}
+}
+
+func (r *register) current(now time.Time) int16 {
+	if r.curve != nil {
+		return scale(&r.command, r.curve.At(now))
+	}
+	return r.raw
+}
+
+// toRaw converts a configured value to a raw value. value is either a label of the command's value codes, or a number in the command's unit.
+func toRaw(command *conf.Command, value string) (int16, error) {
+	if code, ok := command.ValueCode[value]; ok {
+		return int16(code), nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a number nor a value code", value)
+	}
+	if command.Type == conf.TypeValue {
+		return int16(number), nil
+	}
+	return scale(command, number), nil
+}
+
+// scale converts a value in the command's unit to the raw value, reverting the division by the divisor. Values exceeding int16 are clamped.
+func scale(command *conf.Command, value float64) int16 {
+	divisor := float64(command.Divisor)
+	if divisor == 0 {
+		divisor = 1
+	}
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(value*divisor))))
+}
+
+// defaultRaw returns a plausible raw value for command, based on its unit and value codes.
+func defaultRaw(command *conf.Command) int16 {
+	if command.Type == conf.TypeValue {
+		codes := make([]int, 0, len(command.ValueCode))
+		for _, code := range command.ValueCode {
+			codes = append(codes, code)
+		}
+		if len(codes) == 0 {
+			return 0
+		}
+		slices.Sort(codes)
+		return int16(codes[0])
+	}
+	switch command.Unit {
+	case conf.UnitDeg:
+		return scale(command, 21.5)
+	case conf.UnitBar:
+		return scale(command, 1.8)
+	case conf.UnitLh:
+		return scale(command, 850)
+	case conf.UnitPercent:
+		return scale(command, 50)
+	case conf.UnitWh, conf.UnitKwh:
+		return scale(command, 1234)
+	case conf.UnitW:
+		return scale(command, 1500)
+	case conf.UnitKw:
+		return scale(command, 1.5)
+	case conf.UnitSec, conf.UnitMin, conf.UnitHour:
+		return scale(command, 30)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,152 @@
+package simulate_test
+
+import (
+	"context"
+	"echoctl/conf"
+	"echoctl/simulate"
+	"github.com/go-daq/canbus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type socketStub struct {
+	sent chan canbus.Frame
+}
+
+func (s *socketStub) Close() error {
+	return nil
+}
+
+func (s *socketStub) Send(msg canbus.Frame) (int, error) {
+	s.sent <- msg
+	return len(msg.Data), nil
+}
+
+func (s *socketStub) RecvCtx(ctx context.Context) (canbus.Frame, error) {
+	<-ctx.Done()
+	return canbus.Frame{}, ctx.Err()
+}
+
+func TestSimulator(t *testing.T) {
+	t.Run("answers requests with configured value", func(t *testing.T) {
+		t.Parallel()
+
+		simulator, inbound, socket := NewSimulator(t, simulate.Config{Values: map[string]simulate.ValueConfig{
+			"t_dhw": {Value: "48.5"},
+		}})
+
+		startAndRun(t, simulator, func() {
+			inbound <- canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA, 0x00, 0x0E, 0x00, 0x00}}
+			frame := readWithTimeout(t, socket.sent)
+			assert.Equal(t, uint32(0x180), frame.ID)
+			assert.Equal(t, []byte{0x32, 0x10, 0xFA, 0x00, 0x0E, 0x01, 0xE5}, frame.Data, "485 is 48.5 with divisor 10")
+		})
+	})
+
+	t.Run("pads answers to short registers", func(t *testing.T) {
+		t.Parallel()
+
+		simulator, inbound, socket := NewSimulator(t, simulate.Config{Values: map[string]simulate.ValueConfig{
+			"mode": {Value: "heating"},
+		}})
+
+		startAndRun(t, simulator, func() {
+			inbound <- canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}}
+			frame := readWithTimeout(t, socket.sent)
+			assert.Equal(t, []byte{0x32, 0x10, 0x0E, 0x00, 0x01, 0x00, 0x00}, frame.Data)
+		})
+	})
+
+	t.Run("accepts writes of writable commands", func(t *testing.T) {
+		t.Parallel()
+
+		simulator, inbound, socket := NewSimulator(t, simulate.Config{})
+
+		startAndRun(t, simulator, func() {
+			inbound <- canbus.Frame{ID: 0x190, Data: []byte{0x30, 0x00, 0xFA, 0x00, 0x0E, 0x02, 0x08}}
+			inbound <- canbus.Frame{ID: 0x190, Data: []byte{0x31, 0x00, 0xFA, 0x00, 0x0E, 0x00, 0x00}}
+			frame := readWithTimeout(t, socket.sent)
+			assert.Equal(t, []byte{0x32, 0x10, 0xFA, 0x00, 0x0E, 0x02, 0x08}, frame.Data, "the written value should be answered")
+		})
+	})
+
+	t.Run("rejects configuration of unknown commands", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := simulate.NewSimulator(&socketStub{}, nil, commands(), simulate.Config{Values: map[string]simulate.ValueConfig{
+			"unknown": {Value: "1"},
+		}}, zap.NewNop())
+		assert.Error(t, err)
+	})
+}
+
+func TestCurve(t *testing.T) {
+	t.Run("interpolates between points and wraps around midnight", func(t *testing.T) {
+		t.Parallel()
+
+		curve, err := simulate.ParseCurve(map[string]float64{"06:00": -5, "18:00": 7})
+		assert.NoError(t, err)
+
+		assert.InDelta(t, -5, curve.At(at(6, 0)), 0.001)
+		assert.InDelta(t, 1, curve.At(at(12, 0)), 0.001)
+		assert.InDelta(t, 1, curve.At(at(0, 0)), 0.001, "midnight is half way from 18:00 to 06:00")
+		assert.InDelta(t, 4, curve.At(at(21, 0)), 0.001)
+	})
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2022, 12, 11, hour, minute, 0, 0, time.Local)
+}
+
+func commands() []conf.Command {
+	return []conf.Command{
+		{
+			Id:       "t_dhw",
+			Request:  conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x00, 0x0E, 0x00, 0x00}},
+			Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x00, 0x0E}},
+			Divisor:  10,
+			Writable: true,
+			Unit:     conf.UnitDeg,
+			Type:     conf.TypeFloat,
+		},
+		{
+			Id:        "mode",
+			Request:   conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}},
+			Response:  conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}},
+			Type:      conf.TypeValue,
+			ValueCode: map[string]int{"standby": 0, "heating": 1},
+		},
+	}
+}
+
+func NewSimulator(t *testing.T, config simulate.Config) (simulate.Simulator, chan canbus.Frame, *socketStub) {
+	inbound := make(chan canbus.Frame, 2)
+	socket := &socketStub{sent: make(chan canbus.Frame, 2)}
+	simulator, err := simulate.NewSimulator(socket, inbound, commands(), config, zap.NewNop())
+	assert.NoError(t, err)
+	return simulator, inbound, socket
+}
+
+func startAndRun(t *testing.T, simulator simulate.Simulator, f func()) {
+	tmb := simulator.Simulate()
+	f()
+	tmb.Kill(nil)
+	select {
+	case <-tmb.Dead():
+	case <-time.After(time.Second):
+		t.Log("Simulator failed to shut down in 1s")
+	}
+}
+
+func readWithTimeout[T any](t *testing.T, ch <-chan T) *T {
+	select {
+	case value := <-ch:
+		return &value
+	case <-time.After(time.Second):
+		assert.Fail(t, "Simulator failed to send in 1s")
+		var zero T
+		return &zero
+	}
+}
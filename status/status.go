@@ -0,0 +1,181 @@
+// Package status reports the state of the components of the daemon on the health and readiness endpoints.
+package status
+
+import (
+	"encoding/json"
+	"gopkg.in/tomb.v2"
+	"net/http"
+	"time"
+)
+
+// The values of Report.Status.
+const (
+	Ok        = "ok"
+	NotReady  = "not ready"
+	Unhealthy = "unhealthy"
+)
+
+// Component is a go routine of the daemon, named for the report.
+type Component struct {
+	Name string
+	Tomb *tomb.Tomb
+}
+
+// Probe reports the state of a resource, which is not a go routine, f.e. the MQTT connection.
+type Probe struct {
+	Name string
+
+	// State returns the current state, and whether the daemon is ready in this state.
+	State func() (state string, ready bool)
+}
+
+// Tombs returns the tombs of components.
+func Tombs(components []Component) []*tomb.Tomb {
+	result := make([]*tomb.Tomb, len(components))
+	for i, c := range components {
+		result[i] = c.Tomb
+	}
+	return result
+}
+
+// ComponentState is the reported state of a Component or Probe.
+type ComponentState struct {
+	State string `json:"state"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// CommandState is the reported time of the last response to a command.
+type CommandState struct {
+	LastResponse *time.Time `json:"last_response,omitempty"`
+
+	// SecondsSince is the time since the last response, or since the start if there was none.
+	SecondsSince float64 `json:"seconds_since"`
+}
+
+// Report is the body of the health and readiness endpoints.
+type Report struct {
+	Status     string                    `json:"status"`
+	Components map[string]ComponentState `json:"components"`
+	Commands   map[string]CommandState   `json:"commands"`
+
+	// SilentSeconds is the time since the last response to any command, or since the start if there was none.
+	SilentSeconds float64 `json:"silent_seconds"`
+}
+
+// Status serves the health and readiness endpoints.
+type Status interface {
+	// Report returns the current state.
+	Report() Report
+
+	// Health serves 200, while all components run and the bus answered within the maximum silence. Otherwise, 503.
+	Health() http.Handler
+
+	// Readiness serves 200, while the daemon is healthy, and all probes are ready. Otherwise, 503.
+	Readiness() http.Handler
+}
+
+type status struct {
+	components []Component
+	probes     []Probe
+	commands   []string
+	lastValues func() map[string]time.Time
+	maxSilence time.Duration
+	started    time.Time
+}
+
+var _ Status = (*status)(nil)
+
+// NewStatus creates a Status. commands are the ids expected to respond, lastValues returns the time of the last response per command id. A maxSilence of zero disables the silence check.
+func NewStatus(components []Component, probes []Probe, commands []string, lastValues func() map[string]time.Time, maxSilence time.Duration) Status {
+	return &status{
+		components: components,
+		probes:     probes,
+		commands:   commands,
+		lastValues: lastValues,
+		maxSilence: maxSilence,
+		started:    time.Now(),
+	}
+}
+
+func (s *status) Report() Report {
+	now := time.Now()
+	report := Report{
+		Components: make(map[string]ComponentState, len(s.components)+len(s.probes)),
+		Commands:   make(map[string]CommandState, len(s.commands)),
+	}
+
+	healthy, ready := true, true
+	for _, c := range s.components {
+		state := ComponentState{State: "running", Ready: c.Tomb.Alive()}
+		if !state.Ready {
+			state.State = "stopped"
+			if err := c.Tomb.Err(); err != nil && err != tomb.ErrDying && err != tomb.ErrStillAlive {
+				state.Error = err.Error()
+			}
+			healthy = false
+		}
+		report.Components[c.Name] = state
+	}
+	for _, p := range s.probes {
+		state, probeReady := p.State()
+		report.Components[p.Name] = ComponentState{State: state, Ready: probeReady}
+		ready = ready && probeReady
+	}
+
+	lastValues := s.lastValues()
+	var latest time.Time
+	for _, id := range s.commands {
+		at, ok := lastValues[id]
+		if !ok {
+			report.Commands[id] = CommandState{SecondsSince: now.Sub(s.started).Seconds()}
+			continue
+		}
+		report.Commands[id] = CommandState{LastResponse: &at, SecondsSince: now.Sub(at).Seconds()}
+		if at.After(latest) {
+			latest = at
+		}
+	}
+	if latest.IsZero() {
+		latest = s.started
+	}
+	silence := now.Sub(latest)
+	report.SilentSeconds = silence.Seconds()
+	if s.maxSilence > 0 && silence > s.maxSilence {
+		healthy = false
+	}
+
+	switch {
+	case !healthy:
+		report.Status = Unhealthy
+	case !ready:
+		report.Status = NotReady
+	default:
+		report.Status = Ok
+	}
+	return report
+}
+
+func (s *status) Health() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := s.Report()
+		serve(w, report, report.Status != Unhealthy)
+	})
+}
+
+func (s *status) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := s.Report()
+		serve(w, report, report.Status == Ok)
+	})
+}
+
+func serve(w http.ResponseWriter, report Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
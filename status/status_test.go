@@ -0,0 +1,111 @@
+package status_test
+
+import (
+	"echoctl/status"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/tomb.v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	t.Run("is ready while components run and probes are ready", func(t *testing.T) {
+		t.Parallel()
+
+		running := runningTomb(t)
+		s := status.NewStatus([]status.Component{{Name: "poller", Tomb: running}}, []status.Probe{probe("mqtt", "connected", true)}, []string{"t_dhw"}, lastValues("t_dhw", time.Now()), time.Minute)
+
+		code, report := get(t, s.Readiness())
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", report.Status)
+		assert.Equal(t, status.ComponentState{State: "running", Ready: true}, report.Components["poller"])
+		assert.Equal(t, status.ComponentState{State: "connected", Ready: true}, report.Components["mqtt"])
+		assert.NotNil(t, report.Commands["t_dhw"].LastResponse)
+	})
+
+	t.Run("is healthy but not ready while a probe is not ready", func(t *testing.T) {
+		t.Parallel()
+
+		s := status.NewStatus([]status.Component{{Name: "poller", Tomb: runningTomb(t)}}, []status.Probe{probe("mqtt", "disconnected", false)}, nil, lastValues(), 0)
+
+		code, report := get(t, s.Readiness())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "not ready", report.Status)
+		code, _ = get(t, s.Health())
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("is unhealthy after a component died", func(t *testing.T) {
+		t.Parallel()
+
+		died := new(tomb.Tomb)
+		died.Go(func() error { return errors.New("read failed") })
+		<-died.Dead()
+		s := status.NewStatus([]status.Component{{Name: "reader", Tomb: died}}, nil, nil, lastValues(), 0)
+
+		code, report := get(t, s.Health())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, status.ComponentState{State: "stopped", Error: "read failed"}, report.Components["reader"])
+	})
+
+	t.Run("is unhealthy after the bus was silent too long", func(t *testing.T) {
+		t.Parallel()
+
+		s := status.NewStatus(nil, nil, []string{"t_dhw", "mode"}, lastValues("t_dhw", time.Now().Add(-time.Hour)), time.Minute)
+
+		code, report := get(t, s.Health())
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "unhealthy", report.Status)
+		assert.InDelta(t, time.Hour.Seconds(), report.Commands["t_dhw"].SecondsSince, 1)
+		assert.Nil(t, report.Commands["mode"].LastResponse)
+	})
+
+	t.Run("is healthy before the maximum silence elapsed since start", func(t *testing.T) {
+		t.Parallel()
+
+		s := status.NewStatus(nil, nil, []string{"t_dhw"}, lastValues(), time.Minute)
+
+		code, _ := get(t, s.Health())
+		assert.Equal(t, http.StatusOK, code)
+	})
+}
+
+func runningTomb(t *testing.T) *tomb.Tomb {
+	tmb := new(tomb.Tomb)
+	tmb.Go(func() error {
+		<-tmb.Dying()
+		return nil
+	})
+	t.Cleanup(func() {
+		tmb.Kill(nil)
+		_ = tmb.Wait()
+	})
+	return tmb
+}
+
+func probe(name string, state string, ready bool) status.Probe {
+	return status.Probe{Name: name, State: func() (string, bool) { return state, ready }}
+}
+
+// lastValues returns a function returning the pairs of command id and time.
+func lastValues(pairs ...any) func() map[string]time.Time {
+	result := make(map[string]time.Time)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result[pairs[i].(string)] = pairs[i+1].(time.Time)
+	}
+	return func() map[string]time.Time { return result }
+}
+
+func get(t *testing.T, handler http.Handler) (int, status.Report) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	var report status.Report
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, report
+}
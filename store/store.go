@@ -0,0 +1,198 @@
+// Package store keeps the latest value of each command, shared by all consumers of values, so they need not poll again for the current value.
+package store
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"gopkg.in/tomb.v2"
+	"sync"
+	"time"
+)
+
+const (
+	// pendingTimeout is the time after a request, within which a received value is attributed to the request.
+	pendingTimeout = 10 * time.Second
+
+	// staleIntervals is the number of poll intervals without a value, after which the latest value is stale.
+	staleIntervals = 3
+
+	// subscriberBuffer is the number of values buffered per subscriber. Further values are dropped.
+	subscriberBuffer = 100
+)
+
+// Source tells, why a value was received.
+type Source string
+
+const (
+	// SourcePoll is a response to a read request of echoctl.
+	SourcePoll Source = "poll"
+
+	// SourcePassive is a value received without a request of echoctl, e.g. a response to another device on the bus.
+	SourcePassive Source = "passive"
+
+	// SourceWrite is a value read back after a write of echoctl.
+	SourceWrite Source = "write"
+)
+
+// Entry is the latest value of a command.
+type Entry struct {
+	Command conf.Command
+	Raw     int16
+
+	// Value is the decoded raw value, see conf.Command.Decode.
+	Value  any
+	At     time.Time
+	Source Source
+
+	// Stale is set, if no value was received for staleIntervals poll intervals of the command. Values of commands, which are not polled, never become stale.
+	Stale bool
+}
+
+type pending struct {
+	source Source
+	at     time.Time
+}
+
+type store struct {
+	inbound     <-chan dispatcher.CommandValue
+	tomb        *tomb.Tomb
+	intervals   map[string]time.Duration
+	mutex       sync.Mutex
+	entries     map[string]Entry
+	pending     map[string]pending
+	subscribers map[chan Entry]struct{}
+}
+
+// Store keeps the latest received value per command, taken from a dispatcher.Sink. A value is attributed to the request of the command sent before, or is passive. It is the can.RequestListener of the Poller and the Requester. Safe for concurrent use.
+type Store interface {
+	// Record starts storing the values of the sink. It can be called again after the returned tomb died, to restart recording.
+	Record() *tomb.Tomb
+
+	// Put stores value, as if received from the sink.
+	Put(value dispatcher.CommandValue)
+
+	// RequestSent notes a sent read request of command, or a write request, if write is set. The next value of command is attributed to it.
+	RequestSent(command conf.Command, write bool)
+
+	// Get returns the latest value of the command with id, and false, if no value was received yet.
+	Get(id string) (Entry, bool)
+
+	// All returns the latest values by command id.
+	All() map[string]Entry
+
+	// Subscribe returns a channel receiving every new value, and a function cancelling the subscription. It never blocks the sender: values are dropped for slow subscribers.
+	Subscribe() (<-chan Entry, func())
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore creates a Store of the values received from inbound. intervals are the poll intervals by command id, deciding when values are stale.
+func NewStore(intervals map[string]time.Duration, inbound <-chan dispatcher.CommandValue) Store {
+	return &store{
+		inbound:     inbound,
+		intervals:   intervals,
+		entries:     make(map[string]Entry),
+		pending:     make(map[string]pending),
+		subscribers: make(map[chan Entry]struct{}),
+	}
+}
+
+func (s *store) Record() *tomb.Tomb {
+	s.tomb = new(tomb.Tomb)
+	s.tomb.Go(s.record)
+	return s.tomb
+}
+
+func (s *store) record() error {
+	for {
+		select {
+		case value := <-s.inbound:
+			s.Put(value)
+		case <-s.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+func (s *store) RequestSent(command conf.Command, write bool) {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if write {
+		s.pending[command.Id] = pending{source: SourceWrite, at: now}
+		return
+	}
+	// A read following a write reads the written value back.
+	if p, ok := s.pending[command.Id]; ok && p.source == SourceWrite && now.Sub(p.at) <= pendingTimeout {
+		return
+	}
+	s.pending[command.Id] = pending{source: SourcePoll, at: now}
+}
+
+func (s *store) Put(value dispatcher.CommandValue) {
+	at := value.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	command := value.Cmd
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	source := SourcePassive
+	// A value received before the request is no answer to it, but was queued in the sink while the request was sent.
+	if p, ok := s.pending[command.Id]; ok && !at.Before(p.at) {
+		if at.Sub(p.at) <= pendingTimeout {
+			source = p.source
+		}
+		delete(s.pending, command.Id)
+	}
+	entry := Entry{Command: command, Raw: value.Value, Value: command.Decode(value.Value), At: at, Source: source}
+	s.entries[command.Id] = entry
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- entry:
+		default:
+		}
+	}
+}
+
+func (s *store) Get(id string) (Entry, bool) {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return s.withStale(entry, now), true
+}
+
+func (s *store) All() map[string]Entry {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make(map[string]Entry, len(s.entries))
+	for id, entry := range s.entries {
+		result[id] = s.withStale(entry, now)
+	}
+	return result
+}
+
+func (s *store) Subscribe() (<-chan Entry, func()) {
+	entries := make(chan Entry, subscriberBuffer)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers[entries] = struct{}{}
+	return entries, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.subscribers, entries)
+	}
+}
+
+// withStale returns entry with Stale set, if it is older than staleIntervals poll intervals at now.
+func (s *store) withStale(entry Entry, now time.Time) Entry {
+	if interval, ok := s.intervals[entry.Command.Id]; ok && interval > 0 {
+		entry.Stale = now.Sub(entry.At) > staleIntervals*interval
+	}
+	return entry
+}
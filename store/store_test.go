@@ -0,0 +1,127 @@
+package store_test
+
+import (
+	"echoctl/conf"
+	"echoctl/dispatcher"
+	"echoctl/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var (
+	tDhw = conf.Command{Id: "t_dhw", Type: conf.TypeFloat, Divisor: 10}
+	mode = conf.Command{Id: "mode", Type: conf.TypeValue, ValueCode: map[string]int{"standby": 0, "heating": 1}}
+)
+
+func TestStore(t *testing.T) {
+	t.Run("keeps the latest decoded value per command", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		before := time.Now()
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 480})
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 485})
+		values.Put(dispatcher.CommandValue{Cmd: mode, Value: 1})
+
+		entry, ok := values.Get("t_dhw")
+		assert.True(t, ok)
+		assert.Equal(t, int16(485), entry.Raw)
+		assert.Equal(t, 48.5, entry.Value)
+		assert.False(t, entry.At.Before(before))
+		assert.Equal(t, "heating", values.All()["mode"].Value)
+		assert.Len(t, values.All(), 2)
+
+		_, ok = values.Get("unknown")
+		assert.False(t, ok)
+	})
+
+	t.Run("attributes values to the requests sent before", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 480})
+		assert.Equal(t, store.SourcePassive, values.All()["t_dhw"].Source)
+
+		values.RequestSent(tDhw, false)
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 481})
+		assert.Equal(t, store.SourcePoll, values.All()["t_dhw"].Source)
+
+		// The request is answered, further values are passive.
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 482})
+		assert.Equal(t, store.SourcePassive, values.All()["t_dhw"].Source)
+
+		values.RequestSent(tDhw, true)
+		values.RequestSent(tDhw, false)
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 500})
+		assert.Equal(t, store.SourceWrite, values.All()["t_dhw"].Source)
+	})
+
+	t.Run("marks values stale after missing polls", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(map[string]time.Duration{"t_dhw": 10 * time.Millisecond}, nil)
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 480})
+		values.Put(dispatcher.CommandValue{Cmd: mode, Value: 1})
+		entry, _ := values.Get("t_dhw")
+		assert.False(t, entry.Stale)
+
+		time.Sleep(50 * time.Millisecond)
+		entry, _ = values.Get("t_dhw")
+		assert.True(t, entry.Stale)
+		entry, _ = values.Get("mode")
+		assert.False(t, entry.Stale, "values of commands, which are not polled, never become stale")
+	})
+
+	t.Run("sends new values to subscribers", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		entries, cancel := values.Subscribe()
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 485})
+		select {
+		case entry := <-entries:
+			assert.Equal(t, 48.5, entry.Value)
+		case <-time.After(time.Second):
+			t.Fatal("no value received")
+		}
+
+		cancel()
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 490})
+		assert.Empty(t, entries)
+	})
+
+	t.Run("records the values of the sink", func(t *testing.T) {
+		t.Parallel()
+
+		inbound := make(chan dispatcher.CommandValue)
+		values := store.NewStore(nil, inbound)
+		entries, cancel := values.Subscribe()
+		defer cancel()
+		tmb := values.Record()
+		defer tmb.Kill(nil)
+
+		at := time.Now().Add(-time.Second)
+		inbound <- dispatcher.CommandValue{Cmd: tDhw, Value: 485, At: at}
+		select {
+		case entry := <-entries:
+			assert.Equal(t, 48.5, entry.Value)
+			assert.Equal(t, at, entry.At, "the time the frame was received")
+		case <-time.After(time.Second):
+			t.Fatal("no value received")
+		}
+	})
+
+	t.Run("does not attribute values received before the request", func(t *testing.T) {
+		t.Parallel()
+
+		values := store.NewStore(nil, nil)
+		before := time.Now()
+		values.RequestSent(tDhw, false)
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 480, At: before.Add(-time.Millisecond)})
+		assert.Equal(t, store.SourcePassive, values.All()["t_dhw"].Source)
+
+		values.Put(dispatcher.CommandValue{Cmd: tDhw, Value: 481})
+		assert.Equal(t, store.SourcePoll, values.All()["t_dhw"].Source, "the request is still pending")
+	})
+}
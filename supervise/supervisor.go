@@ -0,0 +1,128 @@
+// Package supervise restarts failed components according to their restart policy, instead of shutting the daemon down.
+package supervise
+
+import (
+	"echoctl/conf"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"time"
+)
+
+const (
+	defaultWindow         = 10 * time.Minute
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+type supervisor struct {
+	name   string
+	start  func() *tomb.Tomb
+	policy conf.RestartPolicy
+	tomb   *tomb.Tomb
+	log    *zap.Logger
+}
+
+// Supervisor runs a component, and restarts it according to a conf.RestartPolicy.
+type Supervisor interface {
+	// Supervise starts the component. The returned tomb dies, when the component exited and the policy allows no further restart, or when it is killed. It dies with the error of the component.
+	Supervise() *tomb.Tomb
+}
+
+var _ Supervisor = (*supervisor)(nil)
+
+// NewSupervisor creates a Supervisor of the component name. start starts the component, and is called again on every restart. Zero durations of policy are replaced by defaults.
+func NewSupervisor(name string, start func() *tomb.Tomb, policy conf.RestartPolicy, log *zap.Logger) Supervisor {
+	if policy.Restart == "" {
+		policy.Restart = conf.RestartPermanent
+	}
+	if policy.Window <= 0 {
+		policy.Window = defaultWindow
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultInitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	return &supervisor{
+		name:   name,
+		start:  start,
+		policy: policy,
+		tomb:   new(tomb.Tomb),
+		log:    log,
+	}
+}
+
+func (s *supervisor) Supervise() *tomb.Tomb {
+	s.tomb.Go(s.supervise)
+	return s.tomb
+}
+
+func (s *supervisor) supervise() error {
+	// restarts are the times of the restarts within the window.
+	var restarts []time.Time
+	for {
+		child := s.start()
+		select {
+		case <-child.Dead():
+		case <-s.tomb.Dying():
+			child.Kill(nil)
+			<-child.Dead()
+			return tomb.ErrDying
+		}
+
+		err := child.Err()
+		if err == nil && s.policy.Restart == conf.RestartTransient {
+			return nil
+		}
+		if s.policy.MaxRestarts == 0 {
+			return err
+		}
+		now := time.Now()
+		restarts = since(restarts, now.Add(-s.policy.Window))
+		if len(restarts) >= s.policy.MaxRestarts {
+			return fmt.Errorf("%s: giving up after %d restarts within %v: %w", s.name, len(restarts), s.policy.Window, exitError(err))
+		}
+
+		backoff := s.backoff(len(restarts))
+		s.log.Warn("restarting", zap.String("component", s.name), zap.Error(err), zap.Int("restarts", len(restarts)+1), zap.Duration("backoff", backoff))
+		select {
+		case <-time.After(backoff):
+		case <-s.tomb.Dying():
+			return tomb.ErrDying
+		}
+		restarts = append(restarts, now)
+	}
+}
+
+// backoff returns the initial backoff, doubled for every previous restart, limited to the max backoff.
+func (s *supervisor) backoff(previousRestarts int) time.Duration {
+	backoff := s.policy.InitialBackoff
+	for i := 0; i < previousRestarts && backoff < s.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.policy.MaxBackoff {
+		return s.policy.MaxBackoff
+	}
+	return backoff
+}
+
+// since returns the times after start.
+func since(times []time.Time, start time.Time) []time.Time {
+	for len(times) > 0 && !times[0].After(start) {
+		times = times[1:]
+	}
+	return times
+}
+
+// exitError returns err, or an error describing the unexpected exit of a component, if err is nil.
+func exitError(err error) error {
+	if err == nil {
+		return fmt.Errorf("exited")
+	}
+	return err
+}
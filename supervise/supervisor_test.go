@@ -0,0 +1,124 @@
+package supervise_test
+
+import (
+	"echoctl/conf"
+	"echoctl/supervise"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = conf.RestartPolicy{MaxRestarts: 2, Window: time.Minute, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestSupervisor(t *testing.T) {
+	t.Run("restarts a failed component", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{fail: 1}
+		tmb := supervise.NewSupervisor("test", c.start, testPolicy, zap.NewNop()).Supervise()
+
+		assert.Eventually(t, func() bool { return c.starts.Load() == 2 }, time.Second, time.Millisecond)
+		assert.True(t, tmb.Alive())
+		tmb.Kill(nil)
+		waitDead(t, tmb)
+		assert.NoError(t, tmb.Err())
+	})
+
+	t.Run("gives up after the max restarts within the window", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{fail: 10}
+		tmb := supervise.NewSupervisor("test", c.start, testPolicy, zap.NewNop()).Supervise()
+
+		waitDead(t, tmb)
+		assert.ErrorIs(t, tmb.Err(), errFailed)
+		assert.EqualError(t, tmb.Err(), "test: giving up after 2 restarts within 1m0s: failed")
+		assert.Equal(t, int32(3), c.starts.Load())
+	})
+
+	t.Run("does not restart without max restarts", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{fail: 10}
+		tmb := supervise.NewSupervisor("test", c.start, conf.RestartPolicy{}, zap.NewNop()).Supervise()
+
+		waitDead(t, tmb)
+		assert.Equal(t, errFailed, tmb.Err())
+		assert.Equal(t, int32(1), c.starts.Load())
+	})
+
+	t.Run("does not restart a transient component, which exited without error", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{exit: true}
+		policy := testPolicy
+		policy.Restart = conf.RestartTransient
+		tmb := supervise.NewSupervisor("test", c.start, policy, zap.NewNop()).Supervise()
+
+		waitDead(t, tmb)
+		assert.NoError(t, tmb.Err())
+		assert.Equal(t, int32(1), c.starts.Load())
+	})
+
+	t.Run("restarts a permanent component, which exited without error", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{exit: true}
+		tmb := supervise.NewSupervisor("test", c.start, testPolicy, zap.NewNop()).Supervise()
+
+		waitDead(t, tmb)
+		assert.EqualError(t, tmb.Err(), "test: giving up after 2 restarts within 1m0s: exited")
+		assert.Equal(t, int32(3), c.starts.Load())
+	})
+
+	t.Run("kills the component when killed", func(t *testing.T) {
+		t.Parallel()
+
+		c := component{}
+		tmb := supervise.NewSupervisor("test", c.start, testPolicy, zap.NewNop()).Supervise()
+		assert.Eventually(t, func() bool { return c.starts.Load() == 1 }, time.Second, time.Millisecond)
+
+		tmb.Kill(nil)
+		waitDead(t, tmb)
+		assert.False(t, c.current.Load().Alive())
+	})
+}
+
+var errFailed = errors.New("failed")
+
+// component fails on its first fail starts, or exits without error if exit is set. Otherwise, it runs until killed.
+type component struct {
+	fail    int32
+	exit    bool
+	starts  atomic.Int32
+	current atomic.Pointer[tomb.Tomb]
+}
+
+func (c *component) start() *tomb.Tomb {
+	tmb := new(tomb.Tomb)
+	c.current.Store(tmb)
+	start := c.starts.Add(1)
+	tmb.Go(func() error {
+		switch {
+		case start <= c.fail:
+			return errFailed
+		case c.exit:
+			return nil
+		}
+		<-tmb.Dying()
+		return tomb.ErrDying
+	})
+	return tmb
+}
+
+func waitDead(t *testing.T, tmb *tomb.Tomb) {
+	select {
+	case <-tmb.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("Supervisor did not exit in 1s")
+	}
+}
@@ -0,0 +1,172 @@
+// Package systemd notifies systemd about the state of the daemon, see sd_notify(3).
+package systemd
+
+import (
+	"echoctl/status"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/tomb.v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusInterval is the interval the status is checked, if the watchdog is disabled or has a longer interval.
+const statusInterval = 5 * time.Second
+
+type notifier struct {
+	socket     string
+	watchdog   time.Duration
+	maxSilence time.Duration
+	report     func() status.Report
+	tomb       *tomb.Tomb
+	log        *zap.Logger
+}
+
+// Notifier sends READY=1, once the daemon is ready and the first command was answered, STATUS= lines with a summary of the status.Report, and WATCHDOG=1 pings while the daemon is healthy. A hung daemon, which does not receive responses any more, stops pinging after the maximum silence.
+type Notifier interface {
+	Notify() *tomb.Tomb
+}
+
+var _ Notifier = (*notifier)(nil)
+
+// NewNotifier creates a Notifier for the NOTIFY_SOCKET, WATCHDOG_USEC and WATCHDOG_PID variables of environ (`NAME=value` entries, like os.Environ). Without NOTIFY_SOCKET, the Notifier does nothing. maxSilence is the time without any response, after which the watchdog is not pinged any more. If it is zero, the watchdog timeout is used.
+func NewNotifier(environ []string, report func() status.Report, maxSilence time.Duration, log *zap.Logger) Notifier {
+	variables := make(map[string]string)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		variables[name] = value
+	}
+	watchdog := watchdogInterval(variables["WATCHDOG_USEC"], variables["WATCHDOG_PID"], log)
+	if watchdog > 0 && maxSilence <= 0 {
+		// Otherwise, a hung pipeline keeps pinging the watchdog.
+		maxSilence = watchdog
+		log.Warn("systemd: http.max-silence is not set, using the watchdog timeout", zap.Duration("max-silence", maxSilence))
+	}
+	return &notifier{
+		socket:     variables["NOTIFY_SOCKET"],
+		watchdog:   watchdog,
+		maxSilence: maxSilence,
+		report:     report,
+		tomb:       new(tomb.Tomb),
+		log:        log,
+	}
+}
+
+// watchdogInterval returns the timeout of the watchdog in usec, or zero if it is disabled or meant for another process.
+func watchdogInterval(usec string, pid string, log *zap.Logger) time.Duration {
+	if usec == "" {
+		return 0
+	}
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		log.Warn("systemd: ignoring invalid WATCHDOG_USEC", zap.String("value", usec))
+		return 0
+	}
+	return time.Duration(n) * time.Microsecond
+}
+
+func (n *notifier) Notify() *tomb.Tomb {
+	n.tomb.Go(n.notify)
+	return n.tomb
+}
+
+func (n *notifier) notify() error {
+	if n.socket == "" {
+		<-n.tomb.Dying()
+		return tomb.ErrDying
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath(n.socket), Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("systemd: %w", err)
+	}
+	defer conn.Close()
+
+	// Ping the watchdog twice per timeout, as recommended by sd_watchdog_enabled(3).
+	interval := statusInterval
+	if n.watchdog > 0 && n.watchdog/2 < interval {
+		interval = n.watchdog / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := false
+	lastStatus := ""
+	for {
+		report := n.report()
+		var lines []string
+		if !ready && isReady(report) {
+			ready = true
+			lines = append(lines, "READY=1")
+			n.log.Info("systemd: ready")
+		}
+		if s := summary(report); s != lastStatus {
+			lastStatus = s
+			lines = append(lines, "STATUS="+s)
+		}
+		if n.watchdog > 0 && n.isAlive(report) {
+			lines = append(lines, "WATCHDOG=1")
+		}
+		if len(lines) > 0 {
+			if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+				n.log.Warn("systemd: notifying", zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-n.tomb.Dying():
+			_, _ = conn.Write([]byte("STOPPING=1"))
+			return tomb.ErrDying
+		}
+	}
+}
+
+// isAlive returns true, if report is not unhealthy, and a command was answered within the maximum silence.
+func (n *notifier) isAlive(report status.Report) bool {
+	silence := time.Duration(report.SilentSeconds * float64(time.Second))
+	return report.Status != status.Unhealthy && (n.maxSilence <= 0 || silence <= n.maxSilence)
+}
+
+// socketPath returns the path of the NOTIFY_SOCKET. A leading `@` is an abstract socket.
+func socketPath(socket string) string {
+	if strings.HasPrefix(socket, "@") {
+		return "\x00" + socket[1:]
+	}
+	return socket
+}
+
+// isReady returns true, if all components are ready, and at least one command was answered.
+func isReady(report status.Report) bool {
+	return report.Status == status.Ok && answered(report) > 0
+}
+
+func answered(report status.Report) int {
+	count := 0
+	for _, command := range report.Commands {
+		if command.LastResponse != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// summary is the STATUS= line of report, f.e. `ok, 12/14 commands answered, silent for 3s`, followed by the components, which are not ready.
+func summary(report status.Report) string {
+	s := fmt.Sprintf("%s, %d/%d commands answered, silent for %v", report.Status, answered(report), len(report.Commands), time.Duration(report.SilentSeconds*float64(time.Second)).Round(time.Second))
+	names := maps.Keys(report.Components)
+	slices.Sort(names)
+	for _, name := range names {
+		if component := report.Components[name]; !component.Ready {
+			s += fmt.Sprintf("; %s %s", name, component.State)
+		}
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package systemd_test
+
+import (
+	"echoctl/status"
+	"echoctl/systemd"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Run("notifies ready once a command was answered, and pings the watchdog", func(t *testing.T) {
+		t.Parallel()
+
+		socket, path := listen(t)
+		report := newReport(status.Report{Status: status.NotReady, Commands: map[string]status.CommandState{"t_dhw": {}}})
+		tmb := systemd.NewNotifier(environ(path, 20*time.Millisecond, os.Getpid()), report.get, 0, zap.NewNop()).Notify()
+
+		message := receive(t, socket)
+		assert.Contains(t, message, "STATUS=not ready, 0/1 commands answered")
+		assert.Contains(t, message, "WATCHDOG=1")
+		assert.NotContains(t, message, "READY=1")
+
+		now := time.Now()
+		report.set(status.Report{Status: status.Ok, Commands: map[string]status.CommandState{"t_dhw": {LastResponse: &now}}})
+		message = receiveContaining(t, socket, "READY=1")
+		assert.Contains(t, message, "STATUS=ok, 1/1 commands answered, silent for 0s")
+
+		tmb.Kill(nil)
+		receiveContaining(t, socket, "STOPPING=1")
+		waitDead(t, tmb)
+	})
+
+	t.Run("stops pinging the watchdog while unhealthy", func(t *testing.T) {
+		t.Parallel()
+
+		socket, path := listen(t)
+		report := newReport(status.Report{
+			Status:     status.Unhealthy,
+			Components: map[string]status.ComponentState{"reader": {State: "stopped"}, "poller": {State: "running", Ready: true}},
+		})
+		tmb := systemd.NewNotifier(environ(path, 20*time.Millisecond, os.Getpid()), report.get, 0, zap.NewNop()).Notify()
+		defer tmb.Kill(nil)
+
+		assert.Equal(t, "STATUS=unhealthy, 0/0 commands answered, silent for 0s; reader stopped", receive(t, socket))
+		_ = socket.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, 1024)
+		_, err := socket.Read(buf)
+		assert.Error(t, err, "no further message expected, got %q", string(buf))
+	})
+
+	t.Run("stops pinging the watchdog after the watchdog timeout without max-silence", func(t *testing.T) {
+		t.Parallel()
+
+		socket, path := listen(t)
+		report := newReport(status.Report{Status: status.Ok, SilentSeconds: 1})
+		tmb := systemd.NewNotifier(environ(path, 20*time.Millisecond, os.Getpid()), report.get, 0, zap.NewNop()).Notify()
+		defer tmb.Kill(nil)
+
+		assert.NotContains(t, receive(t, socket), "WATCHDOG=1", "A silent bus should not be reported as alive")
+	})
+
+	t.Run("ignores the watchdog of another process", func(t *testing.T) {
+		t.Parallel()
+
+		socket, path := listen(t)
+		report := newReport(status.Report{Status: status.Ok})
+		tmb := systemd.NewNotifier(environ(path, 20*time.Millisecond, os.Getpid()+1), report.get, 0, zap.NewNop()).Notify()
+		defer tmb.Kill(nil)
+
+		assert.NotContains(t, receive(t, socket), "WATCHDOG=1")
+	})
+
+	t.Run("does nothing without notify socket", func(t *testing.T) {
+		t.Parallel()
+
+		tmb := systemd.NewNotifier(nil, newReport(status.Report{}).get, 0, zap.NewNop()).Notify()
+		assert.True(t, tmb.Alive())
+		tmb.Kill(nil)
+		waitDead(t, tmb)
+	})
+}
+
+type report struct {
+	mutex  sync.Mutex
+	report status.Report
+}
+
+func newReport(r status.Report) *report {
+	return &report{report: r}
+}
+
+func (r *report) get() status.Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.report
+}
+
+func (r *report) set(report status.Report) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.report = report
+}
+
+func environ(path string, watchdog time.Duration, pid int) []string {
+	return []string{
+		"NOTIFY_SOCKET=" + path,
+		"WATCHDOG_USEC=" + strconv.FormatInt(watchdog.Microseconds(), 10),
+		"WATCHDOG_PID=" + strconv.Itoa(pid),
+	}
+}
+
+func listen(t *testing.T) (*net.UnixConn, string) {
+	path := filepath.Join(t.TempDir(), "notify")
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Close() })
+	return socket, path
+}
+
+func receive(t *testing.T, socket *net.UnixConn) string {
+	_ = socket.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := socket.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+// receiveContaining receives messages until one contains expected.
+func receiveContaining(t *testing.T, socket *net.UnixConn, expected string) string {
+	for {
+		if message := receive(t, socket); strings.Contains(message, expected) {
+			return message
+		}
+	}
+}
+
+func waitDead(t *testing.T, tmb *tomb.Tomb) {
+	select {
+	case <-tmb.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("Notifier did not exit in 1s")
+	}
+}
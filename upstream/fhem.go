@@ -0,0 +1,222 @@
+package upstream
+
+import (
+	"echoctl/conf"
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// ReadFHEM reads the command table of an FHEM module, a Perl hash of hashes like:
+//
+//	my %HPSU_commands = (
+//	  t_dhw => { cmd => "31 00 0E 00 00 00 00", type => "float", unit => "deg", div => 10, rw => 0,
+//	             name => "Warmwasser-Temperatur" },
+//	  mode  => { cmd => "31 00 FA 01 12 00 00", type => "value", value_code => { 1 => "heating", 2 => "cooling" } },
+//	);
+//
+// Every nested hash with a `cmd` or `command` key is an entry, named by its key. The fields are the same as the columns of ReadPyHPSU, `name` is the label. Code outside the first hash, f.e. the rest of the module, is ignored.
+func ReadFHEM(r io.Reader, options Options) (map[string]conf.Command, []Skipped, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := perlParser{tokens: tokenizePerl(string(source))}
+	if !p.skipToHash() {
+		return nil, nil, fmt.Errorf("no hash found")
+	}
+	table, err := p.value()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []entry
+	collectFHEMEntries(table, &entries)
+	commands, skipped := toCommands(entries, options)
+	return commands, skipped, nil
+}
+
+// collectFHEMEntries walks the hashes in value, and collects those with a command.
+func collectFHEMEntries(value any, entries *[]entry) {
+	hash, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	keys := maps.Keys(hash)
+	slices.Sort(keys)
+	for _, key := range keys {
+		nested, ok := hash[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := nested["cmd"]; !ok {
+			if _, ok := nested["command"]; !ok {
+				collectFHEMEntries(nested, entries)
+				continue
+			}
+		}
+		e := entry{name: key, position: key, fields: make(map[string]string, len(nested))}
+		for field, fieldValue := range nested {
+			field = strings.ToLower(field)
+			switch v := fieldValue.(type) {
+			case string:
+				e.fields[field] = v
+			case map[string]any:
+				if field == "value_code" || field == "value_codes" || field == "values" {
+					e.valueCode = make(map[string]string, len(v))
+					for code, label := range v {
+						if s, ok := label.(string); ok {
+							e.valueCode[code] = s
+						}
+					}
+				}
+			}
+		}
+		// The name field is the label, the key is the id.
+		if name, ok := e.fields["name"]; ok {
+			delete(e.fields, "name")
+			e.fields["label"] = name
+		}
+		*entries = append(*entries, e)
+	}
+}
+
+type perlParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *perlParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+func (p *perlParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// skipToHash skips to the first assigned hash, `= (` or `= {`.
+func (p *perlParser) skipToHash() bool {
+	for p.pos < len(p.tokens)-1 {
+		if p.tokens[p.pos] == "=" && (p.tokens[p.pos+1] == "(" || p.tokens[p.pos+1] == "{") {
+			p.pos++
+			return true
+		}
+		p.pos++
+	}
+	return false
+}
+
+// value parses a scalar, or a hash or list in `( )`, `{ }` or `[ ]`. Hashes are returned as map[string]any, lists as []any, scalars as string.
+func (p *perlParser) value() (any, error) {
+	token := p.next()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end of input")
+	case "(", "{":
+		closing := map[string]string{"(": ")", "{": "}"}[token]
+		items, err := p.items(closing)
+		if err != nil {
+			return nil, err
+		}
+		hash := make(map[string]any, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			if key, ok := items[i].(string); ok {
+				hash[key] = items[i+1]
+			}
+		}
+		return hash, nil
+	case "[":
+		return p.items("]")
+	case ")", "}", "]", ",", "=>":
+		return nil, fmt.Errorf("unexpected %q", token)
+	default:
+		return unquotePerl(token), nil
+	}
+}
+
+// items parses values separated by `,` or `=>` up to closing.
+func (p *perlParser) items(closing string) ([]any, error) {
+	var items []any
+	for {
+		switch p.peek() {
+		case closing:
+			p.next()
+			return items, nil
+		case ",", "=>":
+			p.next()
+			continue
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// tokenizePerl splits source into quoted strings, `=>`, punctuation and barewords. Comments are dropped.
+func tokenizePerl(source string) []string {
+	var tokens []string
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			if i > len(runes) {
+				i = len(runes)
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '=' && i+1 < len(runes) && runes[i+1] == '>':
+			tokens = append(tokens, "=>")
+			i += 2
+		case strings.ContainsRune("(){}[],;=", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(){}[],;=#\"'", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens
+}
+
+// unquotePerl removes the quotes of a string token, and the escapes of a double quoted string.
+func unquotePerl(token string) string {
+	if len(token) < 2 || (token[0] != '"' && token[0] != '\'') {
+		return token
+	}
+	content := token[1 : len(token)-1]
+	if token[0] == '\'' {
+		return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(content)
+	}
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\t`, "\t").Replace(content)
+}
@@ -0,0 +1,64 @@
+package upstream_test
+
+import (
+	"echoctl/conf"
+	"echoctl/upstream"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReadFHEM(t *testing.T) {
+	t.Run("maps the command table to commands", func(t *testing.T) {
+		t.Parallel()
+
+		commands, skipped, err := upstream.ReadFHEM(strings.NewReader(`package main;
+use strict;
+# Commands of the HPSU, see the forum thread.
+my %HPSU_commands = (
+  t_dhw => { cmd => "31 00 0E 00 00 00 00", type => "float", unit => "deg", div => 10, rw => 0,
+             name => "Warmwasser-Temperatur" },   # T-WW
+  'mode' => {
+    'cmd' => '31 00 FA 01 12 00 00',
+    'type' => 'value',
+    'rw' => 1,
+    'value_code' => { 1 => "heating", 2 => "cooling" },
+  },
+  broken => { cmd => "31", type => "value" },
+);
+
+sub HPSU_Initialize($) {
+  my ($hash) = @_;
+}
+`), upstream.Options{Lang: "de"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"broken: skipped: request 31 carries no register"}, reasons(skipped))
+		assert.Equal(t, map[string]conf.Command{
+			"t_dhw": {
+				Id:       "t_dhw",
+				Name:     map[string]string{"de": "Warmwasser-Temperatur"},
+				Request:  conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}},
+				Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}},
+				Divisor:  10,
+				Unit:     conf.UnitDeg,
+				Type:     conf.TypeFloat,
+			},
+			"mode": {
+				Id:        "mode",
+				Name:      map[string]string{"de": "mode"},
+				Request:   conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x12, 0x00, 0x00}},
+				Response:  conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}},
+				Writable:  true,
+				Type:      conf.TypeValue,
+				ValueCode: map[string]int{"heating": 1, "cooling": 2},
+			},
+		}, commands)
+	})
+
+	t.Run("fails without a hash", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := upstream.ReadFHEM(strings.NewReader("package main;\n"), upstream.Options{Lang: "de"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"echoctl/conf"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadPyHPSU reads a pyHPSU CSV command file, f.e. commands_hpsu_DE.csv. The columns are identified by the header line, so the column order and additional columns don't matter. The delimiter is `;` or `,`, whichever the header uses. Recognised columns:
+//
+//	name            the command id
+//	command         the request, f.e. `31 00 FA 01 D6 00 00`, required
+//	can_id          the CAN ID the request is sent on, default 190
+//	receiver        the CAN ID of the receiver, checked against the request
+//	label[_xx]      the name, without suffix in options.Lang
+//	description[_xx]
+//	unit, type, divisor, writable
+//	value_code      f.e. `0=off|1=on`
+//
+// The response is derived from the request, see propose.ResponseFor.
+func ReadPyHPSU(r io.Reader, options Options) (map[string]conf.Command, []Skipped, error) {
+	buffered := bufio.NewReader(r)
+	peeked, err := buffered.Peek(4096)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if firstLine, _, _ := bytes.Cut(peeked, []byte("\n")); bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("missing header")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+
+	var entries []entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		e := entry{position: fmt.Sprintf("line %d", line), fields: make(map[string]string, len(record))}
+		for i, value := range record {
+			if i < len(columns) {
+				e.fields[columns[i]] = value
+			}
+		}
+		e.name = strings.TrimSpace(e.fields["name"])
+		if e.name == "" {
+			e.name = strings.TrimSpace(e.fields["id"])
+		}
+		// The name column is the id, not the label.
+		delete(e.fields, "name")
+		e.valueCode = parseValueCodes(e.fields["value_code"])
+		entries = append(entries, e)
+	}
+	commands, skipped := toCommands(entries, options)
+	return commands, skipped, nil
+}
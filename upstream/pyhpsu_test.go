@@ -0,0 +1,93 @@
+package upstream_test
+
+import (
+	"echoctl/conf"
+	"echoctl/upstream"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReadPyHPSU(t *testing.T) {
+	t.Run("maps columns to commands", func(t *testing.T) {
+		t.Parallel()
+
+		commands, skipped, err := upstream.ReadPyHPSU(strings.NewReader(`name;command;receiver;label;description_en;unit;type;divisor;writable;value_code
+t_dhw;31 00 0E 00 00 00 00;180;Warmwasser-Temperatur;Hot water temperature;°C;float;10;0;
+mode_01;31 00 FA 01 12 00 00;180;Betriebsart;;;value;;rw;1=heating|2=cooling
+t_ext;3100FA0A0C0000;;Außentemperatur;;deg;;10;;
+`), upstream.Options{Lang: "de"})
+		assert.NoError(t, err)
+		assert.Empty(t, skipped)
+		assert.Equal(t, map[string]conf.Command{
+			"t_dhw": {
+				Id:          "t_dhw",
+				Name:        map[string]string{"de": "Warmwasser-Temperatur"},
+				Description: map[string]string{"en": "Hot water temperature"},
+				Request:     conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x00}},
+				Response:    conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0x0E}},
+				Divisor:     10,
+				Unit:        conf.UnitDeg,
+				Type:        conf.TypeFloat,
+			},
+			"mode_01": {
+				Id:        "mode_01",
+				Name:      map[string]string{"de": "Betriebsart"},
+				Request:   conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x01, 0x12, 0x00, 0x00}},
+				Response:  conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x01, 0x12}},
+				Writable:  true,
+				Type:      conf.TypeValue,
+				ValueCode: map[string]int{"heating": 1, "cooling": 2},
+			},
+			"t_ext": {
+				Id:       "t_ext",
+				Name:     map[string]string{"de": "Außentemperatur"},
+				Request:  conf.RequestCommand{CanId: 0x190, CommandBytes: conf.CommandBytes{0x31, 0x00, 0xFA, 0x0A, 0x0C, 0x00, 0x00}},
+				Response: conf.RequestCommand{CanId: 0x180, CommandBytes: conf.CommandBytes{0x32, 0x10, 0xFA, 0x0A, 0x0C}},
+				Divisor:  10,
+				Unit:     conf.UnitDeg,
+				Type:     conf.TypeFloat,
+			},
+		}, commands)
+	})
+
+	t.Run("reports entries it could not map", func(t *testing.T) {
+		t.Parallel()
+
+		commands, skipped, err := upstream.ReadPyHPSU(strings.NewReader(`name,command,receiver,unit,type,value_code
+# comment
+t_dhw,31 00 0E 00 00 00 00,300,furlong,longint,
+,31 00 0E 00 00 00 00,,,,
+broken,31 0G,,,,
+mode,31 00 FA 01 12 00 00,,,value,heating|cooling=2
+`), upstream.Options{Lang: "en"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"t_dhw", "mode"}, keys(commands))
+		assert.Equal(t, float32(1), commands["t_dhw"].Divisor, "longint defaults to divisor 1")
+		assert.Equal(t, map[string]int{"cooling": 2}, commands["mode"].ValueCode)
+		assert.Equal(t, []string{
+			"t_dhw: receiver 300 doesn't match the request, using 180",
+			"t_dhw: unknown unit \"furlong\"",
+			"t_dhw: no divisor, assuming 1",
+			"line 4: skipped: no name",
+			"broken: skipped: request command \"31 0G\": encoding/hex: invalid byte: U+0047 'G'",
+			"mode: value code heating= has no numeric code",
+		}, reasons(skipped))
+	})
+}
+
+func keys(commands map[string]conf.Command) []string {
+	var result []string
+	for id := range commands {
+		result = append(result, id)
+	}
+	return result
+}
+
+func reasons(skipped []upstream.Skipped) []string {
+	var result []string
+	for _, s := range skipped {
+		result = append(result, s.String())
+	}
+	return result
+}
@@ -0,0 +1,288 @@
+// Package upstream imports command definitions from the register lists maintained by other HPSU projects: the CSV command files of pyHPSU, and the command tables of FHEM modules.
+package upstream
+
+import (
+	"echoctl/conf"
+	"echoctl/propose"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"strconv"
+	"strings"
+)
+
+// defaultRequestCanId is the CAN ID the requests are sent with, if an entry doesn't name it.
+const defaultRequestCanId conf.CanId = 0x190
+
+// Skipped reports an entry, or a field of an entry, which could not be mapped.
+type Skipped struct {
+	// Entry is the name of the entry, or its position in the source, if it has no name.
+	Entry  string
+	Reason string
+}
+
+func (s Skipped) String() string {
+	return fmt.Sprintf("%s: %s", s.Entry, s.Reason)
+}
+
+// Options configure the mapping of entries.
+type Options struct {
+	// Lang is the language of labels and descriptions without language, f.e. the `label` column of pyHPSU's commands_hpsu_DE.csv.
+	Lang string
+}
+
+// entry is an upstream definition, with lower case field names.
+type entry struct {
+	name string
+
+	// position locates the entry in the source, for reports.
+	position  string
+	fields    map[string]string
+	valueCode map[string]string
+}
+
+var (
+	requestFields  = []string{"command", "cmd", "request"}
+	canIdFields    = []string{"can_id", "sender", "sender_id"}
+	receiverFields = []string{"receiver", "receiver_id"}
+	divisorFields  = []string{"divisor", "div", "factor"}
+	writableFields = []string{"writable", "readwrite", "rw", "write"}
+	labelFields    = []string{"label", "name"}
+	descFields     = []string{"description", "desc"}
+)
+
+// units maps the unit spellings of upstream lists to units.
+var units = map[string]conf.Unit{
+	"":        conf.UnitNone,
+	"none":    conf.UnitNone,
+	"deg":     conf.UnitDeg,
+	"°c":      conf.UnitDeg,
+	"c":       conf.UnitDeg,
+	"bar":     conf.UnitBar,
+	"lh":      conf.UnitLh,
+	"l/h":     conf.UnitLh,
+	"percent": conf.UnitPercent,
+	"%":       conf.UnitPercent,
+	"wh":      conf.UnitWh,
+	"kwh":     conf.UnitKwh,
+	"w":       conf.UnitW,
+	"kw":      conf.UnitKw,
+	"sec":     conf.UnitSec,
+	"s":       conf.UnitSec,
+	"min":     conf.UnitMin,
+	"hour":    conf.UnitHour,
+	"h":       conf.UnitHour,
+}
+
+// types maps the type spellings of upstream lists to value types.
+var types = map[string]conf.ValueType{
+	"float":   conf.TypeFloat,
+	"longint": conf.TypeLongint,
+	"int":     conf.TypeLongint,
+	"integer": conf.TypeLongint,
+	"value":   conf.TypeValue,
+	"enum":    conf.TypeValue,
+}
+
+// toCommands maps entries to commands. Entries without a usable request are skipped. Unmappable optional fields are dropped from the command, and reported as well.
+func toCommands(entries []entry, options Options) (map[string]conf.Command, []Skipped) {
+	commands := make(map[string]conf.Command, len(entries))
+	var skipped []Skipped
+	for _, e := range entries {
+		name := e.name
+		if name == "" {
+			name = e.position
+		}
+		command, problems, err := e.toCommand(options)
+		for _, problem := range problems {
+			skipped = append(skipped, Skipped{Entry: name, Reason: problem})
+		}
+		if err != nil {
+			skipped = append(skipped, Skipped{Entry: name, Reason: "skipped: " + err.Error()})
+			continue
+		}
+		if _, ok := commands[command.Id]; ok {
+			skipped = append(skipped, Skipped{Entry: name, Reason: "skipped: duplicate name"})
+			continue
+		}
+		commands[command.Id] = command
+	}
+	return commands, skipped
+}
+
+func (e *entry) field(names []string) string {
+	for _, name := range names {
+		if value, ok := e.fields[name]; ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// toCommand returns an error, if the entry can't be mapped at all, and problems with single fields.
+func (e *entry) toCommand(options Options) (conf.Command, []string, error) {
+	var problems []string
+	if e.name == "" {
+		return conf.Command{}, nil, fmt.Errorf("no name")
+	}
+	request, err := e.request()
+	if err != nil {
+		return conf.Command{}, nil, err
+	}
+	response, ok := propose.ResponseFor(request)
+	if !ok {
+		return conf.Command{}, nil, fmt.Errorf("request %s carries no register", e.field(requestFields))
+	}
+	if receiver := e.field(receiverFields); receiver != "" {
+		if receiverId, err := parseCanId(receiver); err != nil || receiverId != response.CanId {
+			problems = append(problems, fmt.Sprintf("receiver %s doesn't match the request, using %X", receiver, uint32(response.CanId)))
+		}
+	}
+
+	command := conf.Command{
+		Id:          e.name,
+		Name:        e.translations(labelFields, options.Lang),
+		Description: e.translations(descFields, options.Lang),
+		Request:     request,
+		Response:    response,
+	}
+	if len(command.Name) == 0 {
+		command.Name = map[string]string{options.Lang: e.name}
+	}
+	if len(command.Description) == 0 {
+		command.Description = nil
+	}
+
+	unit := strings.ToLower(e.field([]string{"unit"}))
+	if command.Unit, ok = units[unit]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown unit %q", unit))
+	}
+	command.Writable = parseBool(e.field(writableFields))
+	command.ValueCode, problems = e.valueCodes(problems)
+	if divisor := e.field(divisorFields); divisor != "" {
+		d, err := strconv.ParseFloat(divisor, 32)
+		if err != nil || d == 0 {
+			problems = append(problems, fmt.Sprintf("invalid divisor %q", divisor))
+		} else {
+			command.Divisor = float32(d)
+		}
+	}
+
+	valueType := strings.ToLower(e.field([]string{"type"}))
+	if command.Type, ok = types[valueType]; !ok {
+		if valueType != "" {
+			problems = append(problems, fmt.Sprintf("unknown type %q", valueType))
+		}
+		command.Type = guessType(command)
+	}
+	if (command.Type == conf.TypeFloat || command.Type == conf.TypeLongint) && command.Divisor == 0 {
+		problems = append(problems, "no divisor, assuming 1")
+		command.Divisor = 1
+	}
+	return command, problems, nil
+}
+
+func (e *entry) request() (conf.RequestCommand, error) {
+	var request conf.RequestCommand
+	raw := e.field(requestFields)
+	if raw == "" {
+		return request, fmt.Errorf("no request command")
+	}
+	// Both `31 00 FA 01 D6 00 00` and `3100FA01D60000`.
+	bytes, err := hex.DecodeString(strings.ReplaceAll(raw, " ", ""))
+	if err != nil {
+		return request, fmt.Errorf("request command %q: %w", raw, err)
+	}
+	request.CommandBytes = bytes
+	request.CanId = defaultRequestCanId
+	if canId := e.field(canIdFields); canId != "" {
+		if request.CanId, err = parseCanId(canId); err != nil {
+			return request, fmt.Errorf("CAN ID %q: %w", canId, err)
+		}
+	}
+	return request, nil
+}
+
+// parseCanId parses a hex CAN ID, f.e. `190` or `0x190`.
+func parseCanId(value string) (conf.CanId, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 11)
+	return conf.CanId(id), err
+}
+
+// translations collects the fields `<name>` (in lang) and `<name>_<lang>` of the first of names present.
+func (e *entry) translations(names []string, lang string) map[string]string {
+	result := make(map[string]string)
+	for _, name := range names {
+		for key, value := range e.fields {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if key == name {
+				result[lang] = value
+			} else if strings.HasPrefix(key, name+"_") && len(key) == len(name)+3 {
+				result[key[len(name)+1:]] = value
+			}
+		}
+		if len(result) > 0 {
+			break
+		}
+	}
+	return result
+}
+
+// valueCodes orients the value code pairs as label → code. Upstream lists use both orientations.
+func (e *entry) valueCodes(problems []string) (map[string]int, []string) {
+	if len(e.valueCode) == 0 {
+		return nil, problems
+	}
+	result := make(map[string]int, len(e.valueCode))
+	keys := maps.Keys(e.valueCode)
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := strings.TrimSpace(e.valueCode[key])
+		if code, err := strconv.Atoi(value); err == nil {
+			result[key] = code
+		} else if code, err := strconv.Atoi(key); err == nil {
+			result[value] = code
+		} else {
+			problems = append(problems, fmt.Sprintf("value code %s=%s has no numeric code", key, value))
+		}
+	}
+	return result, problems
+}
+
+// parseValueCodes parses value codes in a single field, f.e. `0=off|1=on` or `off:0,on:1`.
+func parseValueCodes(field string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(field, func(r rune) bool { return r == '|' || r == ',' }) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			key, value, _ = strings.Cut(pair, ":")
+		}
+		// A pair without code is kept, to be reported.
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+func guessType(command conf.Command) conf.ValueType {
+	switch {
+	case len(command.ValueCode) > 0:
+		return conf.TypeValue
+	case command.Divisor != 0 && command.Divisor != 1:
+		return conf.TypeFloat
+	default:
+		return conf.TypeLongint
+	}
+}
+
+func parseBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "y", "w", "rw":
+		return true
+	default:
+		return false
+	}
+}
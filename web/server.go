@@ -0,0 +1,73 @@
+// Package web serves the HTTP endpoints of the daemon.
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/tomb.v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+type server struct {
+	listen  string
+	handler http.Handler
+	log     *zap.Logger
+	tomb    *tomb.Tomb
+}
+
+// Server serves handler on an HTTP listener.
+type Server interface {
+	// Serve starts serving. It can be called again after the returned tomb died, to restart serving.
+	Serve() *tomb.Tomb
+}
+
+var _ Server = (*server)(nil)
+
+// NewServer creates a Server listening on listen (`[host]:port`). An empty listen disables the server.
+func NewServer(listen string, handler http.Handler, log *zap.Logger) Server {
+	return &server{
+		listen:  listen,
+		handler: handler,
+		log:     log,
+	}
+}
+
+func (s *server) Serve() *tomb.Tomb {
+	s.tomb = new(tomb.Tomb)
+	s.tomb.Go(s.serve)
+	return s.tomb
+}
+
+func (s *server) serve() error {
+	if s.listen == "" {
+		<-s.tomb.Dying()
+		return tomb.ErrDying
+	}
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	s.log.Info("http: listening", zap.Stringer("addr", listener.Addr()))
+	httpServer := &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Cancel the requests on shutdown, f.e. event streams, which would otherwise delay the shutdown until the timeout.
+		BaseContext: func(net.Listener) context.Context { return s.tomb.Context(context.Background()) },
+	}
+	s.tomb.Go(func() error {
+		<-s.tomb.Dying()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return httpServer.Shutdown(ctx)
+	})
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http: %w", err)
+	}
+	return tomb.ErrDying
+}